@@ -0,0 +1,117 @@
+// Package assertions checks a finished run against the limits of the
+// assertions config section, so CI can fail on a bad capture.
+package assertions
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/pipeline"
+	"github.com/msn60/isotcpdump/report"
+	"github.com/rs/zerolog"
+)
+
+// ExitCode is the status of a run that breaks an assertion; 1 is left to
+// errors and 2 to usage.
+const ExitCode = 3
+
+// Violation is one broken assertion.
+type Violation struct {
+	Name  string `json:"name"` // config key, e.g. "max_p99_latency"
+	Limit string `json:"limit"`
+	Got   string `json:"got"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: want %s, got %s", v.Name, v.Limit, v.Got)
+}
+
+func (v Violation) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("assertion", v.Name).Str("limit", v.Limit).Str("got", v.Got)
+}
+
+// Result lists the assertions checked and the ones broken.
+type Result struct {
+	Checked    []string    `json:"checked"`
+	Violations []Violation `json:"violations"`
+}
+
+func (r *Result) OK() bool { return len(r.Violations) == 0 }
+
+func (r *Result) check(name, limit, got string, ok bool) {
+	r.Checked = append(r.Checked, name)
+	if !ok {
+		r.Violations = append(r.Violations, Violation{Name: name, Limit: limit, Got: got})
+	}
+}
+
+// Check evaluates cfg.Assertions against res. The approval rate comes from
+// res.Decline, built here when the report is off.
+func Check(cfg *config.Config, res *pipeline.Result) *Result {
+	a := cfg.Assertions
+	s := res.Summary
+	r := &Result{}
+
+	if v := strings.TrimSpace(a.MaxP99Latency); v != "" {
+		if limit, err := time.ParseDuration(v); err == nil {
+			got := time.Duration(s.Latency.P99 * float64(time.Second))
+			r.check("max_p99_latency", "<= "+limit.String(), got.Round(time.Microsecond).String(), got <= limit)
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		limit *int
+		got   int
+	}{
+		{"max_orphan_requests", a.MaxOrphanRequests, s.Matcher.OrphanRequests},
+		{"max_orphan_responses", a.MaxOrphanResponses, s.Matcher.OrphanResponses},
+		{"max_timeouts", a.MaxTimeouts, s.Matcher.Timeouts},
+	} {
+		if c.limit != nil {
+			r.check(c.name, "<= "+strconv.Itoa(*c.limit), strconv.Itoa(c.got), c.got <= *c.limit)
+		}
+	}
+	if a.MinMessages != nil {
+		got := s.Messages.Input + s.Messages.Output
+		r.check("min_messages", ">= "+strconv.Itoa(*a.MinMessages), strconv.Itoa(got), got >= *a.MinMessages)
+	}
+	if a.MinApprovalRate > 0 {
+		dec := res.Decline
+		if dec == nil {
+			dec = report.BuildDecline(res.Snapshot, report.OptionsFromConfig(cfg))
+		}
+		limit := ">= " + strconv.FormatFloat(a.MinApprovalRate, 'f', -1, 64)
+		if t := dec.Totals; t.Total == 0 {
+			r.check("min_approval_rate", limit, "no transactions", false)
+		} else {
+			rate := t.ApprovalRate()
+			got := fmt.Sprintf("%.4f (%d of %d)", rate, t.Approved, t.Total)
+			r.check("min_approval_rate", limit, got, rate >= a.MinApprovalRate)
+		}
+	}
+	if a.NoFramingErrors {
+		r.check("no_framing_errors", "0", strconv.FormatInt(s.FramingErrors, 10), s.FramingErrors == 0)
+	}
+	return r
+}
+
+// WriteText lists each violation, or says all assertions held.
+func (r *Result) WriteText(w io.Writer) error {
+	if r.OK() {
+		_, err := fmt.Fprintf(w, "assertions: %d passed\n", len(r.Checked))
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "assertions: %d of %d failed\n", len(r.Violations), len(r.Checked)); err != nil {
+		return err
+	}
+	for _, v := range r.Violations {
+		if _, err := fmt.Fprintf(w, "  FAIL %s\n", v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package assertions_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/msn60/isotcpdump/assertions"
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/pipeline"
+	"github.com/msn60/isotcpdump/report"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+func intp(n int) *int { return &n }
+
+// run is a finished run with 40 input and 40 output messages, a 120ms p99,
+// 2 orphan requests, 1 orphan response, 3 timeouts, 1 framing error and
+// 9 of 10 transactions approved.
+func run() *pipeline.Result {
+	return &pipeline.Result{
+		Snapshot: &stream.IsoStreamResponse{},
+		Decline:  &report.DeclineReport{Totals: report.Counts{Total: 10, Approved: 9, Declined: 1}},
+		Summary: &pipeline.RunSummary{
+			Messages:      pipeline.DirectionCounts{Input: 40, Output: 40},
+			FramingErrors: 1,
+			Matcher:       matcher.Stats{OrphanRequests: 2, OrphanResponses: 1, Timeouts: 3},
+			Latency:       pipeline.LatencyStats{Count: 37, P99: 0.120},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		a    config.Assertions
+		want string // the violated assertion, empty when it holds
+	}{
+		{"p99 pass", config.Assertions{MaxP99Latency: "200ms"}, ""},
+		{"p99 fail", config.Assertions{MaxP99Latency: "100ms"}, "max_p99_latency: want <= 100ms, got 120ms"},
+		{"orphan requests pass", config.Assertions{MaxOrphanRequests: intp(2)}, ""},
+		{"orphan requests fail", config.Assertions{MaxOrphanRequests: intp(1)}, "max_orphan_requests: want <= 1, got 2"},
+		{"orphan responses pass", config.Assertions{MaxOrphanResponses: intp(1)}, ""},
+		{"orphan responses fail", config.Assertions{MaxOrphanResponses: intp(0)}, "max_orphan_responses: want <= 0, got 1"},
+		{"timeouts pass", config.Assertions{MaxTimeouts: intp(3)}, ""},
+		{"timeouts fail", config.Assertions{MaxTimeouts: intp(2)}, "max_timeouts: want <= 2, got 3"},
+		{"messages pass", config.Assertions{MinMessages: intp(80)}, ""},
+		{"messages fail", config.Assertions{MinMessages: intp(81)}, "min_messages: want >= 81, got 80"},
+		{"approval pass", config.Assertions{MinApprovalRate: 0.9}, ""},
+		{"approval fail", config.Assertions{MinApprovalRate: 0.95}, "min_approval_rate: want >= 0.95, got 0.9000 (9 of 10)"},
+		{"framing fail", config.Assertions{NoFramingErrors: true}, "no_framing_errors: want 0, got 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := assertions.Check(&config.Config{Assertions: tt.a}, run())
+			if len(r.Checked) != 1 {
+				t.Fatalf("checked %v, want one", r.Checked)
+			}
+			switch {
+			case tt.want == "" && !r.OK():
+				t.Errorf("violations %v, want none", r.Violations)
+			case tt.want != "" && (len(r.Violations) != 1 || r.Violations[0].String() != tt.want):
+				t.Errorf("violations %v, want %q", r.Violations, tt.want)
+			}
+		})
+	}
+}
+
+// Unset limits are not checked; zero is a limit for the counters.
+func TestCheckUnset(t *testing.T) {
+	r := assertions.Check(&config.Config{}, run())
+	if len(r.Checked) != 0 || !r.OK() {
+		t.Errorf("checked %v, violations %v, want nothing", r.Checked, r.Violations)
+	}
+
+	res := run()
+	res.Summary.Matcher = matcher.Stats{}
+	r = assertions.Check(&config.Config{Assertions: config.Assertions{MaxTimeouts: intp(0)}}, res)
+	if len(r.Checked) != 1 || !r.OK() {
+		t.Errorf("max_timeouts 0: checked %v, violations %v", r.Checked, r.Violations)
+	}
+}
+
+// A run without transactions cannot meet an approval rate, with the report
+// on or off.
+func TestApprovalWithoutTransactions(t *testing.T) {
+	cfg := &config.Config{Assertions: config.Assertions{MinApprovalRate: 0.5}}
+	for name, dec := range map[string]*report.DeclineReport{
+		"report on":  {},
+		"report off": nil,
+	} {
+		res := run()
+		res.Decline = dec
+		r := assertions.Check(cfg, res)
+		if r.OK() || r.Violations[0].Got != "no transactions" {
+			t.Errorf("%s: violations %v, want no transactions", name, r.Violations)
+		}
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	cfg := &config.Config{Assertions: config.Assertions{MaxTimeouts: intp(5), NoFramingErrors: true}}
+	var b bytes.Buffer
+	if err := assertions.Check(cfg, run()).WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "assertions: 1 of 2 failed\n  FAIL no_framing_errors: want 0, got 1\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	cfg.Assertions.NoFramingErrors = false
+	_ = assertions.Check(cfg, run()).WriteText(&b)
+	if !strings.HasPrefix(b.String(), "assertions: 1 passed") {
+		t.Errorf("got %q", b.String())
+	}
+}
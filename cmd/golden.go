@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/msn60/isotcpdump/golden"
+)
+
+// runGolden runs the golden-file cases under -dir, the same check as
+// TestGolden in package pipeline. Exit code: 0 all match (or were
+// updated), 1 mismatches, 2 a case could not run.
+func runGolden(args []string) int {
+	fs := flag.NewFlagSet("golden", flag.ExitOnError)
+	dir := fs.String("dir", filepath.Join("testdata", "golden"), "directory of cases, one config.yaml each")
+	update := fs.Bool("update", false, "rewrite the golden files from this run")
+	only := fs.String("run", "", "only cases whose name contains this")
+	_ = fs.Parse(args)
+
+	cases, err := golden.Cases(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	code, ran := 0, 0
+	for _, c := range cases {
+		if *only != "" && !strings.Contains(filepath.Base(c), *only) {
+			continue
+		}
+		ran++
+		r := golden.Check(c, *update)
+		switch {
+		case r.Err != nil:
+			fmt.Printf("💥 %s: %v\n", r.Name, r.Err)
+			code = 2
+		case len(r.Updated) > 0:
+			fmt.Printf("✏️ %s: updated %s\n", r.Name, strings.Join(r.Updated, ", "))
+		case r.OK():
+			fmt.Printf("✅ %s\n", r.Name)
+		default:
+			fmt.Printf("❌ %s\n", r.Name)
+			for _, m := range r.Mismatches {
+				fmt.Printf("    %s\n", m)
+			}
+			if code == 0 {
+				code = 1
+			}
+		}
+	}
+	if ran == 0 {
+		fmt.Fprintf(os.Stderr, "golden: no case matches %q\n", *only)
+		return 2
+	}
+	return code
+}
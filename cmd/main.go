@@ -1,19 +1,20 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"flag"
+	"io"
 	"log"
 	"os"
 	"strings"
-	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
-	"github.com/google/gopacket/tcpassembly"
+	"github.com/msn60/isotcpdump/assertions"
 	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/pipeline"
 	zrlogger "github.com/msn60/isotcpdump/pkg/zr_logger"
-	"github.com/msn60/isotcpdump/stream"
+	"github.com/rs/zerolog"
 )
 
 func main() {
@@ -26,94 +27,149 @@ func main() {
 	// TODO: check all of logic in zrlogger
 	// TODO: gather all message
 
-	cfg, err := config.Load()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(runValidateConfig(os.Args[2:]))
+		case "verify-audit":
+			os.Exit(runVerifyAudit(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "simulate":
+			os.Exit(runSimulate(os.Args[2:]))
+		case "gen-pcap":
+			os.Exit(runGenPcap(os.Args[2:]))
+		case "golden":
+			os.Exit(runGolden(os.Args[2:]))
+		}
+	}
+	os.Exit(runCapture())
+}
+
+// runCapture is the default command; it returns assertions.ExitCode when
+// the run breaks a configured assertion.
+func runCapture() int {
+	loadOpts := configFlags(flag.CommandLine)
+	flag.Func("assert", "set an assertion, key=value or a bare flag such as no_framing_errors (repeatable)", func(kv string) error {
+		if !strings.Contains(kv, "=") {
+			kv += "=true"
+		}
+		loadOpts.Set = append(loadOpts.Set, "assertions."+kv)
+		return nil
+	})
+	flag.Parse()
+
+	cfg, err := config.LoadWith(*loadOpts)
 	if err != nil {
 		log.Fatalf("config load error: %v", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	opts := zrlogger.OptionsFromConfig(cfg)
 
 	if err := zrlogger.Init(opts); err != nil {
 		log.Fatalf("logger init error: %v", err)
 	}
-	console := zrlogger.Console()
-	file := zrlogger.File()
-
-	app := config.NewApp(cfg).WithLogger(console, file)
-	app.Flogger.Info().Str("version", cfg.App.Version).Msg("application started")
-	app.Clogger.Info().Str("version", cfg.App.Version).Msg("application started")
-	// console.Debug().RawJSON("config", []byte(cfg.Pretty())).Msg("loaded config (dev view)")
+	defer zrlogger.L().Close()
+
+	app := config.NewApp(cfg).WithLogger(zrlogger.L().Logger)
+	app.Log.Info().Str("version", cfg.App.Version).Msg("application started")
+
+	zrlogger.WatchSignals(context.Background())
+	if cfg.Log.Admin.Enable {
+		admin := zrlogger.NewAdmin(zrlogger.L())
+		if err := admin.Start(cfg.Log.Admin.Listen); err != nil {
+			app.Log.Error().Err(err).Str("listen", cfg.Log.Admin.Listen).Msg("log admin listener failed")
+		} else {
+			app.Log.Info().Str("url", "http://"+admin.Addr()+"/log/level").Msg("log admin listening")
+			defer admin.Shutdown(context.Background())
+		}
+	}
+	// console.Debug().Msg("loaded config (dev view)\n" + cfg.Pretty())
 	// config.Print(cfg)
 	// fmt.Println(app)
 
-	pcapPath := strings.TrimSpace(app.Cfg.App.PcapPath)
-
-	if pcapPath == "" {
-		app.Clogger.Fatal().Msg("pcap path is empty in config")
-		os.Exit(1)
-	}
-
-	stream.PrintBytes(cfg)
-
-	// handle, err := pcap.OpenOffline(pcapPath)
-	// if err != nil {
-	// 	app.Clogger.Fatal().Err(err).Str("pcap_path", pcapPath).Msg("failed to open pcap file")
-	// 	os.Exit(1)
-	// }
-	// defer handle.Close()
-	// runWithStreams(app, handle, 1000)
-}
-
-func runWithStreams(app *config.Application, handle *pcap.Handle, maxCSVRows int) {
-	// 1) create packet source
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+	// stream.PrintBytes(cfg)
 
-	// 2) create aggregator & assembler
-	agg := stream.NewAggregator(maxCSVRows)
-	factory := stream.NewFactory(app.Cfg.Network.FWIP, agg)
-
-	pool := tcpassembly.NewStreamPool(factory)
-	assembler := tcpassembly.NewAssembler(pool)
-
-	// 3) counters
-	var totalPackets int
-	var payloadPackets int
-
-	// 4)
-	for pkt := range packetSource.Packets() {
-		totalPackets++
-
-		if pkt.NetworkLayer() == nil || pkt.TransportLayer() == nil {
-			continue
+	var handle *pcap.Handle
+	live := strings.TrimSpace(app.Cfg.App.Interface) != ""
+	if live {
+		iface := strings.TrimSpace(app.Cfg.App.Interface)
+		handle, err = pcap.OpenLive(iface, 65535, true, pcap.BlockForever)
+		if err != nil {
+			app.Log.Fatal().Err(err).Str("interface", iface).Msg("failed to open interface")
+			os.Exit(1)
 		}
-		tcp, ok := pkt.TransportLayer().(*layers.TCP)
-		if !ok {
-			continue
+	} else {
+		pcapPath := strings.TrimSpace(app.Cfg.App.PcapPath)
+		if pcapPath == "" {
+			app.Log.Fatal().Msg("pcap path is empty in config")
+			os.Exit(1)
 		}
-		if len(tcp.Payload) > 0 {
-			payloadPackets++
+		handle, err = pcap.OpenOffline(pcapPath)
+		if err != nil {
+			app.Log.Fatal().Err(err).Str("pcap_path", pcapPath).Msg("failed to open pcap file")
+			os.Exit(1)
 		}
+	}
+	defer handle.Close()
+
+	if f := strings.TrimSpace(app.Cfg.App.BPFFilter); f != "" {
+		if err := handle.SetBPFFilter(f); err != nil {
+			app.Log.Fatal().Err(err).Str("bpf_filter", f).Msg("invalid bpf filter")
+			os.Exit(1)
+		}
+	}
+	var lc *config.Live
+	if live {
+		lc = config.NewLive(cfg, *loadOpts)
+	}
+	source := strings.TrimSpace(app.Cfg.App.PcapPath)
+	if live {
+		source = strings.TrimSpace(app.Cfg.App.Interface)
+	}
+	popts := pipeline.Options{
+		Source:     gopacket.NewPacketSource(handle, handle.LinkType()),
+		SourceName: source,
+		Handle:     handle,
+		Live:       lc,
+		MaxCSVRows: 1000,
+	}
+	if audit := zrlogger.Audit(); audit.GetLevel() != zerolog.Disabled {
+		popts.Audit = &audit
+	}
+	res := pipeline.Run(app, popts)
+	return checkAssertions(app, res, os.Stderr)
+}
 
-		assembler.Assemble(pkt.NetworkLayer().NetworkFlow(), tcp)
+// checkAssertions writes the assertion results of res to w and logs the
+// violations; it returns assertions.ExitCode when one is broken.
+func checkAssertions(app *config.Application, res *pipeline.Result, w io.Writer) int {
+	ar := assertions.Check(app.Cfg, res)
+	if len(ar.Checked) == 0 {
+		return 0
+	}
+	_ = ar.WriteText(w)
+	if ar.OK() {
+		app.Log.Info().Int("checked", len(ar.Checked)).Msg("assertions passed")
+		return 0
 	}
+	for _, v := range ar.Violations {
+		app.Log.Error().EmbedObject(v).Msg("assertion failed")
+	}
+	return assertions.ExitCode
+}
 
-	// 5)
-	assembler.FlushAll()
-	time.Sleep(500 * time.Millisecond)
-
-	// 6)
-	resp := agg.Snapshot()
-
-	// 7)
-	// _ = writeCSV("input_packets.csv", resp.InputRows)
-	// _ = writeCSV("output_packets.csv", resp.OutputRows)
-
-	// 8) final report
-	fmt.Println("✅ Processing complete")
-	fmt.Println("📦 Total packets:", totalPackets)
-	fmt.Println("💾 Packets with payload:", payloadPackets)
-	fmt.Println("📥 Input messages:", resp.TotalInputMessages)
-	fmt.Println("📤 Output messages:", resp.TotalOutputMessages)
-	fmt.Println("📝 Input messages in CSV:", len(resp.InputRows))
-	fmt.Println("📝 Output messages in CSV:", len(resp.OutputRows))
+// configFlags registers -config, -env and -set on fs.
+func configFlags(fs *flag.FlagSet) *config.LoadOptions {
+	o := &config.LoadOptions{}
+	fs.StringVar(&o.Path, "config", "", "base config file (.yaml, .yml or .toml); default $"+config.EnvConfigPath+" or config/config.<CONFIG_FILE_TYPE>")
+	fs.StringVar(&o.Profile, "env", "", "config profile, merges config.<env>.<ext> over the base; default $APP_ENV")
+	fs.Func("set", "override a config key, key=value (repeatable)", func(kv string) error {
+		o.Set = append(o.Set, kv)
+		return nil
+	})
+	return o
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/msn60/isotcpdump/assertions"
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/pipeline"
+	"github.com/msn60/isotcpdump/stream"
+	"github.com/rs/zerolog"
+)
+
+func TestCheckAssertionsExitCode(t *testing.T) {
+	res := &pipeline.Result{
+		Snapshot: &stream.IsoStreamResponse{},
+		Summary:  &pipeline.RunSummary{FramingErrors: 2},
+	}
+	tests := []struct {
+		name   string
+		a      config.Assertions
+		code   int
+		output string
+	}{
+		{"none configured", config.Assertions{}, 0, ""},
+		{"held", config.Assertions{MaxP99Latency: "1s"}, 0, "assertions: 1 passed\n"},
+		{"broken", config.Assertions{NoFramingErrors: true}, assertions.ExitCode,
+			"assertions: 1 of 1 failed\n  FAIL no_framing_errors: want 0, got 2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := config.NewApp(&config.Config{Assertions: tt.a}).WithLogger(zerolog.Nop())
+			var out bytes.Buffer
+			if code := checkAssertions(app, res, &out); code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			if out.String() != tt.output {
+				t.Errorf("output %q, want %q", out.String(), tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/framing"
+	"github.com/msn60/isotcpdump/simulate"
+)
+
+// runSimulate serves as a mock ISO host until interrupted. Exit code: 0
+// stopped cleanly, 2 unusable config or listen address.
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	loadOpts := configFlags(fs)
+	listen := fs.String("listen", "", "host:port to listen on; default simulate.listen")
+	header := fs.String("framing", "", "length header: ascii4|binary2|binary4; default simulate.framing")
+	seed := fs.Int64("seed", 0, "random seed for codes, drops and delays; default simulate.seed")
+	_ = fs.Parse(args)
+
+	cfg, err := config.LoadWith(*loadOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	err = cfg.Validate()
+	if ve, ok := err.(config.ValidationErrors); ok {
+		err = ve.Except("app.pcap_path") // the host does not read a capture
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts := simulate.OptionsFromConfig(cfg)
+	if *header != "" {
+		if opts.Header, err = framing.Parse(*header); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if *seed != 0 {
+		opts.Seed = *seed
+	}
+	addr := strings.TrimSpace(cfg.Simulate.Listen)
+	if *listen != "" {
+		addr = *listen
+	}
+	if addr == "" {
+		fmt.Fprintln(os.Stderr, "simulate: no listen address; set simulate.listen or pass -listen")
+		return 2
+	}
+
+	host := simulate.New(opts)
+	if err := host.Start(addr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("🎭 Simulating ISO host on %s (seed %d, %d rules)\n", host.Addr(), host.Seed(), len(opts.Rules))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = host.Shutdown(sctx)
+	st := host.Stats()
+	fmt.Printf("   connections %d, requests %d, responses %d, dropped %d, unparsable %d\n",
+		st.Connections, st.Requests, st.Responses, st.Dropped, st.Unparsable)
+	return 0
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/msn60/isotcpdump/config"
+)
+
+// runValidateConfig loads the config, prints every problem with its key
+// path and returns the process exit code.
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	loadOpts := configFlags(fs)
+	provenance := fs.Bool("provenance", false, "print every key with the layer that set it")
+	_ = fs.Parse(args)
+
+	cfg, err := config.LoadWith(*loadOpts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *provenance {
+		fmt.Println(cfg.Pretty())
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("✅ config is valid")
+	return 0
+}
@@ -4,26 +4,28 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/knadh/koanf/parsers/toml"
 	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/file"
+	kfs "github.com/knadh/koanf/providers/fs"
+	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
 	"github.com/rs/zerolog"
 )
 
-var (
-	k = koanf.New(".")
-)
-
 type Application struct {
-	Cfg     *Config
-	Clogger zerolog.Logger // console logger
-	Flogger zerolog.Logger // file logger
+	Cfg *Config
+	Log zerolog.Logger // fans out to every log sink
 }
 
 func NewApp(cfg *Config) *Application {
@@ -32,9 +34,8 @@ func NewApp(cfg *Config) *Application {
 	}
 }
 
-func (a *Application) WithLogger(console, file zerolog.Logger) *Application {
-	a.Clogger = console
-	a.Flogger = file
+func (a *Application) WithLogger(l zerolog.Logger) *Application {
+	a.Log = l
 	return a
 }
 
@@ -46,13 +47,32 @@ type Config struct {
 	Limits       Limits       `koanf:"limits"`
 	Log          Log          `koanf:"log"`
 	CrossNetwork CrossNetwork `koanf:"crossnetwork"`
+	Report       Report       `koanf:"report"`
+	Summary      Summary      `koanf:"summary"`
+	Assertions   Assertions   `koanf:"assertions"`
+	Matcher      Matcher      `koanf:"matcher"`
+	Duplicates   Duplicates   `koanf:"duplicates"`
+	Metrics      Metrics      `koanf:"metrics"`
+	Dashboard    Dashboard    `koanf:"dashboard"`
+	Masking      Masking      `koanf:"masking"`
+	SNMP         SNMP         `koanf:"snmp"`
+	Replay       Replay       `koanf:"replay"`
+	Simulate     Simulate     `koanf:"simulate"`
+	Generate     Generate     `koanf:"generate"`
 	EnvVars      map[string]string
+	Sources      map[string]string `json:"-"` // key -> layer that set it
+	Files        []string          `json:"-"` // config files merged, in order
+
+	values   map[string]any   // merged koanf values, for Pretty
+	typeErrs ValidationErrors // file values of the wrong type, see checkTypes
 }
 
 type App struct {
-	Version  string `koanf:"version"`
-	Name     string `koanf:"name"`
-	PcapPath string `koanf:"pcap_path"`
+	Version   string `koanf:"version"`
+	Name      string `koanf:"name"`
+	PcapPath  string `koanf:"pcap_path"`
+	Interface string `koanf:"interface"`  // live capture when set, pcap_path is ignored
+	BPFFilter string `koanf:"bpf_filter"` // e.g. "tcp port 2020"
 }
 
 type Network struct {
@@ -73,6 +93,8 @@ type Output struct {
 	InputCSVPath    string `koanf:"input_csv_path"`
 	OutputCSVPath   string `koanf:"output_csv_path"`
 	DurationsCSV    string `koanf:"durations_csv"`
+	ReportCSV       string `koanf:"report_csv"`    // decline report as CSV, in addition to report.path
+	ReportStatus    bool   `koanf:"report_status"` // write report_csv
 }
 
 type Limits struct {
@@ -86,9 +108,12 @@ type Log struct {
 	Metadata    LogMetadata      `koanf:"metadata"`
 	Console     LogConsole       `koanf:"console"`
 	File        LogFile          `koanf:"file"`
+	Rotation    LogRotation      `koanf:"rotation"`
 	Time        LogTime          `koanf:"time"`
 	System      LogSystem        `koanf:"system"`
 	Sampler     LogSampler       `koanf:"sampler"`
+	Sinks       []LogSink        `koanf:"sinks"` // empty: console and file from the sections above
+	Admin       LogAdmin         `koanf:"admin"`
 	ArraySample []LogArraySample `koanf:"array_sample"`
 }
 
@@ -102,6 +127,8 @@ type LogConsole struct {
 	Enable        bool     `koanf:"enable"`
 	Pipe          bool     `koanf:"pipe"`
 	FieldsExclude []string `koanf:"fields_exclude"`
+	FieldOrder    []string `koanf:"field_order"` // pipe fields printed first, the rest alphabetically
+	Color         string   `koanf:"color"`       // "auto|always|never"; auto colors levels on a terminal
 }
 
 type LogFile struct {
@@ -113,6 +140,35 @@ type LogFile struct {
 	Compress   bool   `koanf:"file_compress"`
 }
 
+// LogRotation adds time based rotation on top of the file size cap.
+type LogRotation struct {
+	Interval string `koanf:"interval"` // "hourly|daily|weekly", empty: size only
+	Count    int    `koanf:"count"`    // period folders kept, 0 keeps all
+}
+
+// LogSink is one output of the application logger.
+type LogSink struct {
+	Name          string   `koanf:"name"`
+	Type          string   `koanf:"type"`    // "console|file|json|syslog|ring|audit"
+	Level         string   `koanf:"level"`   // empty: log.metadata.level
+	Format        string   `koanf:"format"`  // "json|pipe|console"; default pipe for console, json otherwise
+	Path          string   `koanf:"path"`    // file, json, audit; file rotates per log.file and log.rotation
+	Address       string   `koanf:"address"` // syslog: "udp://host:514", "unix:///dev/log"; empty: local
+	Tag           string   `koanf:"tag"`     // syslog
+	Size          int      `koanf:"size"`    // ring entries
+	FieldsInclude []string `koanf:"fields_include"`
+	FieldsExclude []string `koanf:"fields_exclude"`
+	FieldOrder    []string `koanf:"field_order"` // pipe
+	Color         string   `koanf:"color"`       // pipe, console: "auto|always|never"
+}
+
+// LogAdmin serves GET/PUT /log/level to read and change sink levels at
+// runtime. It has no auth, so it only listens on loopback.
+type LogAdmin struct {
+	Enable bool   `koanf:"enable"`
+	Listen string `koanf:"listen"`
+}
+
 type LogTime struct {
 	FieldFormat          string `koanf:"field_format"`        // "RFC3339Nano" or layout
 	DurationFieldUnit    string `koanf:"duration_field_unit"` // "ns|us|ms|s"
@@ -130,7 +186,18 @@ type LogSampler struct {
 	BurstPeriod string `koanf:"burst_period"`
 	NextEveryN  uint32 `koanf:"next_every_n"`
 	BasicN      uint32 `koanf:"basic_n"`
-	Level       string `koanf:"level"`
+	Level       string `koanf:"level"` // highest level the sampler above applies to; empty: all
+
+	Levels         map[string]LogLevelSampler `koanf:"levels"`          // per level, override the sampler above
+	ReportInterval string                     `koanf:"report_interval"` // log dropped counts this often
+}
+
+// LogLevelSampler samples one level: a burst per period, then every Nth.
+type LogLevelSampler struct {
+	Burst       uint32 `koanf:"burst"`
+	BurstPeriod string `koanf:"burst_period"`
+	NextEveryN  uint32 `koanf:"next_every_n"`
+	BasicN      uint32 `koanf:"basic_n"` // without a burst: keep every Nth
 }
 
 type LogArraySample struct {
@@ -141,22 +208,262 @@ type LogArraySample struct {
 }
 
 type CrossNetwork struct {
-	Net []CrossNet `koanf:"net"`
+	Segments []NetSegment `koanf:"segments"`
+	Net      []CrossNet   `koanf:"net"`
+}
+
+// NetSegment names a set of networks; servers are segments of their own.
+type NetSegment struct {
+	Name  string   `koanf:"name"`
+	CIDRs []string `koanf:"cidrs"` // CIDRs or bare IPs
 }
 
+// CrossNet is a path between two segments (or servers), by name.
 type CrossNet struct {
+	Name  string `koanf:"name"` // default "src->dest"
 	Src   string `koanf:"src"`
 	Dest  string `koanf:"dest"`
 	OID   string `koanf:"oid"`
 	Index int    `koanf:"index"`
 }
 
+type Report struct {
+	Enable        bool           `koanf:"enable"`
+	Format        string         `koanf:"format"` // "text|csv|json"
+	Path          string         `koanf:"path"`   // empty: stdout
+	Bucket        string         `koanf:"bucket"` // time bucket, e.g. "1m"
+	ResponseCodes []ResponseCode `koanf:"response_codes"`
+}
+
+// Summary is the end-of-run summary of the default command.
+type Summary struct {
+	Format string `koanf:"format"` // "text|json|yaml"
+	Path   string `koanf:"path"`   // empty: stdout
+}
+
+// Assertions are limits a finished run must meet, for CI; a run that
+// breaks one exits non-zero. Unset limits are not checked.
+type Assertions struct {
+	MaxP99Latency      string  `koanf:"max_p99_latency"` // e.g. "200ms", matched and late responses
+	MaxOrphanRequests  *int    `koanf:"max_orphan_requests"`
+	MaxOrphanResponses *int    `koanf:"max_orphan_responses"`
+	MaxTimeouts        *int    `koanf:"max_timeouts"`
+	MinMessages        *int    `koanf:"min_messages"`      // input and output, duplicates excluded
+	MinApprovalRate    float64 `koanf:"min_approval_rate"` // 0..1, by report.response_codes
+	NoFramingErrors    bool    `koanf:"no_framing_errors"`
+}
+
+type ResponseCode struct {
+	Code        string `koanf:"code"`
+	Description string `koanf:"description"`
+	Outcome     string `koanf:"outcome"` // "approved|declined|timeout"
+}
+
+type Matcher struct {
+	DefaultTimeout string            `koanf:"default_timeout"`
+	Timeouts       map[string]string `koanf:"timeouts"` // MTI class digit -> duration
+	LateWindow     string            `koanf:"late_window"`
+	ReversalWindow string            `koanf:"reversal_window"`
+}
+
+type Duplicates struct {
+	Enable bool   `koanf:"enable"`
+	Window string `koanf:"window"` // capture time a message is remembered
+}
+
+type Metrics struct {
+	Enable         bool      `koanf:"enable"`
+	Listen         string    `koanf:"listen"`
+	Path           string    `koanf:"path"`
+	LatencyBuckets []float64 `koanf:"latency_buckets"` // seconds
+}
+
+type Dashboard struct {
+	Enable   bool   `koanf:"enable"`
+	Listen   string `koanf:"listen"`
+	Hold     bool   `koanf:"hold"`     // keep serving after an offline run until interrupted
+	Interval string `koanf:"interval"` // push period of the live view
+	Recent   int    `koanf:"recent"`   // transactions shown
+}
+
+type SNMP struct {
+	Enable    bool   `koanf:"enable"`
+	Listen    string `koanf:"listen"` // UDP, e.g. "127.0.0.1:1161"
+	Community string `koanf:"community"`
+	RootOID   string `koanf:"root_oid"` // global counters; paths use crossnetwork.net[].oid
+}
+
+type Masking struct {
+	Rules []MaskRule `koanf:"rules"` // empty: built-in defaults
+}
+
+// Replay resends captured messages to a test host, see the replay command.
+type Replay struct {
+	Target          string  `koanf:"target"`    // host:port
+	Speed           float64 `koanf:"speed"`     // 1 keeps capture timing, 2 twice as fast, 0 back to back
+	Framing         string  `koanf:"framing"`   // "ascii4|binary2|binary4"
+	Direction       string  `koanf:"direction"` // "input|output": the captured messages to send
+	DialTimeout     string  `koanf:"dial_timeout"`
+	ResponseTimeout string  `koanf:"response_timeout"` // wait after the last send
+	IgnoreFields    []int   `koanf:"ignore_fields"`    // not compared; default 7, 12, 13
+}
+
+// Simulate is the mock ISO host of the simulate command.
+type Simulate struct {
+	Listen  string    `koanf:"listen"`
+	Framing string    `koanf:"framing"` // "ascii4|binary2|binary4"
+	Seed    int64     `koanf:"seed"`    // codes, drops and delays repeat for a seed; 0: random
+	Delay   SimDelay  `koanf:"delay"`   // default for rules without their own
+	Strip   []int     `koanf:"strip"`   // request fields left out of responses; default 35, 45, 52, 55
+	Rules   []SimRule `koanf:"rules"`   // first match wins; no match answers 00
+}
+
+// SimDelay is a response delay distribution.
+type SimDelay struct {
+	Dist   string `koanf:"dist"` // "fixed|uniform|normal|exponential"
+	Min    string `koanf:"min"`  // fixed value, or lower bound
+	Max    string `koanf:"max"`  // uniform upper bound, and a cap for the others
+	Mean   string `koanf:"mean"` // normal, exponential
+	StdDev string `koanf:"stddev"`
+}
+
+type SimRule struct {
+	MTI         string            `koanf:"mti"`          // empty: any request
+	Match       map[string]string `koanf:"match"`        // field number -> value; a trailing * matches a prefix
+	ResponseMTI string            `koanf:"response_mti"` // default: the request MTI's response
+	Codes       []SimCode         `koanf:"codes"`        // DE39, picked by weight; default 00
+	Drop        float64           `koanf:"drop"`         // share of matching requests left unanswered
+	Delay       SimDelay          `koanf:"delay"`
+}
+
+type SimCode struct {
+	Code   string `koanf:"code"`
+	Weight int    `koanf:"weight"`
+}
+
+// Generate is the synthetic capture of the gen-pcap command: conversations
+// from every other enabled server to network.fw_ip.
+type Generate struct {
+	Output      string    `koanf:"output"`
+	Seed        int64     `koanf:"seed"`     // same seed, same file; 0: random
+	Messages    int       `koanf:"messages"` // requests per server
+	Start       string    `koanf:"start"`    // RFC 3339 time of the first packet; default 2024-01-01T00:00:00Z
+	Interval    string    `koanf:"interval"` // between requests on a connection
+	Latency     string    `koanf:"latency"`  // mean response time
+	DeclineRate float64   `koanf:"decline_rate"`
+	MTIs        []string  `koanf:"mtis"` // request MTIs, used in turn; default 0200
+	Faults      GenFaults `koanf:"faults"`
+}
+
+// GenFaults are per-message (split, merge, reset, garbage) or per-segment
+// (loss, reorder, retransmit) probabilities.
+type GenFaults struct {
+	Loss       float64 `koanf:"loss"`
+	Reorder    float64 `koanf:"reorder"`
+	Retransmit float64 `koanf:"retransmit"`
+	Split      float64 `koanf:"split"`
+	Merge      float64 `koanf:"merge"`
+	Reset      float64 `koanf:"reset"`
+	Garbage    float64 `koanf:"garbage"`
+}
+
+type MaskRule struct {
+	Field     int `koanf:"field"`
+	KeepFirst int `koanf:"keep_first"`
+	KeepLast  int `koanf:"keep_last"`
+}
+
+const (
+	// EnvPrefix marks environment (and .env) variables that override config
+	// keys, e.g. ISOTCP_LOG_METADATA_LEVEL=debug sets log.metadata.level.
+	EnvPrefix = "ISOTCP_"
+	// EnvConfigPath names the config file when no path is given.
+	EnvConfigPath = EnvPrefix + "CONFIG"
+	// EnvDotEnvPath names the .env file, default ".env" in the working dir.
+	EnvDotEnvPath = EnvPrefix + "ENV_FILE"
+)
+
+// Load reads the config from ISOTCP_CONFIG, or from config/config.<type>
+// where type comes from CONFIG_FILE_TYPE in .env.
 func Load() (*Config, error) {
+	return NewLoader().Load(LoadOptions{})
+}
+
+// LoadFrom reads the layered config with path as the base file.
+func LoadFrom(path string) (*Config, error) {
+	return NewLoader().Load(LoadOptions{Path: path})
+}
+
+// LoadWith reads the layered config from the OS filesystem and environment.
+func LoadWith(opts LoadOptions) (*Config, error) {
+	return NewLoader().Load(opts)
+}
+
+// ProfileAliases map longer profile names to their file suffix.
+var ProfileAliases = map[string]string{"development": "dev", "production": "prod"}
+
+// LoadOptions select the base file, the profile and flag overrides.
+type LoadOptions struct {
+	Path    string   // base file; empty falls back to Load's lookup
+	Profile string   // empty: APP_ENV from the environment or .env; see ProfileAliases
+	Set     []string // "key=value" overrides from flags, applied last
+}
+
+// Loader reads configs. Every load starts from an empty koanf instance, so
+// a Loader can be reused and several can run side by side.
+type Loader struct {
+	fsys fs.FS             // nil: the OS filesystem
+	env  map[string]string // nil: the process environment
+}
+
+func NewLoader() *Loader {
+	return &Loader{}
+}
+
+// WithFS reads config and .env files from fsys instead of the OS; paths
+// are slash separated and relative to its root.
+func (l *Loader) WithFS(fsys fs.FS) *Loader {
+	l.fsys = fsys
+	return l
+}
+
+// WithEnv replaces the process environment with vars.
+func (l *Loader) WithEnv(vars map[string]string) *Loader {
+	l.env = vars
+	return l
+}
+
+// LoadBytes parses a single config document of fileType ("yaml" or
+// "toml"). No profile, local, .env, environment or flag layer is applied.
+func (l *Loader) LoadBytes(data []byte, fileType string) (*Config, error) {
+	ko := koanf.New(".")
+	cfg := Config{EnvVars: map[string]string{}, Sources: map[string]string{}}
+	if err := ko.Load(rawbytes.Provider(data), parserFor(fileType)); err != nil {
+		return nil, fmt.Errorf("config: load %s bytes: %w", fileType, err)
+	}
+	checkTypes(&cfg.typeErrs, "", ko.Raw(), reflect.TypeOf(cfg))
+	for _, key := range ko.Keys() {
+		cfg.Sources[key] = "bytes"
+	}
+	return finish(ko, &cfg)
+}
+
+// Load merges, in order: the base file, config.<profile>.<ext> (required
+// once a profile is asked for), config.local.<ext> (optional), both next
+// to the base file, ISOTCP_* variables from .env and then the
+// environment, and finally opts.Set.
+// Config.Sources records which layer set each key.
+func (l *Loader) Load(opts LoadOptions) (*Config, error) {
+	ko := koanf.New(".")
 
 	var cfg Config
 
 	// read .env
-	envs := readDotEnvAll(".env")
+	dotEnv := strings.TrimSpace(l.getenv(nil, EnvDotEnvPath))
+	if dotEnv == "" {
+		dotEnv = ".env"
+	}
+	envs := l.readDotEnvAll(dotEnv)
 
 	// load EnvVars except LIMIT_SIZE
 	cfg.EnvVars = make(map[string]string, len(envs))
@@ -170,43 +477,240 @@ func Load() (*Config, error) {
 		cfg.EnvVars[k] = v
 	}
 
-	// get type of config from .env
-	fileType := strings.ToLower(envs["CONFIG_FILE_TYPE"])
-	if fileType != "toml" && fileType != "yaml" {
+	path := strings.TrimSpace(opts.Path)
+	if path == "" {
+		path = strings.TrimSpace(l.getenv(envs, EnvConfigPath))
+	}
+
+	// get type of config from the extension, or from .env
+	var fileType string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		fileType = "toml"
+	case ".yaml", ".yml":
 		fileType = "yaml"
+	default:
+		fileType = strings.ToLower(envs["CONFIG_FILE_TYPE"])
+		if fileType != "toml" && fileType != "yaml" {
+			fileType = "yaml"
+		}
+	}
+	if path == "" {
+		path = filepath.Join("config", "config."+fileType)
 	}
+	parser := parserFor(fileType)
 
-	var path string
+	cfg.Sources = make(map[string]string)
+	merge := func(layer string, p koanf.Provider, pa koanf.Parser) error {
+		lk := koanf.New(".")
+		if err := lk.Load(p, pa); err != nil {
+			return err
+		}
+		for _, key := range lk.Keys() {
+			cfg.Sources[key] = layer
+		}
+		if pa != nil { // files; the env and flag layers are strings by design
+			checkTypes(&cfg.typeErrs, "", lk.Raw(), reflect.TypeOf(cfg))
+		}
+		return ko.Merge(lk)
+	}
 
-	switch fileType {
-	case "toml":
-		path = filepath.Join("config", "config.toml")
-		if err := k.Load(file.Provider(path), toml.Parser()); err != nil {
-			return nil, fmt.Errorf("config: load toml: %w", err)
+	// 1) base file
+	if err := merge("file:"+path, l.fileProvider(path), parser); err != nil {
+		return nil, fmt.Errorf("config: load %s %s: %w", fileType, path, err)
+	}
+	cfg.Files = append(cfg.Files, path)
+
+	// 2) profile, when one is set, and 3) optional local override
+	profile := strings.ToLower(strings.TrimSpace(opts.Profile))
+	if profile == "" {
+		profile = strings.ToLower(strings.TrimSpace(l.getenv(envs, "APP_ENV")))
+	}
+	if alias, ok := ProfileAliases[profile]; ok {
+		profile = alias
+	}
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	for _, ly := range []struct{ layer, name string }{
+		{"profile", profile},
+		{"local", "local"},
+	} {
+		if ly.name == "" {
+			continue
 		}
-	default:
-		path = filepath.Join("config", "config.yaml")
-		if err := k.Load(file.Provider(path), yaml.Parser()); err != nil {
-			return nil, fmt.Errorf("config: load yaml: %w", err)
+		p := stem + "." + ly.name + ext
+		if !l.exists(p) {
+			if ly.layer == "profile" {
+				// a typo in -env or APP_ENV would otherwise go unnoticed
+				return nil, fmt.Errorf("config: profile %q: %s not found", ly.name, p)
+			}
+			continue
+		}
+		if err := merge(ly.layer+":"+p, l.fileProvider(p), parser); err != nil {
+			return nil, fmt.Errorf("config: load %s %s: %w", fileType, p, err)
+		}
+		cfg.Files = append(cfg.Files, p)
+	}
+
+	// 4) ISOTCP_* overrides: .env first, then the environment
+	known := knownKeys(ko)
+	for _, src := range []struct {
+		layer string
+		vars  map[string]string
+	}{
+		{"dotenv:" + dotEnv, envs},
+		{"env", l.environ()},
+	} {
+		overrides := make(map[string]any)
+		for name, v := range src.vars {
+			if key := envKey(known, name); key != "" {
+				overrides[key] = v
+			}
+		}
+		if err := merge(src.layer, confmap.Provider(overrides, "."), nil); err != nil {
+			return nil, fmt.Errorf("config: load %s overrides: %w", src.layer, err)
 		}
 	}
 
+	// 5) flags
+	flags := make(map[string]any, len(opts.Set))
+	for _, kv := range opts.Set {
+		key, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("config: bad override %q, want key=value", kv)
+		}
+		flags[strings.TrimSpace(key)] = v
+	}
+	if err := merge("flag", confmap.Provider(flags, "."), nil); err != nil {
+		return nil, fmt.Errorf("config: load flag overrides: %w", err)
+	}
+
 	// if you need to normalize some structure
-	// normalizeAllListOfSingletonMaps(k,
+	// normalizeAllListOfSingletonMaps(ko,
 	// 	"log.array_sample",
 	// 	// add another configs which you need
 	// )
 
-	if err := k.Unmarshal("", &cfg); err != nil {
+	return finish(ko, &cfg)
+}
+
+func finish(ko *koanf.Koanf, cfg *Config) (*Config, error) {
+	if err := ko.Unmarshal("", cfg); err != nil {
 		return nil, fmt.Errorf("config: unmarshal: %w", err)
 	}
+	cfg.values = ko.All()
+	return cfg, nil
+}
+
+func parserFor(fileType string) koanf.Parser {
+	if strings.EqualFold(fileType, "toml") {
+		return toml.Parser()
+	}
+	return yaml.Parser()
+}
+
+func (l *Loader) fileProvider(path string) koanf.Provider {
+	if l.fsys != nil {
+		return kfs.Provider(l.fsys, filepath.ToSlash(path))
+	}
+	return file.Provider(path)
+}
+
+func (l *Loader) exists(path string) bool {
+	var err error
+	if l.fsys != nil {
+		_, err = fs.Stat(l.fsys, filepath.ToSlash(path))
+	} else {
+		_, err = os.Stat(path)
+	}
+	return err == nil
+}
+
+// environ returns the ISOTCP_* variables of the environment.
+func (l *Loader) environ() map[string]string {
+	out := make(map[string]string)
+	if l.env != nil {
+		for name, v := range l.env {
+			if strings.HasPrefix(name, EnvPrefix) {
+				out[name] = v
+			}
+		}
+		return out
+	}
+	for _, kv := range os.Environ() {
+		if name, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			out[name] = v
+		}
+	}
+	return out
+}
+
+// getenv prefers the environment over .env.
+func (l *Loader) getenv(envs map[string]string, name string) string {
+	if l.env != nil {
+		if v, ok := l.env[name]; ok {
+			return v
+		}
+	} else if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return envs[name]
+}
+
+// envKey maps ISOTCP_LOG_FILE_FILE_PATH to log.file.file_path. Known keys
+// resolve exactly; anything else splits the section at the first '_'.
+func envKey(known map[string]string, name string) string {
+	if !strings.HasPrefix(name, EnvPrefix) || name == EnvConfigPath || name == EnvDotEnvPath {
+		return ""
+	}
+	s := strings.ToUpper(strings.TrimPrefix(name, EnvPrefix))
+	if key, ok := known[s]; ok {
+		return key
+	}
+	return strings.Replace(strings.ToLower(s), "_", ".", 1)
+}
 
-	return &cfg, nil
+// knownKeys indexes every key of the Config struct and of the loaded file
+// by its env form (upper case, '.' as '_').
+func knownKeys(ko *koanf.Koanf) map[string]string {
+	keys := structKeys(reflect.TypeOf(Config{}), "")
+	keys = append(keys, ko.Keys()...)
+	out := make(map[string]string, len(keys))
+	for _, key := range keys {
+		out[strings.ToUpper(strings.ReplaceAll(key, ".", "_"))] = key
+	}
+	return out
 }
 
-func readDotEnvAll(path string) map[string]string {
+func structKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("koanf")
+		if tag == "" {
+			continue
+		}
+		key := prefix + tag
+		if f.Type.Kind() == reflect.Struct {
+			keys = append(keys, structKeys(f.Type, key+".")...)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (l *Loader) readDotEnvAll(path string) map[string]string {
 	out := make(map[string]string)
-	f, err := os.Open(path)
+	var (
+		f   io.ReadCloser
+		err error
+	)
+	if l.fsys != nil {
+		f, err = l.fsys.Open(filepath.ToSlash(path))
+	} else {
+		f, err = os.Open(path)
+	}
 	if err != nil {
 		return out
 	}
@@ -227,9 +731,30 @@ func readDotEnvAll(path string) map[string]string {
 	return out
 }
 
+// Pretty lists every key with its value and the layer that set it, e.g.
+// "log.metadata.level = debug  (profile:config/config.dev.yaml)". A Config
+// not built by a Loader has no layers and prints as indented JSON.
 func (c Config) Pretty() string {
-	b, _ := json.MarshalIndent(c, "", "  ")
-	return string(b)
+	if len(c.Sources) == 0 {
+		b, _ := json.MarshalIndent(c, "", "  ")
+		return string(b)
+	}
+	keys := make([]string, 0, len(c.Sources))
+	width := 0
+	for key := range c.Sources {
+		keys = append(keys, key)
+		if len(key) > width {
+			width = len(key)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		b, _ := json.Marshal(c.values[key])
+		lines = append(lines, fmt.Sprintf("%-*s = %s  (%s)", width, key, b, c.Sources[key]))
+	}
+	return strings.Join(lines, "\n")
 }
 
 func (c Config) PrettyInOneLine() string {
@@ -243,6 +768,18 @@ func (c Config) PrettyInOneLine() string {
 		"limits":       c.Limits,
 		"log":          c.Log,
 		"crossnetwork": c.CrossNetwork,
+		"report":       c.Report,
+		"summary":      c.Summary,
+		"assertions":   c.Assertions,
+		"matcher":      c.Matcher,
+		"duplicates":   c.Duplicates,
+		"metrics":      c.Metrics,
+		"dashboard":    c.Dashboard,
+		"masking":      c.Masking,
+		"snmp":         c.SNMP,
+		"replay":       c.Replay,
+		"simulate":     c.Simulate,
+		"generate":     c.Generate,
 	}
 
 	for k, v := range sections {
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	for _, base := range []string{"config.yaml", "config.toml"} {
+		for profile, want := range map[string]string{"dev": "debug", "development": "debug", "prod": "info", "Production": "info"} {
+			cfg, err := NewLoader().WithEnv(map[string]string{}).Load(LoadOptions{Path: base, Profile: profile})
+			if err != nil {
+				t.Errorf("%s -env %s: %v", base, profile, err)
+				continue
+			}
+			if got := cfg.Log.Metadata.Level; got != want {
+				t.Errorf("%s -env %s: log level %q, want %q", base, profile, got, want)
+			}
+		}
+	}
+
+	// APP_ENV counts as asking for a profile too
+	_, err := NewLoader().WithEnv(map[string]string{"APP_ENV": "staging"}).Load(LoadOptions{Path: "config.yaml"})
+	if err == nil || !strings.Contains(err.Error(), `profile "staging"`) {
+		t.Errorf("APP_ENV=staging: got %v, want a missing profile error", err)
+	}
+}
+
+// Pretty shows each key's value with the layer that set it.
+func TestPretty(t *testing.T) {
+	cfg, err := NewLoader().WithEnv(map[string]string{}).Load(LoadOptions{
+		Path: "config.yaml", Profile: "dev", Set: []string{"matcher.timeout=9s"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := cfg.Pretty()
+	for _, want := range []string{`"debug"  (profile:`, `config.dev.yaml)`, `"9s"  (`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Pretty lacks %q:\n%s", want, out)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "log.metadata.level ") && !strings.Contains(line, "config.dev.yaml") {
+			t.Errorf("level not from the profile: %s", line)
+		}
+	}
+
+	if lit := (Config{App: App{Name: "x"}}).Pretty(); !strings.HasPrefix(lit, "{") {
+		t.Errorf("unloaded config: %s", lit)
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/knadh/koanf/providers/file"
+)
+
+// reloadDebounce folds the burst of events an editor save produces into one
+// reload.
+const reloadDebounce = 250 * time.Millisecond
+
+// Change is one key whose effective value differs between two configs.
+type Change struct {
+	Key string
+	Old string // JSON, "" when the key was unset
+	New string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Key, orNone(c.Old), orNone(c.New))
+}
+
+func orNone(s string) string {
+	if s == "" || s == "null" {
+		return "<none>"
+	}
+	return s
+}
+
+// Reload is the outcome of one reload attempt. On error Cfg is the config
+// still in effect and Changes is empty.
+type Reload struct {
+	Cfg     *Config
+	Changes []Change
+	Err     error
+}
+
+// Live holds the effective config of a long-running capture. Get always
+// returns a complete, validated config; Reload replaces it as a whole.
+type Live struct {
+	opts LoadOptions
+	cur  atomic.Pointer[Config]
+	mu   sync.Mutex // serialises reloads
+}
+
+func NewLive(cfg *Config, opts LoadOptions) *Live {
+	l := &Live{opts: opts}
+	l.cur.Store(cfg)
+	return l
+}
+
+func (l *Live) Get() *Config { return l.cur.Load() }
+
+// Reload loads and validates the layers again and swaps the result in. An
+// invalid config is reported and the current one stays in effect.
+func (l *Live) Reload() Reload {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	old := l.cur.Load()
+	cfg, err := LoadWith(l.opts)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		return Reload{Cfg: old, Err: err}
+	}
+	l.cur.Store(cfg)
+	return Reload{Cfg: cfg, Changes: Diff(old, cfg)}
+}
+
+// Watch reloads whenever one of the config files changes and reports every
+// attempt to fn, from a background goroutine, until stop is called.
+// Environment and .env changes are only picked up together with a file
+// change.
+func (l *Live) Watch(fn func(Reload)) (stop func(), err error) {
+	var (
+		mu      sync.Mutex
+		timer   *time.Timer
+		stopped atomic.Bool
+	)
+	trigger := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		if stopped.Load() {
+			return
+		}
+		timer = time.AfterFunc(reloadDebounce, func() {
+			if !stopped.Load() {
+				fn(l.Reload())
+			}
+		})
+	}
+	stop = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped.Store(true)
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	for _, path := range l.Get().Files {
+		if err := l.watchFile(path, trigger, &stopped); err != nil {
+			stop()
+			return nil, fmt.Errorf("config: watch %s: %w", path, err)
+		}
+	}
+	return stop, nil
+}
+
+// watchFile keeps a watch on path. Editors that save by rename or remove
+// end the koanf watcher, so it is set up again once the file is back. The
+// koanf watcher cannot be closed; once stopped it triggers nothing.
+func (l *Live) watchFile(path string, trigger func(), stopped *atomic.Bool) error {
+	return file.Provider(path).Watch(func(_ interface{}, err error) {
+		if stopped.Load() {
+			return
+		}
+		if err == nil {
+			trigger()
+			return
+		}
+		go func() {
+			for !stopped.Load() {
+				time.Sleep(time.Second)
+				if l.watchFile(path, trigger, stopped) == nil {
+					trigger()
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Diff lists the keys whose values differ between old and cur, sorted.
+func Diff(old, cur *Config) []Change {
+	a, b := flatten(old), flatten(cur)
+	keys := make([]string, 0, len(b))
+	for key := range b {
+		keys = append(keys, key)
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var out []Change
+	for _, key := range keys {
+		if a[key] != b[key] {
+			out = append(out, Change{Key: key, Old: a[key], New: b[key]})
+		}
+	}
+	return out
+}
+
+// flatten renders every koanf-tagged leaf of cfg as JSON, keyed like the
+// config file. Lists are compared whole.
+func flatten(cfg *Config) map[string]string {
+	out := make(map[string]string)
+	if cfg != nil {
+		flattenValue(reflect.ValueOf(*cfg), "", out)
+	}
+	return out
+}
+
+func flattenValue(v reflect.Value, prefix string, out map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("koanf")
+		if tag == "" {
+			continue
+		}
+		key := prefix + tag
+		if f.Type.Kind() == reflect.Struct {
+			flattenValue(v.Field(i), key+".", out)
+			continue
+		}
+		b, _ := json.Marshal(v.Field(i).Interface())
+		out[key] = string(b)
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Watch reports file changes until stopped, and nothing after.
+func TestWatchStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(level string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte("log:\n  metadata:\n    level: "+level+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("info")
+
+	l := NewLive(&Config{Files: []string{path}}, LoadOptions{Path: path})
+	var calls atomic.Int32
+	stop, err := l.Watch(func(Reload) { calls.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("debug")
+	deadline := time.Now().Add(5 * time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls.Load() == 0 {
+		t.Fatal("change not reported")
+	}
+
+	stop()
+	n := calls.Load()
+	write("warn")
+	time.Sleep(3 * reloadDebounce)
+	if got := calls.Load(); got != n {
+		t.Errorf("%d reloads after stop", got-n)
+	}
+}
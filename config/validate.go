@@ -0,0 +1,658 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is a problem with a single config key.
+type FieldError struct {
+	Key string // e.g. "server[1].ip"
+	Msg string
+}
+
+func (e *FieldError) Error() string { return e.Key + ": " + e.Msg }
+
+// ValidationErrors collects every FieldError found by Validate.
+type ValidationErrors []*FieldError
+
+func (ve ValidationErrors) Error() string {
+	lines := make([]string, len(ve))
+	for i, e := range ve {
+		lines[i] = e.Error()
+	}
+	return "config: invalid:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Except drops the errors for keys, for commands that do not use them; it
+// returns nil when nothing is left.
+func (ve ValidationErrors) Except(keys ...string) error {
+	var out ValidationErrors
+	for _, e := range ve {
+		if !slices.Contains(keys, e.Key) {
+			out = append(out, e)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func (ve *ValidationErrors) add(key, format string, args ...any) {
+	*ve = append(*ve, &FieldError{Key: key, Msg: fmt.Sprintf(format, args...)})
+}
+
+var knownLevels = map[string]bool{
+	"trace": true, "debug": true, "info": true, "warn": true, "warning": true,
+	"error": true, "fatal": true, "panic": true, "disabled": true,
+}
+
+// Validate checks the loaded config and returns ValidationErrors holding
+// every problem, or nil.
+func (c *Config) Validate() error {
+	ve := slices.Clone(c.typeErrs)
+
+	// app
+	if strings.TrimSpace(c.App.Interface) == "" {
+		p := strings.TrimSpace(c.App.PcapPath)
+		switch fi, err := os.Stat(p); {
+		case p == "":
+			ve.add("app.pcap_path", "required when app.interface is not set")
+		case err != nil:
+			ve.add("app.pcap_path", "%v", err)
+		case fi.IsDir():
+			ve.add("app.pcap_path", "%q is a directory", p)
+		}
+	}
+
+	// network
+	if ip := strings.TrimSpace(c.Network.FWIP); net.ParseIP(ip) == nil {
+		ve.add("network.fw_ip", "invalid ip %q", c.Network.FWIP)
+	}
+
+	// server
+	names := make(map[string]int, len(c.Server))
+	defaults := 0
+	for i, s := range c.Server {
+		key := fmt.Sprintf("server[%d]", i)
+		name := strings.TrimSpace(s.Name)
+		if name == "" {
+			ve.add(key+".name", "required")
+		} else if j, dup := names[name]; dup {
+			ve.add(key+".name", "duplicate name %q (also server[%d])", name, j)
+		} else {
+			names[name] = i
+		}
+		if net.ParseIP(strings.TrimSpace(s.IP)) == nil {
+			ve.add(key+".ip", "invalid ip %q", s.IP)
+		}
+		for j, p := range s.Ports {
+			if p < 1 || p > 65535 {
+				ve.add(fmt.Sprintf("%s.ports[%d]", key, j), "port %d out of range 1-65535", p)
+			}
+		}
+		if s.IsDefault {
+			defaults++
+		}
+	}
+	if len(c.Server) > 0 && defaults != 1 {
+		ve.add("server", "exactly one server must have is_default: true, found %d", defaults)
+	}
+
+	// output
+	for _, o := range []struct{ key, path string }{
+		{"output.packet_log_path", c.Output.PacketLogPath},
+		{"output.input_csv_path", c.Output.InputCSVPath},
+		{"output.output_csv_path", c.Output.OutputCSVPath},
+		{"output.durations_csv", c.Output.DurationsCSV},
+		{"output.report_csv", c.Output.ReportCSV},
+		{"report.path", c.Report.Path},
+		{"summary.path", c.Summary.Path},
+		{"log.file.file_path", c.Log.File.Path},
+	} {
+		if err := checkWritableDir(o.path); err != nil {
+			ve.add(o.key, "%v", err)
+		}
+	}
+
+	// log
+	if l := strings.TrimSpace(c.Log.Metadata.Level); l != "" && !knownLevels[strings.ToLower(l)] {
+		ve.add("log.metadata.level", "unknown level %q", l)
+	}
+	if l := strings.TrimSpace(c.Log.Sampler.Level); l != "" && !knownLevels[strings.ToLower(l)] {
+		ve.add("log.sampler.level", "unknown level %q", l)
+	}
+	checkColor(&ve, "log.console.color", c.Log.Console.Color)
+	sinkNames := make(map[string]int, len(c.Log.Sinks))
+	for i, sk := range c.Log.Sinks {
+		key := fmt.Sprintf("log.sinks[%d]", i)
+		typ := strings.ToLower(strings.TrimSpace(sk.Type))
+		name := strings.TrimSpace(sk.Name)
+		if name == "" {
+			name = typ
+		}
+		if j, dup := sinkNames[name]; dup {
+			ve.add(key+".name", "duplicate sink %q (also log.sinks[%d])", name, j)
+		}
+		sinkNames[name] = i
+		switch typ {
+		case "console", "ring":
+		case "audit":
+			if strings.TrimSpace(sk.Path) == "" {
+				ve.add(key+".path", "required for audit sinks")
+			} else if err := checkWritableDir(sk.Path); err != nil {
+				ve.add(key+".path", "%v", err)
+			}
+			if f := strings.TrimSpace(sk.Format); f != "" && f != "json" {
+				ve.add(key+".format", "audit sinks are json only")
+			}
+		case "file", "json":
+			switch {
+			case strings.TrimSpace(sk.Path) != "":
+				if err := checkWritableDir(sk.Path); err != nil {
+					ve.add(key+".path", "%v", err)
+				}
+			case typ == "json":
+				ve.add(key+".path", "required for json sinks")
+			}
+		case "syslog":
+			if a := strings.TrimSpace(sk.Address); a != "" {
+				if u, err := url.Parse(a); err != nil || u.Scheme == "" {
+					ve.add(key+".address", "want udp://host:port, tcp://host:port or unix:///path, got %q", sk.Address)
+				}
+			}
+			if f := strings.TrimSpace(sk.Format); f != "" && f != "json" {
+				ve.add(key+".format", "syslog sinks are json only")
+			}
+		default:
+			ve.add(key+".type", "unknown type %q, want console|file|json|syslog|ring|audit", sk.Type)
+		}
+		switch strings.ToLower(strings.TrimSpace(sk.Format)) {
+		case "", "json", "pipe", "console":
+		default:
+			ve.add(key+".format", "unknown format %q, want json|pipe|console", sk.Format)
+		}
+		if l := strings.TrimSpace(sk.Level); l != "" && !knownLevels[strings.ToLower(l)] {
+			ve.add(key+".level", "unknown level %q", l)
+		}
+		checkColor(&ve, key+".color", sk.Color)
+		if sk.Size < 0 {
+			ve.add(key+".size", "must not be negative")
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(c.Log.Rotation.Interval)) {
+	case "", "hourly", "daily", "weekly":
+	default:
+		ve.add("log.rotation.interval", "unknown interval %q, want hourly|daily|weekly", c.Log.Rotation.Interval)
+	}
+	if c.Log.Rotation.Count < 0 {
+		ve.add("log.rotation.count", "must not be negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Log.Time.DurationFieldUnit)) {
+	case "", "ns", "us", "µs", "ms", "s":
+	default:
+		ve.add("log.time.duration_field_unit", "unknown unit %q, want ns|us|ms|s", c.Log.Time.DurationFieldUnit)
+	}
+
+	// durations
+	checkDuration(&ve, "log.sampler.burst_period", c.Log.Sampler.BurstPeriod)
+	checkDuration(&ve, "log.sampler.report_interval", c.Log.Sampler.ReportInterval)
+	if sp := c.Log.Sampler; sp.Enable && sp.Burst > 0 && strings.TrimSpace(sp.BurstPeriod) == "" {
+		ve.add("log.sampler.burst_period", "required when burst is set")
+	}
+	levels := make([]string, 0, len(c.Log.Sampler.Levels))
+	for lvl := range c.Log.Sampler.Levels {
+		levels = append(levels, lvl)
+	}
+	sort.Strings(levels)
+	for _, lvl := range levels {
+		key := "log.sampler.levels." + lvl
+		ls := c.Log.Sampler.Levels[lvl]
+		switch strings.ToLower(lvl) {
+		case "trace", "debug", "info", "warn", "warning":
+		case "error", "fatal", "panic":
+			ve.add(key, "%s events are never sampled", lvl)
+		default:
+			ve.add(key, "unknown level %q", lvl)
+		}
+		checkDuration(&ve, key+".burst_period", ls.BurstPeriod)
+		switch {
+		case ls.Burst > 0 && strings.TrimSpace(ls.BurstPeriod) == "":
+			ve.add(key+".burst_period", "required when burst is set")
+		case ls.Burst == 0 && ls.BasicN == 0:
+			ve.add(key, "set burst and burst_period, or basic_n")
+		}
+	}
+	checkDuration(&ve, "report.bucket", c.Report.Bucket)
+	checkDuration(&ve, "matcher.default_timeout", c.Matcher.DefaultTimeout)
+	checkDuration(&ve, "matcher.late_window", c.Matcher.LateWindow)
+	checkDuration(&ve, "matcher.reversal_window", c.Matcher.ReversalWindow)
+	classes := make([]string, 0, len(c.Matcher.Timeouts))
+	for class := range c.Matcher.Timeouts {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		checkDuration(&ve, "matcher.timeouts."+class, c.Matcher.Timeouts[class])
+	}
+	checkDuration(&ve, "duplicates.window", c.Duplicates.Window)
+	checkDuration(&ve, "dashboard.interval", c.Dashboard.Interval)
+
+	// report
+	switch strings.ToLower(strings.TrimSpace(c.Report.Format)) {
+	case "", "text", "csv", "json":
+	default:
+		ve.add("report.format", "unknown format %q, want text|csv|json", c.Report.Format)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Summary.Format)) {
+	case "", "text", "json", "yaml":
+	default:
+		ve.add("summary.format", "unknown format %q, want text|json|yaml", c.Summary.Format)
+	}
+	checkDuration(&ve, "assertions.max_p99_latency", c.Assertions.MaxP99Latency)
+	for _, f := range []struct {
+		key string
+		v   *int
+	}{
+		{"assertions.max_orphan_requests", c.Assertions.MaxOrphanRequests},
+		{"assertions.max_orphan_responses", c.Assertions.MaxOrphanResponses},
+		{"assertions.max_timeouts", c.Assertions.MaxTimeouts},
+		{"assertions.min_messages", c.Assertions.MinMessages},
+	} {
+		if f.v != nil && *f.v < 0 {
+			ve.add(f.key, "must not be negative")
+		}
+	}
+	if r := c.Assertions.MinApprovalRate; r < 0 || r > 1 {
+		ve.add("assertions.min_approval_rate", "want 0..1, got %v", r)
+	}
+	for i, rc := range c.Report.ResponseCodes {
+		key := fmt.Sprintf("report.response_codes[%d]", i)
+		if len(rc.Code) != 2 {
+			ve.add(key+".code", "want 2 characters, got %q", rc.Code)
+		}
+		switch strings.ToLower(strings.TrimSpace(rc.Outcome)) {
+		case "", "approved", "declined", "timeout":
+		default:
+			ve.add(key+".outcome", "unknown outcome %q, want approved|declined|timeout", rc.Outcome)
+		}
+	}
+
+	// listeners
+	if t := strings.TrimSpace(c.Replay.Target); t != "" {
+		checkListen(&ve, "replay.target", t)
+	}
+	if c.Replay.Speed < 0 {
+		ve.add("replay.speed", "must not be negative")
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Replay.Framing)) {
+	case "", "ascii4", "binary2", "binary4":
+	default:
+		ve.add("replay.framing", "unknown framing %q, want ascii4|binary2|binary4", c.Replay.Framing)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Replay.Direction)) {
+	case "", "input", "output":
+	default:
+		ve.add("replay.direction", "want input or output, got %q", c.Replay.Direction)
+	}
+	checkDuration(&ve, "replay.dial_timeout", c.Replay.DialTimeout)
+	checkDuration(&ve, "replay.response_timeout", c.Replay.ResponseTimeout)
+	for i, f := range c.Replay.IgnoreFields {
+		if f < 1 || f > 128 {
+			ve.add(fmt.Sprintf("replay.ignore_fields[%d]", i), "field %d out of 1..128", f)
+		}
+	}
+
+	if l := strings.TrimSpace(c.Simulate.Listen); l != "" {
+		checkListen(&ve, "simulate.listen", l)
+	}
+	switch strings.ToLower(strings.TrimSpace(c.Simulate.Framing)) {
+	case "", "ascii4", "binary2", "binary4":
+	default:
+		ve.add("simulate.framing", "unknown framing %q, want ascii4|binary2|binary4", c.Simulate.Framing)
+	}
+	checkSimDelay(&ve, "simulate.delay", c.Simulate.Delay)
+	for i, f := range c.Simulate.Strip {
+		if f < 2 || f > 128 {
+			ve.add(fmt.Sprintf("simulate.strip[%d]", i), "field %d out of 2..128", f)
+		}
+	}
+	for i, r := range c.Simulate.Rules {
+		key := fmt.Sprintf("simulate.rules[%d]", i)
+		for _, k := range []struct{ name, mti string }{{"mti", r.MTI}, {"response_mti", r.ResponseMTI}} {
+			if v := strings.TrimSpace(k.mti); v != "" && (len(v) != 4 || !isDigits(v)) {
+				ve.add(key+"."+k.name, "want 4 digits, got %q", k.mti)
+			}
+		}
+		for f := range r.Match {
+			if n, err := strconv.Atoi(f); err != nil || n < 2 || n > 128 {
+				ve.add(key+".match", "field %q out of 2..128", f)
+			}
+		}
+		for j, code := range r.Codes {
+			if len(strings.TrimSpace(code.Code)) != 2 {
+				ve.add(fmt.Sprintf("%s.codes[%d].code", key, j), "want 2 characters, got %q", code.Code)
+			}
+			if code.Weight < 0 {
+				ve.add(fmt.Sprintf("%s.codes[%d].weight", key, j), "must not be negative")
+			}
+		}
+		if r.Drop < 0 || r.Drop > 1 {
+			ve.add(key+".drop", "want 0..1, got %v", r.Drop)
+		}
+		checkSimDelay(&ve, key+".delay", r.Delay)
+	}
+
+	if c.Generate.Messages < 0 {
+		ve.add("generate.messages", "must not be negative")
+	}
+	if v := strings.TrimSpace(c.Generate.Start); v != "" {
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			ve.add("generate.start", "want an RFC 3339 time, got %q", c.Generate.Start)
+		}
+	}
+	for _, f := range []struct{ key, v string }{{"generate.interval", c.Generate.Interval}, {"generate.latency", c.Generate.Latency}} {
+		if v := strings.TrimSpace(f.v); v != "" {
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				ve.add(f.key, "invalid duration %q", f.v)
+			}
+		}
+	}
+	for i, m := range c.Generate.MTIs {
+		if m = strings.TrimSpace(m); len(m) != 4 || !isDigits(m) || m[2] != '0' {
+			ve.add(fmt.Sprintf("generate.mtis[%d]", i), "want a 4-digit request MTI, got %q", c.Generate.MTIs[i])
+		}
+	}
+	gf := c.Generate.Faults
+	for _, f := range []struct {
+		key string
+		v   float64
+	}{
+		{"generate.decline_rate", c.Generate.DeclineRate},
+		{"generate.faults.loss", gf.Loss}, {"generate.faults.reorder", gf.Reorder},
+		{"generate.faults.retransmit", gf.Retransmit}, {"generate.faults.split", gf.Split},
+		{"generate.faults.merge", gf.Merge}, {"generate.faults.reset", gf.Reset},
+		{"generate.faults.garbage", gf.Garbage},
+	} {
+		if f.v < 0 || f.v > 1 {
+			ve.add(f.key, "want 0..1, got %v", f.v)
+		}
+	}
+
+	if c.Log.Admin.Enable {
+		checkListen(&ve, "log.admin.listen", c.Log.Admin.Listen)
+		if host, _, err := net.SplitHostPort(strings.TrimSpace(c.Log.Admin.Listen)); err == nil {
+			if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+				ve.add("log.admin.listen", "must be a loopback address, got %q", host)
+			}
+		}
+	}
+	if c.Metrics.Enable {
+		checkListen(&ve, "metrics.listen", c.Metrics.Listen)
+	}
+	if c.Dashboard.Enable {
+		checkListen(&ve, "dashboard.listen", c.Dashboard.Listen)
+	}
+	if c.SNMP.Enable {
+		checkListen(&ve, "snmp.listen", c.SNMP.Listen)
+		if oid := strings.TrimSpace(c.SNMP.RootOID); oid != "" && !validOID(oid) {
+			ve.add("snmp.root_oid", "invalid oid %q", c.SNMP.RootOID)
+		}
+	}
+
+	// crossnetwork
+	segments := make(map[string]bool, len(c.CrossNetwork.Segments)+len(c.Server))
+	for i, seg := range c.CrossNetwork.Segments {
+		key := fmt.Sprintf("crossnetwork.segments[%d]", i)
+		name := strings.TrimSpace(seg.Name)
+		switch {
+		case name == "":
+			ve.add(key+".name", "required")
+		case segments[name]:
+			ve.add(key+".name", "duplicate segment %q", name)
+		}
+		segments[name] = true
+		if len(seg.CIDRs) == 0 {
+			ve.add(key+".cidrs", "at least one cidr required")
+		}
+		for j, cidr := range seg.CIDRs {
+			if !validCIDR(cidr) {
+				ve.add(fmt.Sprintf("%s.cidrs[%d]", key, j), "invalid ip or cidr %q", cidr)
+			}
+		}
+	}
+	for _, s := range c.Server {
+		segments[strings.TrimSpace(s.Name)] = true
+	}
+	oids := make(map[string]int, len(c.CrossNetwork.Net))
+	indexes := make(map[int]int, len(c.CrossNetwork.Net))
+	for i, cn := range c.CrossNetwork.Net {
+		key := fmt.Sprintf("crossnetwork.net[%d]", i)
+		for _, end := range []struct{ key, name string }{{".src", cn.Src}, {".dest", cn.Dest}} {
+			if !segments[strings.TrimSpace(end.name)] {
+				ve.add(key+end.key, "unknown segment or server %q", end.name)
+			}
+		}
+		oid := strings.TrimSpace(cn.OID)
+		if !validOID(oid) {
+			ve.add(key+".oid", "invalid oid %q", cn.OID)
+		} else if j, dup := oids[oid]; dup {
+			ve.add(key+".oid", "duplicate oid (also crossnetwork.net[%d])", j)
+		} else {
+			oids[oid] = i
+		}
+		if j, dup := indexes[cn.Index]; dup {
+			ve.add(key+".index", "duplicate index %d (also crossnetwork.net[%d])", cn.Index, j)
+		} else {
+			indexes[cn.Index] = i
+		}
+	}
+
+	// masking
+	for i, r := range c.Masking.Rules {
+		if r.Field < 2 || r.Field > 128 {
+			ve.add(fmt.Sprintf("masking.rules[%d].field", i), "field %d out of range 2-128", r.Field)
+		}
+	}
+
+	if len(ve) == 0 {
+		return nil
+	}
+	return ve
+}
+
+func validCIDR(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
+
+// validOID wants dotted decimal arcs, e.g. "1.3.6.1.4.1".
+func validOID(s string) bool {
+	arcs := strings.Split(s, ".")
+	if len(arcs) < 2 {
+		return false
+	}
+	for _, a := range arcs {
+		if a == "" || strings.Trim(a, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func checkDuration(ve *ValidationErrors, key, v string) {
+	if strings.TrimSpace(v) == "" {
+		return
+	}
+	if d, err := time.ParseDuration(strings.TrimSpace(v)); err != nil || d <= 0 {
+		ve.add(key, "invalid duration %q", v)
+	}
+}
+
+func checkSimDelay(ve *ValidationErrors, key string, d SimDelay) {
+	switch strings.ToLower(strings.TrimSpace(d.Dist)) {
+	case "", "fixed", "uniform", "normal", "exponential":
+	default:
+		ve.add(key+".dist", "unknown distribution %q, want fixed|uniform|normal|exponential", d.Dist)
+	}
+	for _, f := range []struct{ name, v string }{{"min", d.Min}, {"max", d.Max}, {"mean", d.Mean}, {"stddev", d.StdDev}} {
+		if v := strings.TrimSpace(f.v); v != "" {
+			if x, err := time.ParseDuration(v); err != nil || x < 0 {
+				ve.add(key+"."+f.name, "invalid duration %q", f.v)
+			}
+		}
+	}
+}
+
+func checkColor(ve *ValidationErrors, key, v string) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "auto", "always", "never":
+	default:
+		ve.add(key, "unknown color mode %q, want auto|always|never", v)
+	}
+}
+
+func checkListen(ve *ValidationErrors, key, addr string) {
+	if _, _, err := net.SplitHostPort(strings.TrimSpace(addr)); err != nil {
+		ve.add(key, "invalid listen address %q: %v", addr, err)
+	}
+}
+
+// checkWritableDir reports whether the file at path could be created: its
+// nearest existing ancestor must be a writable directory.
+func checkWritableDir(path string) error {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("%q is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".isotcp-write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory %q is not writable", dir)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// checkTypes compares raw file values with the fields they decode into.
+// Decoding is weak so that environment and flag overrides, which are
+// strings, still set numbers and booleans; files are held to their types
+// here instead, so ports = ["2020"] is an error and not port 2020.
+func checkTypes(ve *ValidationErrors, key string, raw any, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[string]any)
+		if !ok {
+			return
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := f.Tag.Get("koanf")
+			if name == "" || !f.IsExported() {
+				continue
+			}
+			if v, ok := m[name]; ok {
+				checkTypes(ve, joinKey(key, name), v, f.Type)
+			}
+		}
+	case reflect.Map:
+		if m, ok := raw.(map[string]any); ok {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				checkTypes(ve, joinKey(key, k), m[k], t.Elem())
+			}
+		}
+	case reflect.Slice:
+		if s, ok := raw.([]any); ok {
+			for i, v := range s {
+				checkTypes(ve, fmt.Sprintf("%s[%d]", key, i), v, t.Elem())
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !isInteger(raw) {
+			ve.add(key, "want integer, got %s", describe(raw))
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := raw.(float64); !ok && !isInteger(raw) {
+			ve.add(key, "want number, got %s", describe(raw))
+		}
+	case reflect.Bool:
+		if _, ok := raw.(bool); !ok {
+			ve.add(key, "want true or false, got %s", describe(raw))
+		}
+	}
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func isInteger(v any) bool {
+	switch n := v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	case float64:
+		return n == float64(int64(n))
+	}
+	return false
+}
+
+func describe(v any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("string %q", s)
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
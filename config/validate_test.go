@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateFileTypes(t *testing.T) {
+	doc := `
+[network]
+  fw_ip = "172.16.58.20"
+
+[[server]]
+  name       = "fw"
+  ip         = "172.16.58.20"
+  ports      = [2020]
+  is_enable  = true
+  is_default = true
+
+[[server]]
+  name      = "sw"
+  ip        = "172.16.58.19"
+  ports     = ["2020", "3"]
+  is_enable = true
+
+[matcher]
+  late_window = 5
+`
+	cfg, err := NewLoader().LoadBytes([]byte(doc), "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ve ValidationErrors
+	if !errors.As(cfg.Validate(), &ve) {
+		t.Fatalf("Validate: want ValidationErrors, got %v", cfg.Validate())
+	}
+	want := map[string]string{
+		"server[1].ports[0]": `want integer, got string "2020"`,
+		"server[1].ports[1]": `want integer, got string "3"`,
+	}
+	got := map[string]string{}
+	for _, e := range ve {
+		got[e.Key] = e.Msg
+	}
+	for key, msg := range want {
+		if got[key] != msg {
+			t.Errorf("%s: got %q, want %q", key, got[key], msg)
+		}
+	}
+	// strings are not type checked: weak decoding turns 5 into "5", and
+	// checkDuration reports it
+	if _, ok := got["matcher.late_window"]; !ok {
+		t.Error("matcher.late_window: want an invalid duration error")
+	}
+}
+
+// Flag and environment overrides are strings and still set numbers.
+func TestOverridesStayWeak(t *testing.T) {
+	cfg, err := NewLoader().WithEnv(map[string]string{}).Load(LoadOptions{
+		Path: "config.yaml",
+		Set:  []string{"limits.max_records=5", "log.sampler.enable=true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Limits.MaxRecords != 5 || !cfg.Log.Sampler.Enable {
+		t.Errorf("limits.max_records = %d, log.sampler.enable = %v, want 5 and true", cfg.Limits.MaxRecords, cfg.Log.Sampler.Enable)
+	}
+	if len(cfg.typeErrs) != 0 {
+		t.Errorf("type errors from overrides: %v", cfg.typeErrs)
+	}
+}
@@ -1 +1,49 @@
 package constants
+
+// Outcomes of a financial response, used by the decline report.
+const (
+	OutcomeApproved = "approved"
+	OutcomeDeclined = "declined"
+	OutcomeTimeout  = "timeout"
+)
+
+// ResponseCode describes a DE39 value.
+type ResponseCode struct {
+	Description string
+	Outcome     string
+}
+
+// ResponseCodes is the default DE39 table; config `report.response_codes`
+// overrides or extends it.
+var ResponseCodes = map[string]ResponseCode{
+	"00": {"approved", OutcomeApproved},
+	"01": {"refer to card issuer", OutcomeDeclined},
+	"03": {"invalid merchant", OutcomeDeclined},
+	"04": {"pick up card", OutcomeDeclined},
+	"05": {"do not honor", OutcomeDeclined},
+	"06": {"error", OutcomeDeclined},
+	"08": {"honor with identification", OutcomeApproved},
+	"10": {"approved for partial amount", OutcomeApproved},
+	"11": {"approved (vip)", OutcomeApproved},
+	"12": {"invalid transaction", OutcomeDeclined},
+	"13": {"invalid amount", OutcomeDeclined},
+	"14": {"invalid card number", OutcomeDeclined},
+	"15": {"no such issuer", OutcomeDeclined},
+	"30": {"format error", OutcomeDeclined},
+	"41": {"lost card", OutcomeDeclined},
+	"43": {"stolen card", OutcomeDeclined},
+	"51": {"insufficient funds", OutcomeDeclined},
+	"54": {"expired card", OutcomeDeclined},
+	"55": {"incorrect pin", OutcomeDeclined},
+	"57": {"transaction not permitted to cardholder", OutcomeDeclined},
+	"58": {"transaction not permitted to terminal", OutcomeDeclined},
+	"61": {"exceeds withdrawal amount limit", OutcomeDeclined},
+	"62": {"restricted card", OutcomeDeclined},
+	"65": {"exceeds withdrawal frequency limit", OutcomeDeclined},
+	"68": {"response received too late", OutcomeTimeout},
+	"75": {"allowable number of pin tries exceeded", OutcomeDeclined},
+	"91": {"issuer or switch inoperative", OutcomeTimeout},
+	"92": {"routing error", OutcomeDeclined},
+	"94": {"duplicate transmission", OutcomeDeclined},
+	"96": {"system malfunction", OutcomeDeclined},
+}
@@ -0,0 +1,155 @@
+// Package crossnet labels flows with the network path they cross, from the
+// crossnetwork config: named segments (CIDRs) and src→dest paths, each with
+// an OID and index for export.
+package crossnet
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/msn60/isotcpdump/config"
+)
+
+// Path is one configured src→dest pair.
+type Path struct {
+	Name  string `json:"name"` // "src->dest" unless set in config
+	Src   string `json:"src"`
+	Dest  string `json:"dest"`
+	OID   string `json:"oid"`
+	Index int    `json:"index"`
+}
+
+type segment struct {
+	name string
+	nets []*net.IPNet
+}
+
+// Labeler resolves flow endpoints to segments and segment pairs to paths.
+// A nil Labeler labels nothing.
+type Labeler struct {
+	segments []segment
+	paths    []Path
+}
+
+// New builds a Labeler from cfg. Besides crossnetwork.segments, every
+// configured server is a segment of its own IP unless a segment of the
+// same name exists.
+func New(cfg *config.Config) (*Labeler, error) {
+	l := &Labeler{}
+	names := make(map[string]bool)
+	for _, s := range cfg.CrossNetwork.Segments {
+		seg := segment{name: strings.TrimSpace(s.Name)}
+		for _, c := range s.CIDRs {
+			n, err := ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("crossnet: segment %q: %w", seg.name, err)
+			}
+			seg.nets = append(seg.nets, n)
+		}
+		names[seg.name] = true
+		l.segments = append(l.segments, seg)
+	}
+	for _, s := range cfg.Server {
+		name := strings.TrimSpace(s.Name)
+		if names[name] {
+			continue
+		}
+		n, err := ParseCIDR(s.IP)
+		if err != nil {
+			continue
+		}
+		names[name] = true
+		l.segments = append(l.segments, segment{name: name, nets: []*net.IPNet{n}})
+	}
+
+	for _, cn := range cfg.CrossNetwork.Net {
+		p := Path{
+			Name:  strings.TrimSpace(cn.Name),
+			Src:   strings.TrimSpace(cn.Src),
+			Dest:  strings.TrimSpace(cn.Dest),
+			OID:   strings.TrimSpace(cn.OID),
+			Index: cn.Index,
+		}
+		for _, end := range []string{p.Src, p.Dest} {
+			if !names[end] {
+				return nil, fmt.Errorf("crossnet: path %s->%s: unknown segment %q", p.Src, p.Dest, end)
+			}
+		}
+		if p.Name == "" {
+			p.Name = p.Src + "->" + p.Dest
+		}
+		l.paths = append(l.paths, p)
+	}
+	sort.SliceStable(l.paths, func(i, j int) bool { return l.paths[i].Index < l.paths[j].Index })
+	return l, nil
+}
+
+// ParseCIDR accepts a CIDR or a bare IP, which becomes a single host.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip or cidr %q", s)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip or cidr %q", s)
+	}
+	return n, nil
+}
+
+// Segment names the segment holding ip; the longest prefix wins. Empty
+// when no segment matches.
+func (l *Labeler) Segment(ip string) string {
+	if l == nil {
+		return ""
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	best, bestLen := "", -1
+	for _, s := range l.segments {
+		for _, n := range s.nets {
+			if ones, _ := n.Mask.Size(); n.Contains(addr) && ones > bestLen {
+				best, bestLen = s.name, ones
+			}
+		}
+	}
+	return best
+}
+
+// Label finds the path a flow between srcIP and dstIP belongs to, in
+// either direction so requests and responses share it.
+func (l *Labeler) Label(srcIP, dstIP string) (Path, bool) {
+	if l == nil || len(l.paths) == 0 {
+		return Path{}, false
+	}
+	src, dst := l.Segment(srcIP), l.Segment(dstIP)
+	if src == "" || dst == "" {
+		return Path{}, false
+	}
+	for _, p := range l.paths {
+		if (p.Src == src && p.Dest == dst) || (p.Src == dst && p.Dest == src) {
+			return p, true
+		}
+	}
+	return Path{}, false
+}
+
+// Paths lists the configured paths ordered by index.
+func (l *Labeler) Paths() []Path {
+	if l == nil {
+		return nil
+	}
+	return append([]Path(nil), l.paths...)
+}
@@ -0,0 +1,323 @@
+// Package dashboard serves an embedded live view of the capture: TPS,
+// latency percentiles, recent transactions (masked) and declines, pushed to
+// the browser over server-sent events.
+package dashboard
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/constants"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/parser"
+	"github.com/msn60/isotcpdump/report"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+const (
+	tpsWindow     = 10 // seconds averaged for TPS
+	seriesSeconds = 60 // seconds kept for the TPS chart
+	latencySample = 1000
+)
+
+// Tx is one row of the recent transactions table.
+type Tx struct {
+	Time     time.Time      `json:"time"`
+	Kind     string         `json:"kind"`
+	Outcome  string         `json:"outcome"`
+	MTI      string         `json:"mti"`
+	Server   string         `json:"server"`
+	Code     string         `json:"code"`
+	LatencyM float64        `json:"latency_ms"`
+	Fields   map[int]string `json:"fields"` // masked
+}
+
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+type CodeCount struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Outcome     string `json:"outcome"`
+	Count       int    `json:"count"`
+}
+
+// State is what the page renders on every push.
+type State struct {
+	CaptureTime time.Time       `json:"capture_time"`
+	Messages    int             `json:"messages"`
+	TPS         float64         `json:"tps"`
+	Series      []int           `json:"series"` // messages per second, oldest first
+	LatencyMS   Percentiles     `json:"latency_ms"`
+	Outcomes    map[string]int  `json:"outcomes"`
+	Codes       []CodeCount     `json:"codes"`
+	Recent      []Tx            `json:"recent"`
+	Counters    stream.Counters `json:"counters"`
+}
+
+type Dashboard struct {
+	mu       sync.Mutex
+	servers  []config.Server
+	codes    map[string]constants.ResponseCode
+	masks    []parser.MaskRule
+	interval time.Duration
+	agg      *stream.Aggregator
+
+	now       time.Time
+	messages  int
+	perSecond map[int64]int
+	latencies []float64 // ring, ms
+	latPos    int
+	outcomes  map[string]int
+	codeCount map[string]int
+	recent    []Tx // ring
+	recentPos int
+
+	srv  *http.Server
+	addr string
+}
+
+func New(cfg *config.Config, agg *stream.Aggregator) *Dashboard {
+	interval, err := time.ParseDuration(strings.TrimSpace(cfg.Dashboard.Interval))
+	if err != nil || interval <= 0 {
+		interval = time.Second
+	}
+	recent := cfg.Dashboard.Recent
+	if recent <= 0 {
+		recent = 50
+	}
+	return &Dashboard{
+		servers:   cfg.Server,
+		codes:     report.OptionsFromConfig(cfg).Codes,
+		masks:     parser.MaskRulesFromConfig(cfg),
+		interval:  interval,
+		agg:       agg,
+		perSecond: make(map[int64]int),
+		latencies: make([]float64, 0, latencySample),
+		outcomes:  make(map[string]int),
+		codeCount: make(map[string]int),
+		recent:    make([]Tx, 0, recent),
+	}
+}
+
+// SetConfig picks up the servers, response codes and masking rules of a
+// reloaded config; rows already shown are not masked again.
+func (d *Dashboard) SetConfig(cfg *config.Config) {
+	codes := report.OptionsFromConfig(cfg).Codes
+	masks := parser.MaskRulesFromConfig(cfg)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers, d.codes, d.masks = cfg.Server, codes, masks
+}
+
+// ObserveRecord feeds TPS; hook it with Aggregator.OnRecord.
+func (d *Dashboard) ObserveRecord(rec stream.Record) {
+	if rec.Msg == nil || rec.Duplicate != "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if rec.Time.After(d.now) {
+		d.now = rec.Time
+	}
+	d.messages++
+	sec := rec.Time.Unix()
+	d.perSecond[sec]++
+	for s := range d.perSecond {
+		if s <= d.now.Unix()-seriesSeconds {
+			delete(d.perSecond, s)
+		}
+	}
+}
+
+// ObserveEvent feeds latency, outcomes and recent transactions; hook it
+// with Matcher.OnEvent.
+func (d *Dashboard) ObserveEvent(ev matcher.Event) {
+	if ev.Kind == matcher.KindLate {
+		return
+	}
+	tx := Tx{Kind: string(ev.Kind), Outcome: constants.OutcomeTimeout}
+	rec := ev.Request
+	if rec == nil {
+		rec = ev.Response
+	}
+	tx.Time = rec.Time
+	tx.MTI = rec.Msg.MTI
+	if ev.Kind == matcher.KindMatched {
+		tx.LatencyM = float64(ev.Latency) / float64(time.Millisecond)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tx.Server = stream.ServerName(d.servers, rec)
+	tx.Fields = rec.Msg.Masked(d.masks)
+	if r := ev.Response; r != nil {
+		tx.Code = r.Msg.Field(39)
+		tx.Outcome = constants.OutcomeDeclined
+		if rc, ok := d.codes[tx.Code]; ok {
+			tx.Outcome = rc.Outcome
+		}
+	}
+	d.outcomes[tx.Outcome]++
+	d.codeCount[tx.Code]++
+	if ev.Kind == matcher.KindMatched {
+		if len(d.latencies) < latencySample {
+			d.latencies = append(d.latencies, tx.LatencyM)
+		} else {
+			d.latencies[d.latPos] = tx.LatencyM
+			d.latPos = (d.latPos + 1) % latencySample
+		}
+	}
+	if len(d.recent) < cap(d.recent) {
+		d.recent = append(d.recent, tx)
+	} else {
+		d.recent[d.recentPos] = tx
+		d.recentPos = (d.recentPos + 1) % cap(d.recent)
+	}
+}
+
+func (d *Dashboard) State() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	st := State{
+		CaptureTime: d.now,
+		Messages:    d.messages,
+		Series:      make([]int, seriesSeconds),
+		Outcomes:    make(map[string]int, len(d.outcomes)),
+	}
+	if d.agg != nil {
+		st.Counters = d.agg.Counters()
+	}
+	last := d.now.Unix()
+	for i := range st.Series {
+		st.Series[i] = d.perSecond[last-int64(seriesSeconds-1-i)]
+	}
+	sum := 0
+	for _, n := range st.Series[seriesSeconds-tpsWindow:] {
+		sum += n
+	}
+	st.TPS = float64(sum) / tpsWindow
+
+	lat := append([]float64(nil), d.latencies...)
+	sort.Float64s(lat)
+	st.LatencyMS = Percentiles{P50: pct(lat, .50), P90: pct(lat, .90), P99: pct(lat, .99), Max: pct(lat, 1)}
+
+	for k, v := range d.outcomes {
+		st.Outcomes[k] = v
+	}
+	for code, n := range d.codeCount {
+		cc := CodeCount{Code: code, Count: n, Description: "no response", Outcome: constants.OutcomeTimeout}
+		if code != "" {
+			cc.Description, cc.Outcome = "unknown", constants.OutcomeDeclined
+			if rc, ok := d.codes[code]; ok {
+				cc.Description, cc.Outcome = rc.Description, rc.Outcome
+			}
+		}
+		st.Codes = append(st.Codes, cc)
+	}
+	sort.Slice(st.Codes, func(i, j int) bool { return st.Codes[i].Count > st.Codes[j].Count })
+
+	// newest first
+	n := len(d.recent)
+	for i := 0; i < n; i++ {
+		st.Recent = append(st.Recent, d.recent[(d.recentPos+n-1-i)%n])
+	}
+	return st
+}
+
+// Handler serves the page on "/", a JSON snapshot on "/state" and the SSE
+// feed on "/events".
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(indexHTML)
+	})
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d.State())
+	})
+	mux.HandleFunc("/events", d.serveEvents)
+	return mux
+}
+
+func (d *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		b, err := json.Marshal(d.State())
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start listens on addr and serves in the background.
+func (d *Dashboard) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	d.addr = ln.Addr().String()
+	d.srv = &http.Server{Handler: d.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = d.srv.Serve(ln) }()
+	return nil
+}
+
+func (d *Dashboard) Addr() string { return d.addr }
+
+func (d *Dashboard) Shutdown(ctx context.Context) error {
+	if d.srv == nil {
+		return nil
+	}
+	return d.srv.Shutdown(ctx)
+}
+
+// pct expects sorted values.
+func pct(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted)-1) + 0.5)
+	return sorted[i]
+}
@@ -0,0 +1,382 @@
+// Package golden checks the analysis pipeline against checked-in results.
+// Every directory under a root that holds a config.yaml is a case. Its
+// capture is capture.pcap when present, otherwise the one pcapgen writes
+// from the generate section, which then needs a fixed seed. Each output of
+// the run is compared byte for byte with <name>.golden next to it.
+package golden
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/msn60/isotcpdump/assertions"
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/pcapgen"
+	"github.com/msn60/isotcpdump/pipeline"
+	"github.com/msn60/isotcpdump/stream"
+	"github.com/rs/zerolog"
+)
+
+const (
+	ConfigFile  = "config.yaml"
+	CaptureFile = "capture.pcap"
+	Ext         = ".golden"
+)
+
+// Mismatch is one output that differs from its golden file at Line, or,
+// with Note set, is missing on one side.
+type Mismatch struct {
+	File string
+	Line int
+	Want string
+	Got  string
+	Note string
+}
+
+func (m Mismatch) String() string {
+	if m.Note != "" {
+		return m.File + ": " + m.Note
+	}
+	return fmt.Sprintf("%s:%d:\n      want %q\n      got  %q", m.File, m.Line, m.Want, m.Got)
+}
+
+// CaseResult is the outcome of one case.
+type CaseResult struct {
+	Name       string
+	Updated    []string // with update: golden files written or removed
+	Mismatches []Mismatch
+	Err        error
+}
+
+func (r CaseResult) OK() bool { return r.Err == nil && len(r.Mismatches) == 0 }
+
+// Cases lists the case directories under root, sorted.
+func Cases(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && d.Name() == ConfigFile {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("golden: %w", err)
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("golden: no %s under %s", ConfigFile, root)
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// Check runs the case in dir and compares its outputs; with update it
+// rewrites the golden files instead and reports what changed.
+func Check(dir string, update bool) CaseResult {
+	r := CaseResult{Name: filepath.Base(dir)}
+	got, err := Outputs(dir)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*"+Ext))
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	for _, path := range old {
+		name := strings.TrimSuffix(filepath.Base(path), Ext)
+		if _, ok := got[name]; ok {
+			continue
+		}
+		if update {
+			if r.Err = os.Remove(path); r.Err != nil {
+				return r
+			}
+			r.Updated = append(r.Updated, filepath.Base(path))
+			continue
+		}
+		r.Mismatches = append(r.Mismatches, Mismatch{File: name, Note: "golden file no longer produced"})
+	}
+
+	names := make([]string, 0, len(got))
+	for name := range got {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(dir, name+Ext)
+		want, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			r.Err = err
+			return r
+		}
+		if bytes.Equal(want, got[name]) && err == nil {
+			continue
+		}
+		if update {
+			if r.Err = os.WriteFile(path, got[name], 0o644); r.Err != nil {
+				return r
+			}
+			r.Updated = append(r.Updated, filepath.Base(path))
+			continue
+		}
+		if err != nil {
+			r.Mismatches = append(r.Mismatches, Mismatch{File: name, Note: "no golden file; run with -update"})
+			continue
+		}
+		r.Mismatches = append(r.Mismatches, firstDiff(name, want, got[name]))
+	}
+	return r
+}
+
+func firstDiff(name string, want, got []byte) Mismatch {
+	w := strings.Split(string(want), "\n")
+	g := strings.Split(string(got), "\n")
+	for i := 0; i < len(w) || i < len(g); i++ {
+		var a, b string
+		if i < len(w) {
+			a = w[i]
+		}
+		if i < len(g) {
+			b = g[i]
+		}
+		if a != b || i >= len(w) || i >= len(g) {
+			return Mismatch{File: name, Line: i + 1, Want: a, Got: b}
+		}
+	}
+	return Mismatch{File: name, Line: 1}
+}
+
+// Outputs runs the case in dir through the pipeline and renders what it
+// produced, by output name.
+func Outputs(dir string) (map[string][]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if err != nil {
+		return nil, fmt.Errorf("golden: %w", err)
+	}
+	// hermetic: no profile, .env or environment layers
+	cfg, err := config.NewLoader().LoadBytes(data, "yaml")
+	if err != nil {
+		return nil, fmt.Errorf("golden: %s: %w", dir, err)
+	}
+	cfg.App.Interface = ""
+	cfg.Metrics.Enable = false
+	cfg.Dashboard.Enable = false
+	cfg.SNMP.Enable = false
+	cfg.Report.Path = ""
+	cfg.Output.ReportStatus = false
+
+	source := CaptureFile
+	capture, err := os.ReadFile(filepath.Join(dir, CaptureFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		source = "generated"
+		if capture, err = generate(cfg); err != nil {
+			return nil, fmt.Errorf("golden: %s: %w", dir, err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("golden: %w", err)
+	}
+	r, err := pcapgo.NewReader(bytes.NewReader(capture))
+	if err != nil {
+		return nil, fmt.Errorf("golden: %s: %w", dir, err)
+	}
+
+	var summary bytes.Buffer
+	app := config.NewApp(cfg).WithLogger(zerolog.Nop())
+	res := pipeline.Run(app, pipeline.Options{
+		Source:     gopacket.NewPacketSource(utcSource{r}, r.LinkType()),
+		SourceName: source,
+		MaxCSVRows: 1000,
+		Stdout:     &summary,
+		Now:        func() time.Time { return epoch }, // no wall-clock duration or throughput
+	})
+
+	out := map[string][]byte{"summary.txt": summary.Bytes()}
+	add := func(name string, render func(io.Writer) error) error {
+		var b bytes.Buffer
+		if err := render(&b); err != nil {
+			return fmt.Errorf("golden: %s: %w", name, err)
+		}
+		out[name] = b.Bytes()
+		return nil
+	}
+	snap := res.Snapshot
+	rowHeader := []string{"time", "key", "duplicate"}
+	if err := add("summary.json", func(w io.Writer) error { return res.Summary.Write(w, "json") }); err != nil {
+		return nil, err
+	}
+	if err := add("snapshot.json", func(w io.Writer) error { return writeSnapshot(w, res) }); err != nil {
+		return nil, err
+	}
+	if err := add("input.csv", func(w io.Writer) error { return writeCSV(w, rowHeader, snap.InputRows) }); err != nil {
+		return nil, err
+	}
+	if err := add("output.csv", func(w io.Writer) error { return writeCSV(w, rowHeader, snap.OutputRows) }); err != nil {
+		return nil, err
+	}
+	if err := add("records.csv", func(w io.Writer) error { return writeRecords(w, snap.Records) }); err != nil {
+		return nil, err
+	}
+	if err := add("events.csv", func(w io.Writer) error { return writeEvents(w, res.Matcher.Events) }); err != nil {
+		return nil, err
+	}
+	if ar := assertions.Check(cfg, res); len(ar.Checked) > 0 {
+		if err := add("assertions.txt", ar.WriteText); err != nil {
+			return nil, err
+		}
+	}
+	if res.Decline != nil {
+		if err := add("decline.json", res.Decline.WriteJSON); err != nil {
+			return nil, err
+		}
+		if err := add("decline.csv", res.Decline.WriteCSV); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// epoch is the clock of every run.
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func generate(cfg *config.Config) ([]byte, error) {
+	if cfg.Generate.Seed == 0 {
+		return nil, fmt.Errorf("no %s and no generate.seed", CaptureFile)
+	}
+	opts, err := pcapgen.OptionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if _, err := pcapgen.Generate(&b, opts); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// utcSource stamps packets in UTC so outputs do not depend on the host's
+// time zone.
+type utcSource struct{ gopacket.PacketDataSource }
+
+func (s utcSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.PacketDataSource.ReadPacketData()
+	ci.Timestamp = ci.Timestamp.UTC()
+	return data, ci, err
+}
+
+// snapshot is the aggregator and matcher totals, without the per-message
+// detail the CSV outputs hold.
+type snapshot struct {
+	stream.Counters
+	InputMessages           int                 `json:"input_messages"`
+	OutputMessages          int                 `json:"output_messages"`
+	DuplicateInputMessages  int                 `json:"duplicate_input_messages"`
+	DuplicateOutputMessages int                 `json:"duplicate_output_messages"`
+	Retransmissions         int                 `json:"retransmissions"`
+	Resends                 int                 `json:"resends"`
+	InputRows               int                 `json:"input_rows"`
+	OutputRows              int                 `json:"output_rows"`
+	Records                 int                 `json:"records"`
+	DroppedRecords          int                 `json:"dropped_records"`
+	ByPath                  []stream.PathCounts `json:"by_path"`
+	Matcher                 matcher.Stats       `json:"matcher"`
+}
+
+func writeSnapshot(w io.Writer, res *pipeline.Result) error {
+	s := res.Snapshot
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snapshot{
+		Counters:                s.Counters,
+		InputMessages:           s.TotalInputMessages,
+		OutputMessages:          s.TotalOutputMessages,
+		DuplicateInputMessages:  s.DuplicateInputMessages,
+		DuplicateOutputMessages: s.DuplicateOutputMessages,
+		Retransmissions:         s.Retransmissions,
+		Resends:                 s.Resends,
+		InputRows:               len(s.InputRows),
+		OutputRows:              len(s.OutputRows),
+		Records:                 len(s.Records),
+		DroppedRecords:          s.DroppedRecords,
+		ByPath:                  s.ByPath,
+		Matcher:                 res.Matcher.Stats,
+	})
+}
+
+func writeCSV(w io.Writer, header []string, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	return cw.WriteAll(rows)
+}
+
+func writeRecords(w io.Writer, recs []stream.Record) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"time", "direction", "src", "dst", "mti", "stan", "rrn", "rc", "duplicate", "path", "parse_error"})
+	for _, r := range recs {
+		parseErr := ""
+		if r.ParseErr != nil {
+			parseErr = r.ParseErr.Error()
+		}
+		var mti string
+		if r.Msg != nil {
+			mti = r.Msg.MTI
+		}
+		_ = cw.Write([]string{
+			r.Time.Format(time.RFC3339Nano),
+			string(r.Direction),
+			net.JoinHostPort(r.SrcIP, strconv.Itoa(r.SrcPort)),
+			net.JoinHostPort(r.DstIP, strconv.Itoa(r.DstPort)),
+			mti, r.Msg.Field(11), r.Msg.Field(37), r.Msg.Field(39),
+			string(r.Duplicate), r.Path, parseErr,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeEvents(w io.Writer, events []matcher.Event) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"kind", "request_mti", "stan", "rrn", "response_mti", "rc", "latency", "original_stan"})
+	for _, ev := range events {
+		row := make([]string, 8)
+		row[0] = string(ev.Kind)
+		if r := ev.Request; r != nil && r.Msg != nil {
+			row[1], row[2], row[3] = r.Msg.MTI, r.Msg.Field(11), r.Msg.Field(37)
+		}
+		if r := ev.Response; r != nil && r.Msg != nil {
+			row[4], row[5] = r.Msg.MTI, r.Msg.Field(39)
+			if row[2] == "" {
+				row[2], row[3] = r.Msg.Field(11), r.Msg.Field(37)
+			}
+		}
+		if ev.Latency > 0 {
+			row[6] = ev.Latency.String()
+		}
+		if o := ev.Original; o != nil {
+			row[7] = o.Msg.Field(11)
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
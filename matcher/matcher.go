@@ -1 +1,360 @@
+// Package matcher links ISO requests with their responses on capture time,
+// emitting timeout, late, orphan and reversal events.
 package matcher
+
+import (
+	"container/heap"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/parser"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+type Kind string
+
+const (
+	KindMatched        Kind = "matched"
+	KindTimeout        Kind = "timeout"         // request unanswered after its timeout
+	KindLate           Kind = "late"            // response after its request timed out
+	KindOrphanRequest  Kind = "orphan_request"  // capture ended, or a new request took its key, before response or timeout
+	KindOrphanResponse Kind = "orphan_response" // response without a known request
+)
+
+// Event is the outcome of one request and/or response. Original is set on
+// reversals (04xx) whose DE90 points to a request seen in this capture.
+type Event struct {
+	Kind     Kind
+	Request  *stream.Record
+	Response *stream.Record
+	Original *stream.Record
+	Latency  time.Duration
+}
+
+type Stats struct {
+	Requests          int `json:"requests"`
+	Responses         int `json:"responses"`
+	Matched           int `json:"matched"`
+	Timeouts          int `json:"timeouts"`
+	Late              int `json:"late"`
+	OrphanRequests    int `json:"orphan_requests"`
+	OrphanResponses   int `json:"orphan_responses"`
+	ReversalsLinked   int `json:"reversals_linked"`
+	ReversalsUnlinked int `json:"reversals_unlinked"`
+}
+
+type Result struct {
+	Stats
+	Events        []Event
+	DroppedEvents int
+}
+
+// Options for the matcher. Timeouts are keyed by MTI class (second MTI
+// digit, e.g. "2" for financial, "4" for reversal).
+type Options struct {
+	DefaultTimeout time.Duration
+	Timeouts       map[string]time.Duration
+	LateWindow     time.Duration // how long timed-out requests wait for a late response
+	ReversalWindow time.Duration // how long originals stay linkable from DE90
+	MaxEvents      int           // events kept in Result; 0 keeps none
+}
+
+func OptionsFromConfig(cfg *config.Config) Options {
+	mc := cfg.Matcher
+	o := Options{
+		DefaultTimeout: parseDuration(mc.DefaultTimeout, 30*time.Second),
+		Timeouts:       make(map[string]time.Duration, len(mc.Timeouts)),
+		LateWindow:     parseDuration(mc.LateWindow, 5*time.Minute),
+		ReversalWindow: parseDuration(mc.ReversalWindow, 24*time.Hour),
+		MaxEvents:      cfg.Limits.MaxRecords,
+	}
+	for class, d := range mc.Timeouts {
+		if v := parseDuration(d, 0); v > 0 {
+			o.Timeouts[strings.TrimSpace(class)] = v
+		}
+	}
+	return o
+}
+
+func (o Options) withDefaults() Options {
+	if o.DefaultTimeout <= 0 {
+		o.DefaultTimeout = 30 * time.Second
+	}
+	if o.LateWindow <= 0 {
+		o.LateWindow = 5 * time.Minute
+	}
+	if o.ReversalWindow <= 0 {
+		o.ReversalWindow = 24 * time.Hour
+	}
+	return o
+}
+
+// --------- Matcher ---------
+
+type pendingReq struct {
+	key      string
+	rec      *stream.Record
+	original *stream.Record
+	deadline time.Time
+	done     bool
+	index    int
+}
+
+type aged struct {
+	key string
+	at  time.Time
+}
+
+type Matcher struct {
+	mu      sync.Mutex
+	opts    Options
+	now     time.Time
+	onEvent func(Event)
+
+	pending   map[string]*pendingReq
+	deadlines deadlineHeap
+	expired   map[string]*pendingReq // timed out, may still get a late response
+	expiredQ  []aged
+	originals map[string]*stream.Record // by DE90 key (MTI+STAN+DE7)
+	originalQ []aged
+
+	stats         Stats
+	events        []Event
+	droppedEvents int
+}
+
+func New(opts Options) *Matcher {
+	return &Matcher{
+		opts:      opts.withDefaults(),
+		pending:   make(map[string]*pendingReq),
+		expired:   make(map[string]*pendingReq),
+		originals: make(map[string]*stream.Record),
+	}
+}
+
+// SetOptions swaps the timeouts and windows. Pending requests keep the
+// deadline they were given; new requests use the new timeouts.
+func (m *Matcher) SetOptions(opts Options) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.opts = opts.withDefaults()
+}
+
+// OnEvent registers fn to be called for every event, in capture order.
+func (m *Matcher) OnEvent(fn func(Event)) *Matcher {
+	m.onEvent = fn
+	return m
+}
+
+// Observe feeds one record; the capture clock advances to its timestamp.
+// Duplicates are ignored so a resent request does not replace the original.
+func (m *Matcher) Observe(rec stream.Record) {
+	if rec.Msg == nil || rec.Duplicate != "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.advance(rec.Time)
+	r := &rec
+	key := r.Msg.MatchKey()
+
+	switch {
+	case parser.IsRequest(r.Msg.MTI):
+		m.stats.Requests++
+		p := &pendingReq{key: key, rec: r, deadline: r.Time.Add(m.timeout(r.Msg.MTI))}
+		if isReversal(r.Msg.MTI) {
+			if orig, ok := m.originals[originalKeyFromDE90(r.Msg.Field(90))]; ok {
+				p.original = orig
+				m.stats.ReversalsLinked++
+			} else {
+				m.stats.ReversalsUnlinked++
+			}
+		} else if k := originalKey(r.Msg); k != "" {
+			m.originals[k] = r
+			m.originalQ = append(m.originalQ, aged{k, r.Time})
+		}
+		if old, ok := m.pending[key]; ok {
+			// a response now matches the new request, so the old one
+			// can no longer be answered
+			old.done = true
+			m.stats.OrphanRequests++
+			m.emit(Event{Kind: KindOrphanRequest, Request: old.rec, Original: old.original})
+		}
+		m.pending[key] = p
+		heap.Push(&m.deadlines, p)
+
+	case parser.IsResponse(r.Msg.MTI):
+		m.stats.Responses++
+		if p, ok := m.pending[key]; ok {
+			p.done = true
+			delete(m.pending, key)
+			m.stats.Matched++
+			m.emit(Event{Kind: KindMatched, Request: p.rec, Response: r, Original: p.original, Latency: r.Time.Sub(p.rec.Time)})
+			return
+		}
+		if p, ok := m.expired[key]; ok {
+			delete(m.expired, key)
+			m.stats.Late++
+			m.emit(Event{Kind: KindLate, Request: p.rec, Response: r, Original: p.original, Latency: r.Time.Sub(p.rec.Time)})
+			return
+		}
+		m.stats.OrphanResponses++
+		m.emit(Event{Kind: KindOrphanResponse, Response: r})
+	}
+}
+
+// Advance moves the capture clock to now, expiring overdue requests. Live
+// capture calls it periodically so timeouts fire without new traffic.
+func (m *Matcher) Advance(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advance(now)
+}
+
+// Flush reports every request still pending as an orphan; call it once
+// the capture is complete.
+func (m *Matcher) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for m.deadlines.Len() > 0 {
+		p := heap.Pop(&m.deadlines).(*pendingReq)
+		if p.done {
+			continue
+		}
+		delete(m.pending, p.key)
+		m.stats.OrphanRequests++
+		m.emit(Event{Kind: KindOrphanRequest, Request: p.rec, Original: p.original})
+	}
+}
+
+// Pending returns the number of requests waiting for a response.
+func (m *Matcher) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.pending)
+}
+
+func (m *Matcher) Snapshot() Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ev := make([]Event, len(m.events))
+	copy(ev, m.events)
+	return Result{Stats: m.stats, Events: ev, DroppedEvents: m.droppedEvents}
+}
+
+// ---- internals (m.mu held) ----
+
+func (m *Matcher) advance(now time.Time) {
+	if now.Before(m.now) {
+		return
+	}
+	m.now = now
+
+	for m.deadlines.Len() > 0 && !m.deadlines[0].deadline.After(now) {
+		p := heap.Pop(&m.deadlines).(*pendingReq)
+		if p.done {
+			continue
+		}
+		delete(m.pending, p.key)
+		m.expired[p.key] = p
+		m.expiredQ = append(m.expiredQ, aged{p.key, p.deadline})
+		m.stats.Timeouts++
+		m.emit(Event{Kind: KindTimeout, Request: p.rec, Original: p.original})
+	}
+
+	m.expiredQ = prune(m.expiredQ, now.Add(-m.opts.LateWindow), func(k string, at time.Time) {
+		if p, ok := m.expired[k]; ok && !p.deadline.After(at) {
+			delete(m.expired, k)
+		}
+	})
+	m.originalQ = prune(m.originalQ, now.Add(-m.opts.ReversalWindow), func(k string, at time.Time) {
+		if r, ok := m.originals[k]; ok && !r.Time.After(at) {
+			delete(m.originals, k)
+		}
+	})
+}
+
+func (m *Matcher) emit(ev Event) {
+	if len(m.events) < m.opts.MaxEvents {
+		m.events = append(m.events, ev)
+	} else {
+		m.droppedEvents++
+	}
+	if m.onEvent != nil {
+		m.onEvent(ev)
+	}
+}
+
+func (m *Matcher) timeout(mti string) time.Duration {
+	if len(mti) == 4 {
+		if d, ok := m.opts.Timeouts[mti[1:2]]; ok {
+			return d
+		}
+	}
+	return m.opts.DefaultTimeout
+}
+
+// ---- helpers ----
+
+func prune(q []aged, before time.Time, drop func(string, time.Time)) []aged {
+	i := 0
+	for ; i < len(q) && q[i].at.Before(before); i++ {
+		drop(q[i].key, q[i].at)
+	}
+	return q[i:]
+}
+
+func isReversal(mti string) bool {
+	return len(mti) == 4 && mti[1] == '4'
+}
+
+// originalKey is the DE90 identity of a request: MTI, STAN and DE7.
+func originalKey(msg *parser.Message) string {
+	if !msg.Has(11) || !msg.Has(7) {
+		return ""
+	}
+	return msg.MTI + msg.Field(11) + msg.Field(7)
+}
+
+// originalKeyFromDE90 reads original MTI (4), STAN (6) and transmission
+// date & time (10) from DE90.
+func originalKeyFromDE90(de90 string) string {
+	if len(de90) < 20 {
+		return ""
+	}
+	return de90[:20]
+}
+
+func parseDuration(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(s)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// deadlineHeap orders pending requests by deadline.
+type deadlineHeap []*pendingReq
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].deadline.Before(h[j].deadline) }
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *deadlineHeap) Push(x any) {
+	p := x.(*pendingReq)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+func (h *deadlineHeap) Pop() any {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return p
+}
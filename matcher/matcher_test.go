@@ -0,0 +1,125 @@
+package matcher_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/parser"
+	"github.com/msn60/isotcpdump/pcapgen"
+	"github.com/msn60/isotcpdump/pcapgen/pcapgentest"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+const messages = pcapgentest.Messages
+
+// match runs the generated conversation with faults through a matcher.
+func match(t *testing.T, opts matcher.Options, faults pcapgen.Faults) (*pcapgen.Stats, matcher.Stats) {
+	t.Helper()
+	agg := stream.NewAggregator(0).WithDuplicateWindow(30 * time.Second)
+	m := matcher.New(opts)
+	agg.OnRecord(m.Observe)
+	gs := pcapgentest.Capture(t, faults, agg)
+	m.Flush()
+	ms := m.Snapshot().Stats
+
+	// every request and response ends up in exactly one outcome
+	if got := ms.Matched + ms.Timeouts + ms.OrphanRequests; got != ms.Requests {
+		t.Errorf("matched+timeouts+orphan requests = %d, want requests %d", got, ms.Requests)
+	}
+	if got := ms.Matched + ms.Late + ms.OrphanResponses; got != ms.Responses {
+		t.Errorf("matched+late+orphan responses = %d, want responses %d", got, ms.Responses)
+	}
+	return gs, ms
+}
+
+func wantAllMatched(t *testing.T, ms matcher.Stats) {
+	t.Helper()
+	want := matcher.Stats{Requests: messages, Responses: messages, Matched: messages}
+	if ms != want {
+		t.Errorf("stats %+v, want %+v", ms, want)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults pcapgen.Faults
+		check  func(*testing.T, *pcapgen.Stats, matcher.Stats)
+	}{
+		{"clean", pcapgen.Faults{}, func(t *testing.T, _ *pcapgen.Stats, ms matcher.Stats) { wantAllMatched(t, ms) }},
+		{"loss", pcapgen.Faults{Loss: 0.02}, func(t *testing.T, gs *pcapgen.Stats, ms matcher.Stats) {
+			// a lost message leaves its other half unmatched
+			if gs.Lost == 0 {
+				t.Fatal("no segment lost")
+			}
+			if got := ms.OrphanRequests + ms.OrphanResponses; got != gs.Lost {
+				t.Errorf("orphans %d, want %d", got, gs.Lost)
+			}
+			if want := messages - gs.Lost; ms.Matched != want {
+				t.Errorf("matched %d, want %d", ms.Matched, want)
+			}
+		}},
+		{"reorder", pcapgen.Faults{Reorder: 0.1}, func(t *testing.T, gs *pcapgen.Stats, ms matcher.Stats) {
+			// a request captured after the next one is stamped after its
+			// response too, which then has nothing to match
+			if gs.Reordered == 0 {
+				t.Fatal("no segment reordered")
+			}
+			if ms.Requests != messages || ms.Responses != messages {
+				t.Errorf("requests %d, responses %d, want %d each", ms.Requests, ms.Responses, messages)
+			}
+			if ms.OrphanResponses == 0 || ms.OrphanResponses != ms.OrphanRequests {
+				t.Errorf("orphan responses %d, orphan requests %d, want the same, not 0", ms.OrphanResponses, ms.OrphanRequests)
+			}
+		}},
+		{"retransmit", pcapgen.Faults{Retransmit: 0.1}, func(t *testing.T, _ *pcapgen.Stats, ms matcher.Stats) { wantAllMatched(t, ms) }},
+		{"split", pcapgen.Faults{Split: 0.3}, func(t *testing.T, _ *pcapgen.Stats, ms matcher.Stats) { wantAllMatched(t, ms) }},
+		{"merge", pcapgen.Faults{Merge: 0.2}, func(t *testing.T, _ *pcapgen.Stats, ms matcher.Stats) { wantAllMatched(t, ms) }},
+		{"reset", pcapgen.Faults{Reset: 0.1}, func(t *testing.T, _ *pcapgen.Stats, ms matcher.Stats) { wantAllMatched(t, ms) }},
+		{"garbage", pcapgen.Faults{Garbage: 0.1}, func(t *testing.T, _ *pcapgen.Stats, ms matcher.Stats) { wantAllMatched(t, ms) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, ms := match(t, matcher.Options{}, tt.faults)
+			tt.check(t, gs, ms)
+		})
+	}
+}
+
+// Responses take 25-75ms: a 10ms timeout expires every request before its
+// response, which then arrives late.
+func TestTimeouts(t *testing.T) {
+	_, ms := match(t, matcher.Options{DefaultTimeout: 10 * time.Millisecond, LateWindow: time.Second}, pcapgen.Faults{})
+	want := matcher.Stats{Requests: messages, Responses: messages, Timeouts: messages, Late: messages}
+	if ms != want {
+		t.Errorf("stats %+v, want %+v", ms, want)
+	}
+}
+
+func record(at time.Time, mti, stan string) stream.Record {
+	return stream.Record{Time: at, Msg: &parser.Message{MTI: mti, Fields: map[int]string{11: stan, 37: "240101000001", 41: "TRM00001"}}}
+}
+
+// A request whose key is taken by a new one before any response is an
+// orphan; the response then matches the new request.
+func TestKeyReused(t *testing.T) {
+	m := matcher.New(matcher.Options{MaxEvents: 10})
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.Observe(record(t0, "0200", "000001"))
+	m.Observe(record(t0.Add(time.Second), "0200", "000001"))
+	m.Observe(record(t0.Add(2*time.Second), "0210", "000001"))
+	m.Flush()
+
+	res := m.Snapshot()
+	want := matcher.Stats{Requests: 2, Responses: 1, Matched: 1, OrphanRequests: 1}
+	if res.Stats != want {
+		t.Errorf("stats %+v, want %+v", res.Stats, want)
+	}
+	if len(res.Events) != 2 || res.Events[0].Kind != matcher.KindOrphanRequest || !res.Events[0].Request.Time.Equal(t0) {
+		t.Fatalf("events %+v, want the first request orphaned, then a match", res.Events)
+	}
+	if ev := res.Events[1]; ev.Kind != matcher.KindMatched || ev.Latency != time.Second {
+		t.Errorf("second event %s with latency %s, want matched after 1s", ev.Kind, ev.Latency)
+	}
+}
@@ -0,0 +1,158 @@
+// Package metrics exposes capture, framing and matcher statistics for
+// Prometheus on an optional local HTTP listener.
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/stream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "isotcp"
+
+// DefaultLatencyBuckets in seconds.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type Exporter struct {
+	reg     *prometheus.Registry
+	servers []config.Server
+
+	messages      *prometheus.CounterVec
+	duplicates    *prometheus.CounterVec
+	events        *prometheus.CounterVec
+	responseCodes *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+
+	srv  *http.Server
+	addr string
+}
+
+// New builds an exporter; counters of agg and the backlog of m are read at
+// scrape time. m may be nil.
+func New(cfg *config.Config, agg *stream.Aggregator, m *matcher.Matcher) *Exporter {
+	buckets := cfg.Metrics.LatencyBuckets
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	e := &Exporter{
+		reg:     prometheus.NewRegistry(),
+		servers: cfg.Server,
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "messages_total",
+			Help: "ISO messages framed, by MTI, direction and server.",
+		}, []string{"mti", "direction", "server"}),
+		duplicates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "duplicate_messages_total",
+			Help: "Duplicate ISO messages, by kind.",
+		}, []string{"kind"}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "matcher", Name: "events_total",
+			Help: "Matcher outcomes, by kind.",
+		}, []string{"kind"}),
+		responseCodes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "response_codes_total",
+			Help: "Responses by DE39, MTI and server.",
+		}, []string{"code", "mti", "server"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "latency_seconds",
+			Help:    "Request to response latency, by request MTI and server.",
+			Buckets: buckets,
+		}, []string{"mti", "server"}),
+	}
+
+	counter := func(name, help string, get func(stream.Counters) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help},
+			func() float64 { return float64(get(agg.Counters())) })
+	}
+	e.reg.MustRegister(
+		e.messages, e.duplicates, e.events, e.responseCodes, e.latency,
+		counter("packets_total", "TCP packets seen.", func(c stream.Counters) int64 { return c.Packets }),
+		counter("payload_packets_total", "TCP packets carrying payload.", func(c stream.Counters) int64 { return c.PayloadPackets }),
+		counter("flows_total", "TCP flows opened.", func(c stream.Counters) int64 { return c.Flows }),
+		counter("bytes_reassembled_total", "Bytes delivered by TCP reassembly.", func(c stream.Counters) int64 { return c.BytesReassembled }),
+		counter("framing_errors_total", "Invalid length headers, non-ISO frames and gaps.", func(c stream.Counters) int64 { return c.FramingErrors }),
+		counter("resync_bytes_total", "Bytes skipped while searching for a length header.", func(c stream.Counters) int64 { return c.ResyncBytes }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Name: "active_streams", Help: "TCP streams being reassembled."},
+			func() float64 { return float64(agg.Counters().ActiveStreams) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if m != nil {
+		e.reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "matcher", Name: "pending_requests",
+			Help: "Requests waiting for a response.",
+		}, func() float64 { return float64(m.Pending()) }))
+	}
+	return e
+}
+
+// SetServers changes the server label lookup, e.g. after a config reload.
+// Call it from the goroutine that feeds records.
+func (e *Exporter) SetServers(servers []config.Server) { e.servers = servers }
+
+// ObserveRecord counts a framed message; hook it with Aggregator.OnRecord.
+func (e *Exporter) ObserveRecord(rec stream.Record) {
+	if rec.Msg == nil {
+		return
+	}
+	server := stream.ServerName(e.servers, &rec)
+	if rec.Duplicate != "" {
+		e.duplicates.WithLabelValues(string(rec.Duplicate)).Inc()
+		return
+	}
+	e.messages.WithLabelValues(rec.Msg.MTI, string(rec.Direction), server).Inc()
+	if rec.Msg.Has(39) {
+		e.responseCodes.WithLabelValues(rec.Msg.Field(39), rec.Msg.MTI, server).Inc()
+	}
+}
+
+// ObserveEvent counts a matcher event; hook it with Matcher.OnEvent.
+func (e *Exporter) ObserveEvent(ev matcher.Event) {
+	e.events.WithLabelValues(string(ev.Kind)).Inc()
+	if ev.Kind == matcher.KindMatched && ev.Request != nil {
+		e.latency.WithLabelValues(ev.Request.Msg.MTI, stream.ServerName(e.servers, ev.Request)).Observe(ev.Latency.Seconds())
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func (e *Exporter) Handler() http.Handler {
+	return promhttp.HandlerFor(e.reg, promhttp.HandlerOpts{Registry: e.reg})
+}
+
+// Start listens on addr (e.g. "127.0.0.1:9108") and serves path in the
+// background. Listen errors are returned immediately.
+func (e *Exporter) Start(addr, path string) error {
+	if path = strings.TrimSpace(path); path == "" {
+		path = "/metrics"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, e.Handler())
+	e.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	e.addr = ln.Addr().String()
+	go func() { _ = e.srv.Serve(ln) }()
+	return nil
+}
+
+// Addr is the address actually listened on, useful with port 0.
+func (e *Exporter) Addr() string { return e.addr }
+
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	if e.srv == nil {
+		return nil
+	}
+	return e.srv.Shutdown(ctx)
+}
@@ -1 +1,37 @@
 package output
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+)
+
+// Create opens path for writing, creating its parent directories.
+func Create(path string) (*os.File, error) {
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return os.Create(path)
+}
+
+// WriteCSV writes header (if any) and rows to path.
+func WriteCSV(path string, header []string, rows [][]string) error {
+	f, err := Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if len(header) > 0 {
+		if err := w.Write(header); err != nil {
+			return err
+		}
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -1 +1,342 @@
+// Package parser decodes ASCII ISO 8583 messages (hex bitmap, ASCII length
+// prefixes) as they are seen on the wire after the 4-byte length header.
 package parser
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LengthType describes how the length of a data element is encoded.
+type LengthType int
+
+const (
+	Fixed  LengthType = iota // fixed size
+	LLVar                    // 2-digit ASCII length prefix
+	LLLVar                   // 3-digit ASCII length prefix
+)
+
+// FieldSpec describes a single data element. Length is the fixed size in
+// characters, or the maximum size for variable-length elements.
+type FieldSpec struct {
+	Name   string
+	Type   LengthType
+	Length int
+}
+
+// Spec is the ISO 8583:1987 field layout used by our switches. Binary fields
+// (bitmaps, PIN block, MAC) are carried as hex characters.
+var Spec = [129]FieldSpec{
+	1:   {"secondary bitmap", Fixed, 16},
+	2:   {"primary account number", LLVar, 19},
+	3:   {"processing code", Fixed, 6},
+	4:   {"amount, transaction", Fixed, 12},
+	5:   {"amount, settlement", Fixed, 12},
+	6:   {"amount, cardholder billing", Fixed, 12},
+	7:   {"transmission date & time", Fixed, 10},
+	8:   {"amount, cardholder billing fee", Fixed, 8},
+	9:   {"conversion rate, settlement", Fixed, 8},
+	10:  {"conversion rate, cardholder billing", Fixed, 8},
+	11:  {"system trace audit number", Fixed, 6},
+	12:  {"local transaction time", Fixed, 6},
+	13:  {"local transaction date", Fixed, 4},
+	14:  {"expiration date", Fixed, 4},
+	15:  {"settlement date", Fixed, 4},
+	16:  {"conversion date", Fixed, 4},
+	17:  {"capture date", Fixed, 4},
+	18:  {"merchant type", Fixed, 4},
+	19:  {"acquiring institution country code", Fixed, 3},
+	20:  {"pan extended country code", Fixed, 3},
+	21:  {"forwarding institution country code", Fixed, 3},
+	22:  {"point of service entry mode", Fixed, 3},
+	23:  {"application pan sequence number", Fixed, 3},
+	24:  {"network international identifier", Fixed, 3},
+	25:  {"point of service condition code", Fixed, 2},
+	26:  {"point of service capture code", Fixed, 2},
+	27:  {"authorizing identification response length", Fixed, 1},
+	28:  {"amount, transaction fee", Fixed, 9},
+	29:  {"amount, settlement fee", Fixed, 9},
+	30:  {"amount, transaction processing fee", Fixed, 9},
+	31:  {"amount, settlement processing fee", Fixed, 9},
+	32:  {"acquiring institution identification code", LLVar, 11},
+	33:  {"forwarding institution identification code", LLVar, 11},
+	34:  {"primary account number, extended", LLVar, 28},
+	35:  {"track 2 data", LLVar, 37},
+	36:  {"track 3 data", LLLVar, 104},
+	37:  {"retrieval reference number", Fixed, 12},
+	38:  {"authorization identification response", Fixed, 6},
+	39:  {"response code", Fixed, 2},
+	40:  {"service restriction code", Fixed, 3},
+	41:  {"card acceptor terminal identification", Fixed, 8},
+	42:  {"card acceptor identification code", Fixed, 15},
+	43:  {"card acceptor name/location", Fixed, 40},
+	44:  {"additional response data", LLVar, 25},
+	45:  {"track 1 data", LLVar, 76},
+	46:  {"additional data - iso", LLLVar, 999},
+	47:  {"additional data - national", LLLVar, 999},
+	48:  {"additional data - private", LLLVar, 999},
+	49:  {"currency code, transaction", Fixed, 3},
+	50:  {"currency code, settlement", Fixed, 3},
+	51:  {"currency code, cardholder billing", Fixed, 3},
+	52:  {"personal identification number data", Fixed, 16},
+	53:  {"security related control information", Fixed, 16},
+	54:  {"additional amounts", LLLVar, 120},
+	55:  {"icc data", LLLVar, 999},
+	56:  {"reserved iso", LLLVar, 999},
+	57:  {"reserved national", LLLVar, 999},
+	58:  {"reserved national", LLLVar, 999},
+	59:  {"reserved national", LLLVar, 999},
+	60:  {"reserved national", LLLVar, 999},
+	61:  {"reserved private", LLLVar, 999},
+	62:  {"reserved private", LLLVar, 999},
+	63:  {"reserved private", LLLVar, 999},
+	64:  {"message authentication code", Fixed, 16},
+	65:  {"extended bitmap indicator", Fixed, 1},
+	66:  {"settlement code", Fixed, 1},
+	67:  {"extended payment code", Fixed, 2},
+	68:  {"receiving institution country code", Fixed, 3},
+	69:  {"settlement institution country code", Fixed, 3},
+	70:  {"network management information code", Fixed, 3},
+	71:  {"message number", Fixed, 4},
+	72:  {"message number, last", Fixed, 4},
+	73:  {"action date", Fixed, 6},
+	74:  {"number of credits", Fixed, 10},
+	75:  {"credits, reversal number", Fixed, 10},
+	76:  {"number of debits", Fixed, 10},
+	77:  {"debits, reversal number", Fixed, 10},
+	78:  {"transfer number", Fixed, 10},
+	79:  {"transfer, reversal number", Fixed, 10},
+	80:  {"number of inquiries", Fixed, 10},
+	81:  {"number of authorizations", Fixed, 10},
+	82:  {"credits, processing fee amount", Fixed, 12},
+	83:  {"credits, transaction fee amount", Fixed, 12},
+	84:  {"debits, processing fee amount", Fixed, 12},
+	85:  {"debits, transaction fee amount", Fixed, 12},
+	86:  {"total amount of credits", Fixed, 16},
+	87:  {"credits, reversal amount", Fixed, 16},
+	88:  {"total amount of debits", Fixed, 16},
+	89:  {"debits, reversal amount", Fixed, 16},
+	90:  {"original data elements", Fixed, 42},
+	91:  {"file update code", Fixed, 1},
+	92:  {"file security code", Fixed, 2},
+	93:  {"response indicator", Fixed, 5},
+	94:  {"service indicator", Fixed, 7},
+	95:  {"replacement amounts", Fixed, 42},
+	96:  {"message security code", Fixed, 16},
+	97:  {"net settlement amount", Fixed, 17},
+	98:  {"payee", Fixed, 25},
+	99:  {"settlement institution identification code", LLVar, 11},
+	100: {"receiving institution identification code", LLVar, 11},
+	101: {"file name", LLVar, 17},
+	102: {"account identification 1", LLVar, 28},
+	103: {"account identification 2", LLVar, 28},
+	104: {"transaction description", LLLVar, 100},
+	105: {"reserved iso", LLLVar, 999},
+	106: {"reserved iso", LLLVar, 999},
+	107: {"reserved iso", LLLVar, 999},
+	108: {"reserved iso", LLLVar, 999},
+	109: {"reserved iso", LLLVar, 999},
+	110: {"reserved iso", LLLVar, 999},
+	111: {"reserved iso", LLLVar, 999},
+	112: {"reserved national", LLLVar, 999},
+	113: {"reserved national", LLLVar, 999},
+	114: {"reserved national", LLLVar, 999},
+	115: {"reserved national", LLLVar, 999},
+	116: {"reserved national", LLLVar, 999},
+	117: {"reserved national", LLLVar, 999},
+	118: {"reserved national", LLLVar, 999},
+	119: {"reserved national", LLLVar, 999},
+	120: {"reserved private", LLLVar, 999},
+	121: {"reserved private", LLLVar, 999},
+	122: {"reserved private", LLLVar, 999},
+	123: {"reserved private", LLLVar, 999},
+	124: {"reserved private", LLLVar, 999},
+	125: {"reserved private", LLLVar, 999},
+	126: {"reserved private", LLLVar, 999},
+	127: {"reserved private", LLLVar, 999},
+	128: {"message authentication code", Fixed, 16},
+}
+
+var ErrShortMessage = errors.New("parser: message too short")
+
+// Message is a decoded ISO 8583 message. Fields holds every data element
+// found in the bitmap(s), keyed by field number.
+type Message struct {
+	MTI    string
+	Fields map[int]string
+}
+
+// Field returns the value of data element n or "" when it is absent.
+func (m *Message) Field(n int) string {
+	if m == nil {
+		return ""
+	}
+	return m.Fields[n]
+}
+
+// Has reports whether data element n is present.
+func (m *Message) Has(n int) bool {
+	if m == nil {
+		return false
+	}
+	_, ok := m.Fields[n]
+	return ok
+}
+
+// Parse decodes msg (without the length header). On a field error the
+// message decoded so far is returned together with the error, so callers
+// can still use the MTI and leading fields.
+func Parse(msg []byte) (*Message, error) {
+	if len(msg) < 4+16 {
+		return nil, ErrShortMessage
+	}
+	m := &Message{
+		MTI:    string(msg[0:4]),
+		Fields: make(map[int]string),
+	}
+	pos := 4
+
+	bitmap, err := hex.DecodeString(string(msg[pos : pos+16]))
+	if err != nil {
+		return m, fmt.Errorf("parser: primary bitmap: %w", err)
+	}
+	pos += 16
+	if bitmap[0]&0x80 != 0 {
+		if len(msg) < pos+16 {
+			return m, ErrShortMessage
+		}
+		secondary, err := hex.DecodeString(string(msg[pos : pos+16]))
+		if err != nil {
+			return m, fmt.Errorf("parser: secondary bitmap: %w", err)
+		}
+		m.Fields[1] = string(msg[pos : pos+16])
+		pos += 16
+		bitmap = append(bitmap, secondary...)
+	}
+
+	for n := 2; n <= len(bitmap)*8; n++ {
+		if bitmap[(n-1)/8]&(0x80>>uint((n-1)%8)) == 0 {
+			continue
+		}
+		spec := Spec[n]
+		size := spec.Length
+		switch spec.Type {
+		case LLVar, LLLVar:
+			digits := 2
+			if spec.Type == LLLVar {
+				digits = 3
+			}
+			if len(msg) < pos+digits {
+				return m, fmt.Errorf("parser: field %d: %w", n, ErrShortMessage)
+			}
+			size, err = strconv.Atoi(string(msg[pos : pos+digits]))
+			if err != nil || size < 0 || size > spec.Length {
+				return m, fmt.Errorf("parser: field %d: bad length %q", n, msg[pos:pos+digits])
+			}
+			pos += digits
+		}
+		if len(msg) < pos+size {
+			return m, fmt.Errorf("parser: field %d: %w", n, ErrShortMessage)
+		}
+		m.Fields[n] = string(msg[pos : pos+size])
+		pos += size
+	}
+	return m, nil
+}
+
+// --------- MTI helpers ---------
+
+// IsRequest reports whether mti is a request or advice (function digit 0 or 2).
+func IsRequest(mti string) bool {
+	if len(mti) != 4 {
+		return false
+	}
+	return mti[2] == '0' || mti[2] == '2'
+}
+
+// IsResponse reports whether mti is a response to a request or advice.
+func IsResponse(mti string) bool {
+	if len(mti) != 4 {
+		return false
+	}
+	return mti[2] == '1' || mti[2] == '3'
+}
+
+// ResponseMTI returns the MTI expected in reply to the request mti.
+func ResponseMTI(mti string) string {
+	if !IsRequest(mti) {
+		return mti
+	}
+	b := []byte(mti)
+	b[2]++
+	return string(b)
+}
+
+// RequestMTI returns the request MTI answered by the response mti.
+func RequestMTI(mti string) string {
+	if !IsResponse(mti) {
+		return mti
+	}
+	b := []byte(mti)
+	b[2]--
+	return string(b)
+}
+
+// MatchKey links a request with its response: both share the request MTI,
+// STAN (DE11), terminal (DE41) and RRN (DE37).
+func (m *Message) MatchKey() string {
+	if m == nil {
+		return ""
+	}
+	mti := m.MTI
+	if IsResponse(mti) {
+		mti = RequestMTI(mti)
+	}
+	return strings.Join([]string{mti, m.Field(11), m.Field(41), m.Field(37)}, "_")
+}
+
+// Pack encodes m the way Parse reads it: MTI, hex bitmap(s), then the
+// fields in order. Field 1 is derived from the fields present.
+func Pack(m *Message) ([]byte, error) {
+	if len(m.MTI) != 4 {
+		return nil, fmt.Errorf("parser: bad MTI %q", m.MTI)
+	}
+	bitmap := make([]byte, 8)
+	var body []byte
+	for n := 2; n < len(Spec); n++ {
+		v, ok := m.Fields[n]
+		if !ok {
+			continue
+		}
+		if n > 64 && len(bitmap) == 8 {
+			bitmap = append(bitmap, make([]byte, 8)...)
+			bitmap[0] |= 0x80
+		}
+		bitmap[(n-1)/8] |= 0x80 >> uint((n-1)%8)
+		spec := Spec[n]
+		switch spec.Type {
+		case Fixed:
+			if len(v) != spec.Length {
+				return nil, fmt.Errorf("parser: field %d: want %d characters, got %d", n, spec.Length, len(v))
+			}
+		case LLVar, LLLVar:
+			if len(v) > spec.Length {
+				return nil, fmt.Errorf("parser: field %d: %d characters over the %d maximum", n, len(v), spec.Length)
+			}
+			format := "%02d"
+			if spec.Type == LLLVar {
+				format = "%03d"
+			}
+			body = append(body, fmt.Sprintf(format, len(v))...)
+		}
+		body = append(body, v...)
+	}
+	out := append([]byte(m.MTI), strings.ToUpper(hex.EncodeToString(bitmap[:8]))...)
+	if len(bitmap) > 8 {
+		out = append(out, strings.ToUpper(hex.EncodeToString(bitmap[8:]))...)
+	}
+	return append(out, body...), nil
+}
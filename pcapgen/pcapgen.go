@@ -0,0 +1,377 @@
+// Package pcapgen writes synthetic captures: Ethernet/IPv4/TCP connections
+// with handshakes, framed ISO 8583 requests and their responses, and
+// optional faults. With a fixed seed the output is byte for byte the same,
+// so the analyzer's framing and matching can be checked against it.
+package pcapgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/framing"
+	"github.com/msn60/isotcpdump/parser"
+)
+
+type Endpoint struct {
+	IP   net.IP
+	Port int
+}
+
+// Conversation is a client sending Messages requests to a server; a reset
+// reconnects from the next client port.
+type Conversation struct {
+	Client, Server Endpoint
+	Messages       int
+}
+
+// Faults are probabilities. Split, Merge, Reset and Garbage apply per
+// message, Loss, Reorder and Retransmit per data segment. Loss is capture
+// loss: the segment is missing from the file but the peer saw it.
+type Faults struct {
+	Loss       float64 // segment left out, leaving a sequence gap
+	Reorder    float64 // segment captured after the next one in its direction
+	Retransmit float64 // segment captured again 200ms later
+	Split      float64 // message sent in two segments
+	Merge      float64 // request sent in one segment with the next request
+	Reset      float64 // client resets the connection after the response
+	Garbage    float64 // 1-8 non-digit bytes before the frame
+}
+
+// Options for Generate.
+type Options struct {
+	Conversations []Conversation
+	Seed          int64 // 0: seeded from the clock
+	Start         time.Time
+	Interval      time.Duration // between requests of a conversation
+	Latency       time.Duration // mean response time
+	Header        framing.Header
+	DeclineRate   float64  // share of responses with a DE39 other than 00
+	MTIs          []string // request MTIs, used in turn; default 0200
+	MSS           int      // largest TCP payload; default 1460
+	Faults        Faults
+}
+
+// OptionsFromConfig has every enabled server other than network.fw_ip talk
+// to the fw server, on its ports in turn.
+func OptionsFromConfig(cfg *config.Config) (Options, error) {
+	gc := cfg.Generate
+	o := Options{
+		Seed:        gc.Seed,
+		Interval:    parseDuration(gc.Interval),
+		Latency:     parseDuration(gc.Latency),
+		DeclineRate: gc.DeclineRate,
+		Faults: Faults{
+			Loss:       gc.Faults.Loss,
+			Reorder:    gc.Faults.Reorder,
+			Retransmit: gc.Faults.Retransmit,
+			Split:      gc.Faults.Split,
+			Merge:      gc.Faults.Merge,
+			Reset:      gc.Faults.Reset,
+			Garbage:    gc.Faults.Garbage,
+		},
+	}
+	for _, m := range gc.MTIs {
+		o.MTIs = append(o.MTIs, strings.TrimSpace(m))
+	}
+	if v := strings.TrimSpace(gc.Start); v != "" {
+		o.Start, _ = time.Parse(time.RFC3339, v)
+	}
+
+	fwIP := net.ParseIP(strings.TrimSpace(cfg.Network.FWIP)).To4()
+	if fwIP == nil {
+		return o, fmt.Errorf("pcapgen: network.fw_ip %q is not an IPv4 address", cfg.Network.FWIP)
+	}
+	var fwPorts []int
+	for _, s := range cfg.Server {
+		if fwIP.Equal(net.ParseIP(strings.TrimSpace(s.IP))) {
+			fwPorts = append(fwPorts, s.Ports...)
+		}
+	}
+	if len(fwPorts) == 0 {
+		return o, fmt.Errorf("pcapgen: no server with ports at network.fw_ip %s", fwIP)
+	}
+	n := gc.Messages
+	if n == 0 {
+		n = 100
+	}
+	for _, s := range cfg.Server {
+		ip := net.ParseIP(strings.TrimSpace(s.IP)).To4()
+		if !s.IsEnable || ip == nil || ip.Equal(fwIP) {
+			continue
+		}
+		i := len(o.Conversations)
+		o.Conversations = append(o.Conversations, Conversation{
+			Client:   Endpoint{IP: ip, Port: 40000 + 1000*i},
+			Server:   Endpoint{IP: fwIP, Port: fwPorts[i%len(fwPorts)]},
+			Messages: n,
+		})
+	}
+	return o, nil
+}
+
+func (o Options) withDefaults() Options {
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	if o.Start.IsZero() {
+		o.Start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if o.Interval <= 0 {
+		o.Interval = 100 * time.Millisecond
+	}
+	if o.Latency <= 0 {
+		o.Latency = 50 * time.Millisecond
+	}
+	if o.Header == "" {
+		o.Header = framing.Default
+	}
+	if len(o.MTIs) == 0 {
+		o.MTIs = []string{"0200"}
+	}
+	if o.MSS <= 0 {
+		o.MSS = 1460
+	}
+	return o
+}
+
+// Stats counts what went into the file, faults included.
+type Stats struct {
+	Seed          int64 `json:"seed"`
+	Connections   int   `json:"connections"`
+	Packets       int   `json:"packets"`
+	Requests      int   `json:"requests"`
+	Responses     int   `json:"responses"`
+	Declined      int   `json:"declined"`
+	Lost          int   `json:"lost"`
+	Reordered     int   `json:"reordered"`
+	Retransmitted int   `json:"retransmitted"`
+	Split         int   `json:"split"`
+	Merged        int   `json:"merged"`
+	Resets        int   `json:"resets"`
+	Garbage       int   `json:"garbage"`
+}
+
+// Generate writes a pcap of opts to w.
+func Generate(w io.Writer, opts Options) (*Stats, error) {
+	opts = opts.withDefaults()
+	if len(opts.Conversations) == 0 {
+		return nil, errors.New("pcapgen: no conversations")
+	}
+	g := &gen{
+		opts:  opts,
+		rnd:   rand.New(rand.NewSource(opts.Seed)),
+		stats: &Stats{Seed: opts.Seed},
+	}
+	for i, c := range opts.Conversations {
+		if c.Client.IP.To4() == nil || c.Server.IP.To4() == nil {
+			return nil, fmt.Errorf("pcapgen: conversation %d: IPv4 endpoints only", i)
+		}
+		// stagger conversations so their packets interleave
+		if err := g.conversation(c, opts.Start.Add(time.Duration(i)*opts.Interval/time.Duration(len(opts.Conversations)+1))); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(g.pkts, func(i, j int) bool { return g.pkts[i].at.Before(g.pkts[j].at) })
+
+	pw := pcapgo.NewWriter(w)
+	if err := pw.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		return nil, fmt.Errorf("pcapgen: %w", err)
+	}
+	for _, p := range g.pkts {
+		ci := gopacket.CaptureInfo{Timestamp: p.at, CaptureLength: len(p.data), Length: len(p.data)}
+		if err := pw.WritePacket(ci, p.data); err != nil {
+			return nil, fmt.Errorf("pcapgen: %w", err)
+		}
+	}
+	g.stats.Packets = len(g.pkts)
+	return g.stats, nil
+}
+
+type packet struct {
+	at   time.Time
+	data []byte
+}
+
+type gen struct {
+	opts  Options
+	rnd   *rand.Rand
+	stats *Stats
+	pkts  []packet
+	ipID  uint16
+	stan  int
+}
+
+func (g *gen) chance(p float64) bool { return p > 0 && g.rnd.Float64() < p }
+
+// send is one write of a peer: whole frames, possibly with garbage.
+type send struct {
+	at         time.Time
+	fromClient bool
+	payload    []byte
+	split      bool
+}
+
+func (g *gen) conversation(c Conversation, start time.Time) error {
+	terminal := fmt.Sprintf("TRM%05d", c.Client.Port%100000)
+	port := c.Client.Port
+	var (
+		sends   []send
+		pending []byte            // merged requests not sent yet
+		waiting []*parser.Message // their responses
+		connAt  = start
+	)
+	flush := func(reset bool, end time.Time) error {
+		client := Endpoint{IP: c.Client.IP, Port: port}
+		if err := g.connection(client, c.Server, connAt, sends, reset, end); err != nil {
+			return err
+		}
+		sends = nil
+		port++
+		return nil
+	}
+	for i := 0; i < c.Messages; i++ {
+		at := start.Add(5*time.Millisecond + time.Duration(i)*g.opts.Interval +
+			time.Duration(g.rnd.Int63n(int64(g.opts.Interval)/4+1)))
+		req, resp := g.exchange(i, at, terminal)
+		reqFrame, err := g.frame(req)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, reqFrame...)
+		waiting = append(waiting, resp)
+		g.stats.Requests++
+		last := i == c.Messages-1
+		if !last && g.chance(g.opts.Faults.Merge) {
+			g.stats.Merged++
+			continue // goes out with the next request
+		}
+		sends = append(sends, send{at: at, fromClient: true, payload: pending, split: g.chance(g.opts.Faults.Split)})
+		pending = nil
+
+		for _, resp := range waiting {
+			respFrame, err := g.frame(resp)
+			if err != nil {
+				return err
+			}
+			sends = append(sends, send{at: at.Add(g.latency()), fromClient: false, payload: respFrame, split: g.chance(g.opts.Faults.Split)})
+			g.stats.Responses++
+		}
+		waiting = nil
+
+		if !last && g.chance(g.opts.Faults.Reset) {
+			g.stats.Resets++
+			end := latest(sends)
+			if err := flush(true, end.Add(time.Millisecond)); err != nil {
+				return err
+			}
+			connAt = end.Add(2 * time.Millisecond)
+		}
+	}
+	if len(sends) == 0 {
+		return nil
+	}
+	return flush(false, latest(sends).Add(time.Millisecond))
+}
+
+func latest(sends []send) time.Time {
+	var t time.Time
+	for _, s := range sends {
+		if s.at.After(t) {
+			t = s.at
+		}
+	}
+	return t
+}
+
+// exchange builds the i-th request of a conversation and its response.
+func (g *gen) exchange(i int, at time.Time, terminal string) (req, resp *parser.Message) {
+	g.stan = g.stan%999999 + 1
+	mti := g.opts.MTIs[i%len(g.opts.MTIs)]
+	req = &parser.Message{MTI: mti, Fields: map[int]string{
+		2:   "603799" + g.digits(10),
+		3:   "000000",
+		4:   g.digits(12),
+		7:   at.UTC().Format("0102150405"),
+		11:  fmt.Sprintf("%06d", g.stan),
+		12:  at.UTC().Format("150405"),
+		13:  at.UTC().Format("0102"),
+		37:  at.UTC().Format("060102") + fmt.Sprintf("%06d", g.stan),
+		41:  terminal,
+		49:  "364",
+		128: g.hex(16), // also puts in the secondary bitmap the analyzer keys on
+	}}
+	resp = &parser.Message{MTI: parser.ResponseMTI(mti), Fields: make(map[int]string, len(req.Fields)+1)}
+	for f, v := range req.Fields {
+		resp.Fields[f] = v
+	}
+	resp.Fields[39] = "00"
+	if g.chance(g.opts.DeclineRate) {
+		resp.Fields[39] = []string{"51", "55", "61", "91"}[g.rnd.Intn(4)]
+		g.stats.Declined++
+	}
+	return req, resp
+}
+
+// frame packs m with its header and maybe garbage in front.
+func (g *gen) frame(m *parser.Message) ([]byte, error) {
+	body, err := parser.Pack(m)
+	if err != nil {
+		return nil, fmt.Errorf("pcapgen: %w", err)
+	}
+	out, err := g.opts.Header.Frame(body)
+	if err != nil {
+		return nil, err
+	}
+	if g.chance(g.opts.Faults.Garbage) {
+		const junk = "abcdefghijklmnopqrstuvwxyz#$%&*"
+		pre := make([]byte, 1+g.rnd.Intn(8))
+		for i := range pre {
+			pre[i] = junk[g.rnd.Intn(len(junk))]
+		}
+		out = append(pre, out...)
+		g.stats.Garbage++
+	}
+	return out, nil
+}
+
+// latency draws a response time between half and one and a half times the
+// mean.
+func (g *gen) latency() time.Duration {
+	l := g.opts.Latency
+	return l/2 + time.Duration(g.rnd.Int63n(int64(l)+1))
+}
+
+func (g *gen) digits(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('0' + g.rnd.Intn(10))
+	}
+	return string(b)
+}
+
+func (g *gen) hex(n int) string {
+	const digits = "0123456789ABCDEF"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = digits[g.rnd.Intn(16)]
+	}
+	return string(b)
+}
+
+func parseDuration(s string) time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,45 @@
+package pcapgen
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func testOptions(seed int64) Options {
+	return Options{
+		Seed: seed,
+		Conversations: []Conversation{
+			{Client: Endpoint{IP: net.ParseIP("10.0.0.1"), Port: 40000}, Server: Endpoint{IP: net.ParseIP("10.0.0.9"), Port: 2020}, Messages: 30},
+			{Client: Endpoint{IP: net.ParseIP("10.0.0.2"), Port: 41000}, Server: Endpoint{IP: net.ParseIP("10.0.0.9"), Port: 2021}, Messages: 30},
+		},
+		DeclineRate: 0.1,
+		Faults:      Faults{Loss: 0.01, Reorder: 0.05, Retransmit: 0.05, Split: 0.2, Merge: 0.1, Reset: 0.05, Garbage: 0.05},
+	}
+}
+
+func TestSameSeedSameBytes(t *testing.T) {
+	var a, b bytes.Buffer
+	sa, err := Generate(&a, testOptions(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := Generate(&b, testOptions(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("same seed, different captures")
+	}
+	if *sa != *sb {
+		t.Errorf("same seed, different stats: %+v and %+v", *sa, *sb)
+	}
+
+	var c bytes.Buffer
+	if _, err := Generate(&c, testOptions(43)); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a.Bytes(), c.Bytes()) {
+		t.Error("different seeds, same capture")
+	}
+}
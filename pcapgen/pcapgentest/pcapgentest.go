@@ -0,0 +1,60 @@
+// Package pcapgentest runs generated captures through the TCP assembler,
+// for tests of the packages downstream of it.
+package pcapgentest
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/msn60/isotcpdump/pcapgen"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+const (
+	ServerIP = "10.0.0.9" // the firewall side of the conversation
+	Messages = 50         // requests in the conversation, one response each
+)
+
+// Options is one conversation of Messages requests, client 10.0.0.1 to
+// ServerIP:2020, with a fixed seed.
+func Options(faults pcapgen.Faults) pcapgen.Options {
+	return pcapgen.Options{
+		Seed:   3,
+		Faults: faults,
+		Conversations: []pcapgen.Conversation{{
+			Client:   pcapgen.Endpoint{IP: net.ParseIP("10.0.0.1"), Port: 40000},
+			Server:   pcapgen.Endpoint{IP: net.ParseIP(ServerIP), Port: 2020},
+			Messages: Messages,
+		}},
+	}
+}
+
+// Capture generates Options(faults) and feeds every packet through a TCP
+// assembler into agg, counting them as a capture does, then flushes.
+func Capture(t testing.TB, faults pcapgen.Faults, agg *stream.Aggregator) *pcapgen.Stats {
+	t.Helper()
+	var b bytes.Buffer
+	gs, err := pcapgen.Generate(&b, Options(faults))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcapgo.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asm := tcpassembly.NewAssembler(tcpassembly.NewStreamPool(stream.NewFactory(ServerIP, agg)))
+	for pkt := range gopacket.NewPacketSource(r, layers.LinkTypeEthernet).Packets() {
+		tcp, _ := pkt.TransportLayer().(*layers.TCP)
+		agg.CountPacket(tcp != nil && len(tcp.Payload) > 0)
+		if tcp != nil {
+			asm.AssembleWithTimestamp(pkt.NetworkLayer().NetworkFlow(), tcp, pkt.Metadata().Timestamp)
+		}
+	}
+	asm.FlushAll()
+	return gs
+}
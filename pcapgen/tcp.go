@@ -0,0 +1,193 @@
+package pcapgen
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type tcpFlags uint8
+
+const (
+	flagFIN tcpFlags = 1 << iota
+	flagSYN
+	flagRST
+	flagPSH
+	flagACK
+)
+
+// connection writes one TCP connection: handshake, sends in time order,
+// then FIN or, with reset, RST from the client at end.
+func (g *gen) connection(client, server Endpoint, start time.Time, sends []send, reset bool, end time.Time) error {
+	g.stats.Connections++
+	sort.SliceStable(sends, func(i, j int) bool { return sends[i].at.Before(sends[j].at) })
+
+	seq := map[bool]uint32{true: g.rnd.Uint32(), false: g.rnd.Uint32()} // by fromClient
+	ends := func(fromClient bool) (Endpoint, Endpoint) {
+		if fromClient {
+			return client, server
+		}
+		return server, client
+	}
+	emit := func(at time.Time, fromClient bool, flags tcpFlags, payload []byte) (int, error) {
+		src, dst := ends(fromClient)
+		data, err := g.serialize(src, dst, seq[fromClient], seq[!fromClient], flags, payload)
+		if err != nil {
+			return 0, err
+		}
+		g.pkts = append(g.pkts, packet{at: at, data: data})
+		return len(g.pkts) - 1, nil
+	}
+
+	const rtt = 200 * time.Microsecond
+	if _, err := emit(start, true, flagSYN, nil); err != nil {
+		return err
+	}
+	seq[true]++
+	if _, err := emit(start.Add(rtt), false, flagSYN|flagACK, nil); err != nil {
+		return err
+	}
+	seq[false]++
+	if _, err := emit(start.Add(2*rtt), true, flagACK, nil); err != nil {
+		return err
+	}
+
+	// data segments per direction, and whether each is captured late
+	type seg struct {
+		pkt  int
+		late bool
+	}
+	segs := map[bool][]seg{}
+	var resent []int
+	last := start.Add(2 * rtt)
+	for _, s := range sends {
+		at := s.at
+		if !at.After(last) {
+			at = last.Add(50 * time.Microsecond)
+		}
+		parts := [][]byte{s.payload}
+		if s.split && len(s.payload) > 1 {
+			cut := 1 + g.rnd.Intn(len(s.payload)-1)
+			parts = [][]byte{s.payload[:cut], s.payload[cut:]}
+			g.stats.Split++
+		}
+		for pi, part := range parts {
+			if pi > 0 {
+				at = at.Add(time.Millisecond)
+			}
+			for len(part) > 0 {
+				n := min(len(part), g.opts.MSS)
+				chunk := part[:n]
+				part = part[n:]
+				if g.chance(g.opts.Faults.Loss) {
+					g.stats.Lost++
+				} else {
+					i, err := emit(at, s.fromClient, flagPSH|flagACK, chunk)
+					if err != nil {
+						return err
+					}
+					segs[s.fromClient] = append(segs[s.fromClient], seg{pkt: i, late: g.chance(g.opts.Faults.Reorder)})
+					if g.chance(g.opts.Faults.Retransmit) {
+						g.pkts = append(g.pkts, packet{at: at.Add(200 * time.Millisecond), data: g.pkts[i].data})
+						resent = append(resent, len(g.pkts)-1)
+						g.stats.Retransmitted++
+					}
+				}
+				seq[s.fromClient] += uint32(n)
+				last = at
+				at = at.Add(50 * time.Microsecond)
+			}
+		}
+	}
+
+	// a late segment trades capture times with the next one the same way
+	for _, list := range segs {
+		for k := 0; k+1 < len(list); k++ {
+			if !list[k].late {
+				continue
+			}
+			a, b := &g.pkts[list[k].pkt], &g.pkts[list[k+1].pkt]
+			a.at, b.at = b.at, a.at
+			g.stats.Reordered++
+			k++
+		}
+	}
+
+	if !end.After(last) {
+		end = last.Add(time.Millisecond)
+	}
+	for _, i := range resent { // still before the connection closes
+		if !g.pkts[i].at.Before(end) {
+			g.pkts[i].at = end.Add(-time.Microsecond)
+		}
+	}
+	if reset {
+		_, err := emit(end, true, flagRST|flagACK, nil)
+		return err
+	}
+	if _, err := emit(end, true, flagFIN|flagACK, nil); err != nil {
+		return err
+	}
+	seq[true]++
+	if _, err := emit(end.Add(rtt), false, flagFIN|flagACK, nil); err != nil {
+		return err
+	}
+	seq[false]++
+	_, err := emit(end.Add(2*rtt), true, flagACK, nil)
+	return err
+}
+
+func (g *gen) serialize(src, dst Endpoint, seq, ack uint32, flags tcpFlags, payload []byte) ([]byte, error) {
+	g.ipID++
+	eth := &layers.Ethernet{SrcMAC: mac(src.IP), DstMAC: mac(dst.IP), EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Id:       g.ipID,
+		Flags:    layers.IPv4DontFragment,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    src.IP.To4(),
+		DstIP:    dst.IP.To4(),
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(src.Port),
+		DstPort: layers.TCPPort(dst.Port),
+		Seq:     seq,
+		Window:  65535,
+		FIN:     flags&flagFIN != 0,
+		SYN:     flags&flagSYN != 0,
+		RST:     flags&flagRST != 0,
+		PSH:     flags&flagPSH != 0,
+		ACK:     flags&flagACK != 0,
+	}
+	if tcp.ACK {
+		tcp.Ack = ack
+	}
+	if tcp.SYN {
+		mss := g.opts.MSS
+		tcp.Options = []layers.TCPOption{{
+			OptionType:   layers.TCPOptionKindMSS,
+			OptionLength: 4,
+			OptionData:   []byte{byte(mss >> 8), byte(mss)},
+		}}
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		return nil, err
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+		return nil, fmt.Errorf("pcapgen: %w", err)
+	}
+	return append([]byte(nil), buf.Bytes()...), nil
+}
+
+// mac is a locally administered address derived from ip.
+func mac(ip net.IP) net.HardwareAddr {
+	v4 := ip.To4()
+	return net.HardwareAddr{0x02, 0x00, v4[0], v4[1], v4[2], v4[3]}
+}
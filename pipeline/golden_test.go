@@ -0,0 +1,33 @@
+package pipeline_test
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/msn60/isotcpdump/golden"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from this run")
+
+// TestGolden runs every case under testdata/golden; see package golden.
+func TestGolden(t *testing.T) {
+	cases, err := golden.Cases(filepath.Join("..", "testdata", "golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range cases {
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			r := golden.Check(dir, *update)
+			if r.Err != nil {
+				t.Fatal(r.Err)
+			}
+			if len(r.Updated) > 0 {
+				t.Logf("updated %v", r.Updated)
+			}
+			for _, m := range r.Mismatches {
+				t.Error(m)
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+// Package pipeline is the analysis behind the default command: packets
+// through TCP reassembly into the aggregator and matcher, with the
+// exporters, audit records and reports the config enables.
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/crossnet"
+	"github.com/msn60/isotcpdump/dashboard"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/metrics"
+	"github.com/msn60/isotcpdump/output"
+	"github.com/msn60/isotcpdump/parser"
+	zrlogger "github.com/msn60/isotcpdump/pkg/zr_logger"
+	"github.com/msn60/isotcpdump/report"
+	"github.com/msn60/isotcpdump/snmp"
+	"github.com/msn60/isotcpdump/stream"
+	"github.com/rs/zerolog"
+)
+
+// restartKeys are config sections a reload cannot change for a running
+// capture.
+var restartKeys = []string{"app.interface", "app.pcap_path", "limits.", "duplicates.", "metrics.", "dashboard.", "snmp.enable", "snmp.listen", "snmp.community", "snmp.root_oid", "log.file.", "log.console.", "log.sampler.", "log.sinks", "log.admin."}
+
+// sameSinks reports whether a and b differ in sink levels at most.
+func sameSinks(a, b []config.LogSink) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		x.Level, y.Level = "", ""
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter is the part of a capture handle a reload changes.
+type Filter interface {
+	SetBPFFilter(expr string) error
+}
+
+// Options are the inputs and outputs of Run.
+type Options struct {
+	Source     *gopacket.PacketSource
+	SourceName string       // interface or file, for the summary
+	Handle     Filter       // re-filtered on reload; nil: not filterable
+	Live       *config.Live // set for live captures; enables hot reload
+	MaxCSVRows int
+	Stdout     io.Writer        // summary, and reports without a path; default os.Stdout
+	Audit      *zerolog.Logger  // one record per message; nil: none
+	Now        func() time.Time // clock for the summary's duration; default time.Now
+}
+
+// Result is what a run leaves behind once the source is drained.
+type Result struct {
+	Snapshot *stream.IsoStreamResponse
+	Matcher  matcher.Result
+	Decline  *report.DeclineReport // nil unless report.enable
+	Summary  *RunSummary
+}
+
+// Run reads opts.Source to the end, or for live captures until SIGINT or
+// SIGTERM, then writes and logs the summary and writes the configured
+// reports.
+func Run(app *config.Application, opts Options) *Result {
+	lc := opts.Live
+	live := lc != nil
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	started := now()
+
+	// 1) packet source
+	packetSource := opts.Source
+
+	// 2) create aggregator & assembler
+	agg := stream.NewAggregator(opts.MaxCSVRows).WithMaxRecords(app.Cfg.Limits.MaxRecords)
+	if dc := app.Cfg.Duplicates; dc.Enable {
+		window, err := time.ParseDuration(strings.TrimSpace(dc.Window))
+		if err != nil {
+			window = 30 * time.Second
+		}
+		agg.WithDuplicateWindow(window)
+	}
+	m := matcher.New(matcher.OptionsFromConfig(app.Cfg))
+	agg.OnRecord(m.Observe)
+	mtis := make(mtiCounter)
+	agg.OnRecord(mtis.observe)
+
+	var exporter *metrics.Exporter
+	if app.Cfg.Metrics.Enable {
+		exporter = metrics.New(app.Cfg, agg, m)
+		agg.OnRecord(exporter.ObserveRecord)
+		if err := exporter.Start(app.Cfg.Metrics.Listen, app.Cfg.Metrics.Path); err != nil {
+			app.Log.Error().Err(err).Str("listen", app.Cfg.Metrics.Listen).Msg("metrics listener failed")
+			exporter = nil
+		} else {
+			app.Log.Info().Str("addr", exporter.Addr()).Msg("metrics listening")
+			defer exporter.Shutdown(context.Background())
+		}
+	}
+	var dash *dashboard.Dashboard
+	if app.Cfg.Dashboard.Enable {
+		dash = dashboard.New(app.Cfg, agg)
+		agg.OnRecord(dash.ObserveRecord)
+		if err := dash.Start(app.Cfg.Dashboard.Listen); err != nil {
+			app.Log.Error().Err(err).Str("listen", app.Cfg.Dashboard.Listen).Msg("dashboard listener failed")
+			dash = nil
+		} else {
+			app.Log.Info().Str("url", "http://"+dash.Addr()+"/").Msg("dashboard listening")
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				_ = dash.Shutdown(ctx)
+			}()
+		}
+	}
+	var agent *snmp.Agent
+	if app.Cfg.SNMP.Enable {
+		var err error
+		if agent, err = snmp.New(app.Cfg, agg, m); err == nil {
+			err = agent.Start(app.Cfg.SNMP.Listen)
+		}
+		if err != nil {
+			app.Log.Error().Err(err).Str("listen", app.Cfg.SNMP.Listen).Msg("snmp agent failed")
+			agent = nil
+		} else {
+			agg.OnRecord(agent.ObserveRecord)
+			app.Log.Info().Str("addr", agent.Addr()).Msg("snmp agent listening")
+			defer agent.Shutdown(context.Background())
+		}
+	}
+	// masking rules of the audit records, swapped on reload
+	var masks *atomic.Pointer[[]parser.MaskRule]
+	if audit := opts.Audit; audit != nil && audit.GetLevel() != zerolog.Disabled {
+		masks = newMasks(parser.MaskRulesFromConfig(app.Cfg))
+		agg.OnRecord(func(rec stream.Record) { logAuditRecord(*audit, *masks.Load(), rec) })
+	}
+	lat := newLatencies(maxLatencySamples)
+	m.OnEvent(func(ev matcher.Event) {
+		logMatcherEvent(app, ev)
+		lat.observe(ev)
+		if exporter != nil {
+			exporter.ObserveEvent(ev)
+		}
+		if agent != nil {
+			agent.ObserveEvent(ev)
+		}
+		if dash != nil {
+			dash.ObserveEvent(ev)
+		}
+	})
+
+	factory := stream.NewFactory(app.Cfg.Network.FWIP, agg)
+	if labeler, err := crossnet.New(app.Cfg); err != nil {
+		app.Log.Error().Err(err).Msg("crossnetwork labels disabled")
+	} else {
+		factory.SetLabeler(labeler)
+	}
+
+	pool := tcpassembly.NewStreamPool(factory)
+	assembler := tcpassembly.NewAssembler(pool)
+
+	// 3) feed one packet
+	var first, last time.Time
+	handlePacket := func(pkt gopacket.Packet) {
+		if ts := pkt.Metadata().Timestamp; !ts.IsZero() {
+			if first.IsZero() {
+				first = ts
+			}
+			last = ts
+		}
+		tcp, _ := pkt.TransportLayer().(*layers.TCP)
+		agg.CountPacket(tcp != nil && len(tcp.Payload) > 0)
+		if pkt.NetworkLayer() == nil || tcp == nil {
+			return
+		}
+		assembler.AssembleWithTimestamp(pkt.NetworkLayer().NetworkFlow(), tcp, pkt.Metadata().Timestamp)
+	}
+
+	// 4)
+	if live {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		reloads := make(chan config.Reload, 1)
+		// a reload finishing after the loop is dropped instead of blocking
+		// the watcher
+		done := make(chan struct{})
+		defer close(done)
+		stopWatch, err := lc.Watch(func(r config.Reload) {
+			select {
+			case reloads <- r:
+			case <-done:
+			}
+		})
+		if err != nil {
+			app.Log.Warn().Err(err).Msg("config hot reload disabled")
+		} else {
+			defer stopWatch()
+		}
+		packets := packetSource.Packets()
+	loop:
+		for {
+			select {
+			case pkt, ok := <-packets:
+				if !ok {
+					break loop
+				}
+				handlePacket(pkt)
+			case now := <-ticker.C:
+				// idle streams are flushed and timeouts fire without new traffic
+				assembler.FlushOlderThan(now.Add(-stream.FlushAge))
+				m.Advance(now)
+			case r := <-reloads:
+				applyReload(app, r, opts.Handle, factory, m, masks, exporter, dash, agent)
+			case <-ctx.Done():
+				app.Log.Info().Msg("capture stopped")
+				break loop
+			}
+		}
+	} else {
+		for pkt := range packetSource.Packets() {
+			handlePacket(pkt)
+		}
+	}
+
+	// 5)
+	assembler.FlushAll()
+	m.Flush()
+
+	// 6)
+	resp := agg.Snapshot()
+
+	// 7)
+	// _ = writeCSV("input_packets.csv", resp.InputRows)
+	// _ = writeCSV("output_packets.csv", resp.OutputRows)
+
+	// 8) summary
+	ms := m.Snapshot()
+	res := &Result{Snapshot: resp, Matcher: ms}
+	res.Summary = buildSummary(opts.SourceName, started, now(), first, last, resp, ms.Stats, mtis, lat)
+	app.Log.Info().EmbedObject(res.Summary).Msg("run summary")
+	if err := writeSummary(app, res.Summary, stdout); err != nil {
+		app.Log.Error().Err(err).Msg("failed to write run summary")
+	}
+
+	// 9) decline report
+	if app.Cfg.Report.Enable {
+		res.Decline = report.BuildDecline(resp, report.OptionsFromConfig(app.Cfg))
+		if err := writeDeclineReport(app, resp, res.Decline, stdout); err != nil {
+			app.Log.Error().Err(err).Msg("failed to write decline report")
+		}
+	}
+
+	// 10) keep the dashboard up for offline runs
+	if dash != nil && !live && app.Cfg.Dashboard.Hold {
+		app.Log.Info().Str("url", "http://"+dash.Addr()+"/").Msg("replay done, dashboard still serving; Ctrl-C to exit")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		<-ctx.Done()
+		stop()
+	}
+	return res
+}
+
+// streamFactory is the part of the stream factory a reload changes.
+type streamFactory interface {
+	SetFWIP(string)
+	SetLabeler(*crossnet.Labeler)
+}
+
+// applyReload swaps a reloaded config into the running capture. It runs on
+// the capture goroutine, so streams, the matcher and the exporters never
+// see a half-applied config. Streams already open keep their firewall IP.
+func applyReload(app *config.Application, r config.Reload, handle Filter, factory streamFactory,
+	m *matcher.Matcher, masks *atomic.Pointer[[]parser.MaskRule], exporter *metrics.Exporter, dash *dashboard.Dashboard, agent *snmp.Agent) {
+	if r.Err != nil {
+		app.Log.Error().Err(r.Err).Msg("config reload rejected, keeping current config")
+		return
+	}
+	if len(r.Changes) == 0 {
+		return
+	}
+	cfg := r.Cfg
+
+	changes := make([]string, len(r.Changes))
+	var restart []string
+	for i, c := range r.Changes {
+		changes[i] = c.String()
+		if c.Key == "log.sinks" && sameSinks(app.Cfg.Log.Sinks, cfg.Log.Sinks) {
+			continue // levels only, applied below
+		}
+		for _, p := range restartKeys {
+			if c.Key == p || strings.HasPrefix(c.Key, p) {
+				restart = append(restart, c.Key)
+				break
+			}
+		}
+	}
+
+	if handle != nil && cfg.App.BPFFilter != app.Cfg.App.BPFFilter {
+		if err := handle.SetBPFFilter(strings.TrimSpace(cfg.App.BPFFilter)); err != nil {
+			app.Log.Error().Err(err).Str("bpf_filter", cfg.App.BPFFilter).Msg("reloaded bpf filter rejected, keeping the old one")
+		}
+	}
+	factory.SetFWIP(cfg.Network.FWIP)
+	if labeler, err := crossnet.New(cfg); err != nil {
+		app.Log.Error().Err(err).Msg("reloaded crossnetwork rejected, keeping the old labels")
+	} else {
+		factory.SetLabeler(labeler)
+	}
+	m.SetOptions(matcher.OptionsFromConfig(cfg))
+	if masks != nil {
+		rules := parser.MaskRulesFromConfig(cfg)
+		masks.Store(&rules)
+	}
+	if exporter != nil {
+		exporter.SetServers(cfg.Server)
+	}
+	if dash != nil {
+		dash.SetConfig(cfg)
+	}
+	if agent != nil {
+		if err := agent.SetConfig(cfg); err != nil {
+			app.Log.Error().Err(err).Msg("reloaded snmp paths rejected, keeping the old ones")
+		}
+	}
+	applyLevels(zrlogger.L(), r.Changes, cfg)
+	app.Cfg = cfg
+
+	app.Log.Info().Strs("changes", changes).Msg("config reloaded")
+	if len(restart) > 0 {
+		app.Log.Warn().Strs("keys", restart).Msg("changed keys take effect after a restart")
+	}
+}
+
+// applyLevels resets l to the levels of cfg only when the reload changed
+// log settings, so levels set at runtime survive unrelated edits.
+func applyLevels(l *zrlogger.Logger, changes []config.Change, cfg *config.Config) {
+	for _, c := range changes {
+		if strings.HasPrefix(c.Key, "log.") {
+			zrlogger.ApplyLevels(l, cfg)
+			return
+		}
+	}
+}
+
+func newMasks(rules []parser.MaskRule) *atomic.Pointer[[]parser.MaskRule] {
+	p := new(atomic.Pointer[[]parser.MaskRule])
+	p.Store(&rules)
+	return p
+}
+
+// logMatcherEvent writes everything except plain matches to the file log.
+func logMatcherEvent(app *config.Application, ev matcher.Event) {
+	if ev.Kind == matcher.KindMatched {
+		return
+	}
+	e := app.Log.Warn().Str("kind", string(ev.Kind))
+	if r := ev.Request; r != nil {
+		e = e.Str("mti", r.Msg.MTI).Str("stan", r.Msg.Field(11)).Str("rrn", r.Msg.Field(37)).Time("request_time", r.Time)
+	}
+	if r := ev.Response; r != nil {
+		e = e.Str("response_mti", r.Msg.MTI).Str("rc", r.Msg.Field(39)).Time("response_time", r.Time)
+	}
+	if ev.Latency > 0 {
+		e = e.Dur("latency", ev.Latency)
+	}
+	if o := ev.Original; o != nil {
+		e = e.Str("original_mti", o.Msg.MTI).Str("original_stan", o.Msg.Field(11))
+	}
+	e.Msg("matcher event")
+}
+
+// logAuditRecord writes one audit record per decoded message, with the
+// masking rules applied. The stream key is left out: it carries the clear
+// PAN, so records are linked by the message's match key instead.
+func logAuditRecord(l zerolog.Logger, rules []parser.MaskRule, rec stream.Record) {
+	e := l.Log().
+		Time("captured", rec.Time).
+		Str("direction", string(rec.Direction)).
+		Str("src", net.JoinHostPort(rec.SrcIP, strconv.Itoa(rec.SrcPort))).
+		Str("dst", net.JoinHostPort(rec.DstIP, strconv.Itoa(rec.DstPort)))
+	if rec.Path != "" {
+		e = e.Str("path", rec.Path)
+	}
+	if rec.Duplicate != "" {
+		e = e.Str("duplicate", string(rec.Duplicate))
+	}
+	if rec.ParseErr != nil {
+		e = e.Str("parse_error", rec.ParseErr.Error())
+	}
+	if rec.Msg != nil {
+		masked := rec.Msg.Masked(rules)
+		nums := make([]int, 0, len(masked))
+		for n := range masked {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		fields := zerolog.Dict()
+		for _, n := range nums {
+			fields = fields.Str(strconv.Itoa(n), masked[n])
+		}
+		e = e.Str("mti", rec.Msg.MTI).Str("key", rec.Msg.MatchKey()).Dict("fields", fields)
+	}
+	e.Msg("message")
+}
+
+// writeSummary writes the summary to summary.path, or stdout without one.
+func writeSummary(app *config.Application, s *RunSummary, stdout io.Writer) error {
+	sc := app.Cfg.Summary
+	path := strings.TrimSpace(sc.Path)
+	if path == "" {
+		return s.Write(stdout, sc.Format)
+	}
+	f, err := output.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.Write(f, sc.Format); err != nil {
+		return err
+	}
+	app.Log.Info().Str("path", path).Msg("run summary written")
+	return f.Close()
+}
+
+func writeDeclineReport(app *config.Application, resp *stream.IsoStreamResponse, rep *report.DeclineReport, stdout io.Writer) error {
+	if resp.DroppedRecords > 0 {
+		app.Log.Warn().Int("dropped", resp.DroppedRecords).Msg("report covers only the first limits.max_records messages")
+	}
+
+	path := strings.TrimSpace(app.Cfg.Report.Path)
+	if path == "" {
+		if err := rep.Write(stdout, app.Cfg.Report.Format); err != nil {
+			return err
+		}
+		return writeDeclineCSV(app, rep)
+	}
+	f, err := output.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := rep.Write(f, app.Cfg.Report.Format); err != nil {
+		return err
+	}
+	app.Log.Info().Str("path", path).Msg("decline report written")
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return writeDeclineCSV(app, rep)
+}
+
+// writeDeclineCSV writes the extra CSV copy configured by output.report_csv.
+func writeDeclineCSV(app *config.Application, rep *report.DeclineReport) error {
+	path := strings.TrimSpace(app.Cfg.Output.ReportCSV)
+	if !app.Cfg.Output.ReportStatus || path == "" {
+		return nil
+	}
+	f, err := output.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := rep.WriteCSV(f); err != nil {
+		return err
+	}
+	app.Log.Info().Str("path", path).Msg("decline report csv written")
+	return f.Close()
+}
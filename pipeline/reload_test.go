@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/parser"
+	zrlogger "github.com/msn60/isotcpdump/pkg/zr_logger"
+	"github.com/msn60/isotcpdump/stream"
+	"github.com/rs/zerolog"
+)
+
+// Audit records follow masking rules changed by a reload.
+func TestReloadSwapsAuditMasks(t *testing.T) {
+	old := &config.Config{Masking: config.Masking{Rules: []config.MaskRule{{Field: 2, KeepFirst: 6, KeepLast: 4}}}}
+	app := config.NewApp(old).WithLogger(zerolog.Nop())
+
+	var buf bytes.Buffer
+	audit := zerolog.New(&buf)
+	rules := parser.MaskRulesFromConfig(old)
+	masks := newMasks(rules)
+	rec := stream.Record{Time: time.Unix(0, 0), Msg: &parser.Message{MTI: "0200", Fields: map[int]string{2: "6037991234567890"}}}
+
+	logAuditRecord(audit, *masks.Load(), rec)
+	if !strings.Contains(buf.String(), "603799******7890") {
+		t.Fatalf("before reload: %s", buf.String())
+	}
+
+	reloaded := &config.Config{Masking: config.Masking{Rules: []config.MaskRule{{Field: 2, KeepLast: 4}}}}
+	applyReload(app, config.Reload{Cfg: reloaded, Changes: []config.Change{{Key: "masking.rules"}}},
+		nil, stream.NewFactory("", nil), matcher.New(matcher.Options{}), masks, nil, nil, nil)
+
+	buf.Reset()
+	logAuditRecord(audit, *masks.Load(), rec)
+	if !strings.Contains(buf.String(), "************7890") {
+		t.Errorf("after reload: %s", buf.String())
+	}
+}
+
+// The stream key holds the clear PAN and must not reach the audit log.
+func TestAuditRecordHidesPAN(t *testing.T) {
+	const pan = "6037997791850604"
+	rules := []parser.MaskRule{{Field: 2, KeepFirst: 6, KeepLast: 4}}
+	rec := stream.Record{
+		Time: time.Unix(0, 0),
+		Key:  "0200_" + pan + "_000000",
+		Msg: &parser.Message{MTI: "0200", Fields: map[int]string{
+			2: pan, 3: "000000", 11: "000123", 37: "000000000123", 41: "TERM0001",
+		}},
+	}
+
+	var buf bytes.Buffer
+	logAuditRecord(zerolog.New(&buf), rules, rec)
+	out := buf.String()
+	if strings.Contains(out, pan) {
+		t.Fatalf("clear PAN in audit record: %s", out)
+	}
+	if !strings.Contains(out, `"key":"0200_000123_TERM0001_000000000123"`) {
+		t.Errorf("missing match key: %s", out)
+	}
+}
+
+// Levels set at runtime survive a reload that leaves log settings alone.
+func TestReloadKeepsRuntimeLevels(t *testing.T) {
+	l, err := zrlogger.New(&zrlogger.Options{Sinks: []zrlogger.SinkOptions{{Name: "mem", Type: zrlogger.SinkRing}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cfg := &config.Config{Log: config.Log{Metadata: config.LogMetadata{Level: "info"}}}
+
+	l.SetLevel(zerolog.TraceLevel) // e.g. from the admin endpoint
+	applyLevels(l, []config.Change{{Key: "matcher.timeout"}}, cfg)
+	if got := l.Levels()["mem"]; got != "trace" {
+		t.Errorf("after unrelated reload: %s, want trace", got)
+	}
+
+	applyLevels(l, []config.Change{{Key: "log.metadata.level"}}, cfg)
+	if got := l.Levels()["mem"]; got != "info" {
+		t.Errorf("after log reload: %s, want info", got)
+	}
+}
@@ -0,0 +1,316 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/stream"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// RunSummary is the end-of-run account of a capture, for people (text)
+// and scripts (json, yaml). Durations are in seconds.
+type RunSummary struct {
+	Source           string              `json:"source"`
+	Started          time.Time           `json:"started"`
+	Duration         float64             `json:"duration_seconds"`     // wall clock
+	CaptureSpan      float64             `json:"capture_span_seconds"` // first to last packet
+	Packets          int64               `json:"packets"`
+	PayloadPackets   int64               `json:"payload_packets"`
+	Flows            int64               `json:"flows"`
+	BytesReassembled int64               `json:"bytes_reassembled"`
+	Messages         DirectionCounts     `json:"messages"` // duplicates excluded
+	ByMTI            []MTICount          `json:"by_mti"`
+	Duplicates       DuplicateCounts     `json:"duplicates"`
+	FramingErrors    int64               `json:"framing_errors"`
+	ResyncBytes      int64               `json:"resync_bytes"`
+	TruncatedRows    DirectionCounts     `json:"truncated_rows"` // messages past the CSV row limit
+	DroppedRecords   int                 `json:"dropped_records"`
+	Matcher          matcher.Stats       `json:"matcher"`
+	Latency          LatencyStats        `json:"latency"` // matched and late responses
+	Paths            []stream.PathCounts `json:"paths,omitempty"`
+	Throughput       Throughput          `json:"throughput"` // per wall-clock second
+}
+
+type DirectionCounts struct {
+	Input  int `json:"input"`
+	Output int `json:"output"`
+}
+
+type MTICount struct {
+	Direction stream.Direction `json:"direction"`
+	MTI       string           `json:"mti"`
+	Count     int              `json:"count"`
+}
+
+type DuplicateCounts struct {
+	Input           int `json:"input"`
+	Output          int `json:"output"`
+	Retransmissions int `json:"retransmissions"`
+	Resends         int `json:"resends"`
+}
+
+type Throughput struct {
+	Packets  float64 `json:"packets"`
+	Messages float64 `json:"messages"`
+	Bytes    float64 `json:"bytes"`
+}
+
+type LatencyStats struct {
+	Count   int     `json:"count"`
+	Sampled int     `json:"sampled,omitempty"` // set when percentiles come from a sample of Count
+	P50     float64 `json:"p50_seconds"`
+	P90     float64 `json:"p90_seconds"`
+	P99     float64 `json:"p99_seconds"`
+	Max     float64 `json:"max_seconds"`
+}
+
+// maxLatencySamples bounds the memory of a long live capture.
+const maxLatencySamples = 100_000
+
+// latencies collects request-to-response times from matcher events. Past
+// limit it keeps a uniform reservoir sample; count and max stay exact.
+type latencies struct {
+	limit  int
+	count  int
+	max    time.Duration
+	sample []time.Duration
+	rng    *rand.Rand
+}
+
+func newLatencies(limit int) *latencies {
+	return &latencies{limit: limit, rng: rand.New(rand.NewPCG(1, 2))}
+}
+
+func (l *latencies) observe(ev matcher.Event) {
+	if ev.Kind != matcher.KindMatched && ev.Kind != matcher.KindLate {
+		return
+	}
+	l.count++
+	l.max = max(l.max, ev.Latency)
+	if len(l.sample) < l.limit {
+		l.sample = append(l.sample, ev.Latency)
+	} else if i := l.rng.IntN(l.count); i < l.limit {
+		l.sample[i] = ev.Latency
+	}
+}
+
+// stats uses nearest-rank percentiles.
+func (l *latencies) stats() LatencyStats {
+	if len(l.sample) == 0 {
+		return LatencyStats{}
+	}
+	sorted := slices.Clone(l.sample)
+	slices.Sort(sorted)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return sorted[max(i, 0)].Seconds()
+	}
+	st := LatencyStats{
+		Count: l.count,
+		P50:   rank(0.50),
+		P90:   rank(0.90),
+		P99:   rank(0.99),
+		Max:   l.max.Seconds(),
+	}
+	if len(sorted) < l.count {
+		st.Sampled = len(sorted)
+	}
+	return st
+}
+
+// mtiCounter tallies first sightings by direction and MTI.
+type mtiCounter map[MTICount]int
+
+func (c mtiCounter) observe(rec stream.Record) {
+	if rec.Duplicate != "" {
+		return
+	}
+	mti := "----"
+	if rec.Msg != nil {
+		mti = rec.Msg.MTI
+	}
+	c[MTICount{Direction: rec.Direction, MTI: mti}]++
+}
+
+func (c mtiCounter) sorted() []MTICount {
+	out := make([]MTICount, 0, len(c))
+	for k, n := range c {
+		k.Count = n
+		out = append(out, k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Direction != out[j].Direction {
+			return out[i].Direction < out[j].Direction
+		}
+		return out[i].MTI < out[j].MTI
+	})
+	return out
+}
+
+func buildSummary(source string, started, finished, first, last time.Time, snap *stream.IsoStreamResponse,
+	ms matcher.Stats, mtis mtiCounter, lat *latencies) *RunSummary {
+	s := &RunSummary{
+		Source:           source,
+		Started:          started,
+		Duration:         finished.Sub(started).Seconds(),
+		Packets:          snap.Packets,
+		PayloadPackets:   snap.PayloadPackets,
+		Flows:            snap.Flows,
+		BytesReassembled: snap.BytesReassembled,
+		Messages:         DirectionCounts{Input: snap.TotalInputMessages, Output: snap.TotalOutputMessages},
+		ByMTI:            mtis.sorted(),
+		Duplicates: DuplicateCounts{
+			Input:           snap.DuplicateInputMessages,
+			Output:          snap.DuplicateOutputMessages,
+			Retransmissions: snap.Retransmissions,
+			Resends:         snap.Resends,
+		},
+		FramingErrors: snap.FramingErrors,
+		ResyncBytes:   snap.ResyncBytes,
+		TruncatedRows: DirectionCounts{
+			Input:  snap.TotalInputMessages + snap.DuplicateInputMessages - len(snap.InputRows),
+			Output: snap.TotalOutputMessages + snap.DuplicateOutputMessages - len(snap.OutputRows),
+		},
+		DroppedRecords: snap.DroppedRecords,
+		Matcher:        ms,
+		Latency:        lat.stats(),
+		Paths:          snap.ByPath,
+	}
+	if !first.IsZero() {
+		s.CaptureSpan = last.Sub(first).Seconds()
+	}
+	if s.Duration > 0 {
+		s.Throughput = Throughput{
+			Packets:  float64(s.Packets) / s.Duration,
+			Messages: float64(s.Messages.Input+s.Messages.Output) / s.Duration,
+			Bytes:    float64(s.BytesReassembled) / s.Duration,
+		}
+	}
+	return s
+}
+
+// Write renders s as "text", "json" or "yaml".
+func (s *RunSummary) Write(w io.Writer, format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return s.WriteText(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case "yaml":
+		return s.WriteYAML(w)
+	default:
+		return fmt.Errorf("pipeline: unknown summary format %q", format)
+	}
+}
+
+// WriteYAML goes through the JSON encoding, so both formats share field
+// names and order.
+func (s *RunSummary) WriteYAML(w io.Writer) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	styleBlock(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// styleBlock undoes the flow style JSON input leaves on every node.
+func styleBlock(n *yaml.Node) {
+	n.Style &^= yaml.FlowStyle
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" {
+		n.Style &^= yaml.DoubleQuotedStyle
+	}
+	for _, c := range n.Content {
+		styleBlock(c)
+	}
+}
+
+func (s *RunSummary) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Source:\t%s\n", s.Source)
+	fmt.Fprintf(tw, "Duration:\t%s\tcapture span: %s\n", seconds(s.Duration), seconds(s.CaptureSpan))
+	fmt.Fprintf(tw, "Packets:\t%d\twith payload: %d\tflows: %d\tbytes reassembled: %d\n",
+		s.Packets, s.PayloadPackets, s.Flows, s.BytesReassembled)
+	fmt.Fprintf(tw, "Messages:\tinput: %d\toutput: %d\n", s.Messages.Input, s.Messages.Output)
+	fmt.Fprintf(tw, "Duplicates:\tinput: %d\toutput: %d\tretransmissions: %d\tresends: %d\n",
+		s.Duplicates.Input, s.Duplicates.Output, s.Duplicates.Retransmissions, s.Duplicates.Resends)
+	fmt.Fprintf(tw, "Framing errors:\t%d\tresync bytes: %d\n", s.FramingErrors, s.ResyncBytes)
+	fmt.Fprintf(tw, "Truncated rows:\tinput: %d\toutput: %d\tdropped records: %d\n",
+		s.TruncatedRows.Input, s.TruncatedRows.Output, s.DroppedRecords)
+	m := s.Matcher
+	fmt.Fprintf(tw, "Matcher:\tmatched: %d\ttimeouts: %d\tlate: %d\torphan requests: %d\torphan responses: %d\n",
+		m.Matched, m.Timeouts, m.Late, m.OrphanRequests, m.OrphanResponses)
+	l := s.Latency
+	fmt.Fprintf(tw, "Latency:\tp50: %s\tp90: %s\tp99: %s\tmax: %s\tresponses: %d\n",
+		seconds(l.P50), seconds(l.P90), seconds(l.P99), seconds(l.Max), l.Count)
+	if l.Sampled > 0 {
+		fmt.Fprintf(tw, "\tpercentiles from %d sampled responses\n", l.Sampled)
+	}
+	fmt.Fprintf(tw, "Reversals:\tlinked: %d\tunlinked: %d\n", m.ReversalsLinked, m.ReversalsUnlinked)
+	t := s.Throughput
+	fmt.Fprintf(tw, "Throughput:\t%.1f packets/s\t%.1f messages/s\t%.0f bytes/s\n", t.Packets, t.Messages, t.Bytes)
+
+	if len(s.ByMTI) > 0 {
+		fmt.Fprintln(tw, "\nDIRECTION\tMTI\tCOUNT")
+		for _, c := range s.ByMTI {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", c.Direction, c.MTI, c.Count)
+		}
+	}
+	if len(s.Paths) > 0 {
+		fmt.Fprintln(tw, "\nPATH\tOID\tINPUT\tOUTPUT\tDUPLICATES")
+		for _, p := range s.Paths {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", p.Path, p.OID, p.Input, p.Output, p.Duplicates)
+		}
+	}
+	return tw.Flush()
+}
+
+func seconds(f float64) string {
+	return time.Duration(f * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+// MarshalZerologObject logs the summary's totals; per-MTI and per-path
+// detail stays in the rendered summary.
+func (s *RunSummary) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("source", s.Source).
+		Float64("duration_seconds", s.Duration).
+		Float64("capture_span_seconds", s.CaptureSpan).
+		Int64("packets", s.Packets).
+		Int64("payload_packets", s.PayloadPackets).
+		Int64("flows", s.Flows).
+		Int("input_messages", s.Messages.Input).
+		Int("output_messages", s.Messages.Output).
+		Int("duplicate_input", s.Duplicates.Input).
+		Int("duplicate_output", s.Duplicates.Output).
+		Int64("framing_errors", s.FramingErrors).
+		Int64("resync_bytes", s.ResyncBytes).
+		Int("truncated_input_rows", s.TruncatedRows.Input).
+		Int("truncated_output_rows", s.TruncatedRows.Output).
+		Int("matched", s.Matcher.Matched).
+		Int("timeouts", s.Matcher.Timeouts).
+		Int("orphan_requests", s.Matcher.OrphanRequests).
+		Int("orphan_responses", s.Matcher.OrphanResponses).
+		Float64("p99_latency_seconds", s.Latency.P99)
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/stream"
+	"gopkg.in/yaml.v3"
+)
+
+func observeMs(l *latencies, kind matcher.Kind, ms ...int) {
+	for _, v := range ms {
+		l.observe(matcher.Event{Kind: kind, Latency: time.Duration(v) * time.Millisecond})
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	l := newLatencies(maxLatencySamples)
+	for i := 100; i >= 1; i-- {
+		observeMs(l, matcher.KindMatched, i)
+	}
+	observeMs(l, matcher.KindTimeout, 5000) // no response, no latency
+
+	want := LatencyStats{Count: 100, P50: .050, P90: .090, P99: .099, Max: .100}
+	if got := l.stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	l = newLatencies(maxLatencySamples)
+	observeMs(l, matcher.KindMatched, 30, 10)
+	observeMs(l, matcher.KindLate, 20)
+	want = LatencyStats{Count: 3, P50: .020, P90: .030, P99: .030, Max: .030}
+	if got := l.stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if got := newLatencies(maxLatencySamples).stats(); got != (LatencyStats{}) {
+		t.Errorf("empty: %+v", got)
+	}
+}
+
+func TestLatencySampleBounded(t *testing.T) {
+	l := newLatencies(10)
+	for i := 1; i <= 1000; i++ {
+		observeMs(l, matcher.KindMatched, i)
+	}
+	if len(l.sample) != 10 {
+		t.Fatalf("kept %d samples", len(l.sample))
+	}
+	st := l.stats()
+	if st.Count != 1000 || st.Sampled != 10 || st.Max != 1 {
+		t.Errorf("got %+v", st)
+	}
+}
+
+func testSummary() *RunSummary {
+	snap := &stream.IsoStreamResponse{TotalInputMessages: 4, TotalOutputMessages: 4}
+	snap.Packets, snap.PayloadPackets, snap.Flows = 10, 8, 2
+	snap.BytesReassembled, snap.FramingErrors = 1024, 1
+	mtis := mtiCounter{}
+	mtis.observe(stream.Record{Direction: "input"})
+	lat := newLatencies(maxLatencySamples)
+	observeMs(lat, matcher.KindMatched, 10, 20)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return buildSummary("test.pcap", start, start.Add(2*time.Second), start, start.Add(time.Second),
+		snap, matcher.Stats{Matched: 2, Timeouts: 1}, mtis, lat)
+}
+
+func TestSummaryRender(t *testing.T) {
+	s := testSummary()
+	if s.Throughput.Packets != 5 || s.CaptureSpan != 1 {
+		t.Errorf("throughput %+v, span %v", s.Throughput, s.CaptureSpan)
+	}
+
+	var text bytes.Buffer
+	if err := s.Write(&text, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Source:",
+		"test.pcap",
+		"p50: 10ms",
+		"p99: 20ms",
+		"responses: 2",
+		"matched: 2",
+		"timeouts: 1",
+		"Framing errors:  1",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := s.Write(&js, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var back RunSummary
+	if err := json.Unmarshal(js.Bytes(), &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Latency != s.Latency || back.Packets != 10 || len(back.ByMTI) != 1 {
+		t.Errorf("json round trip: %+v", back)
+	}
+	if strings.Contains(js.String(), `"sampled"`) {
+		t.Errorf("sampled set without sampling: %s", js.String())
+	}
+
+	var ys bytes.Buffer
+	if err := s.Write(&ys, "yaml"); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(ys.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["source"] != "test.pcap" || doc["packets"] != 10 {
+		t.Errorf("yaml: %s", ys.String())
+	}
+	if strings.Contains(ys.String(), "{") || strings.Contains(ys.String(), `"test.pcap"`) {
+		t.Errorf("yaml not in block style:\n%s", ys.String())
+	}
+
+	if err := s.Write(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
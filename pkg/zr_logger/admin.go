@@ -0,0 +1,76 @@
+package zrlogger
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Admin serves the sink levels of a Logger:
+//
+//	GET /log/level                          {"console":"info","file":"debug"}
+//	PUT /log/level?level=debug[&sink=file]  all sinks, or the named one
+type Admin struct {
+	l    *Logger
+	srv  *http.Server
+	addr string
+}
+
+func NewAdmin(l *Logger) *Admin { return &Admin{l: l} }
+
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/log/level", a.level)
+	return mux
+}
+
+func (a *Admin) level(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		lvl, ok := tryParseLevel(strings.TrimSpace(r.FormValue("level")))
+		if !ok {
+			http.Error(w, "unknown level "+r.FormValue("level"), http.StatusBadRequest)
+			return
+		}
+		if name := strings.TrimSpace(r.FormValue("sink")); name != "" {
+			if err := a.l.SetSinkLevel(name, lvl); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		} else {
+			a.l.SetLevel(lvl)
+		}
+		a.l.Log().Str("remote", r.RemoteAddr).Interface("levels", a.l.Levels()).Msg("log levels changed")
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(a.l.Levels())
+}
+
+// Start listens on addr and serves in the background.
+func (a *Admin) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	a.addr = ln.Addr().String()
+	a.srv = &http.Server{Handler: a.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() { _ = a.srv.Serve(ln) }()
+	return nil
+}
+
+func (a *Admin) Addr() string { return a.addr }
+
+func (a *Admin) Shutdown(ctx context.Context) error {
+	if a.srv == nil {
+		return nil
+	}
+	return a.srv.Shutdown(ctx)
+}
@@ -0,0 +1,302 @@
+package zrlogger
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Audit records are JSON lines chained by SHA-256:
+//
+//	{...event...,"seq":42,"prev":"<hash of 41>","hash":"<hash>"}
+//
+// where hash is sha256 of the line up to and excluding `,"hash":...`, with
+// a closing brace. Record 1 has prev GenesisHash. Files rotate by size and
+// are never deleted; <path>.head holds the seq and hash of the newest record,
+// so truncating the newest file is detected too.
+
+// GenesisHash is the prev of the first audit record.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+const headWidth = 20 + 1 + sha256.Size*2 + 1 // "%020d %s\n"
+
+// auditWriter chains each event it receives to the previous one.
+type auditWriter struct {
+	mu   sync.Mutex
+	out  io.WriteCloser
+	head *os.File
+	seq  uint64
+	prev string
+}
+
+func openAudit(o *Options, path string) (*auditWriter, error) {
+	if err := mkdirFor(path); err != nil {
+		return nil, err
+	}
+	head, err := os.OpenFile(path+".head", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &auditWriter{head: head, prev: GenesisHash}
+	if seq, hash, ok := readHead(head); ok {
+		w.seq, w.prev = seq, hash
+	}
+	// The record is written before .head, so a crash between the two leaves
+	// .head one behind the file: the newest record wins.
+	if seq, hash, ok := lastRecord(path); ok && seq > w.seq {
+		w.seq, w.prev = seq, hash
+		if err := w.writeHead(); err != nil {
+			head.Close()
+			return nil, err
+		}
+	}
+	size := o.FileMaxSizeMB
+	if size <= 0 {
+		size = 50
+	}
+	// backups and age stay 0: every rotated file is kept for verification
+	w.out = &lumberjack.Logger{Filename: path, MaxSize: size}
+	return w, nil
+}
+
+func (w *auditWriter) Write(p []byte) (int, error) {
+	body := bytes.TrimRight(p, "\n")
+	if len(body) < 2 || body[len(body)-1] != '}' {
+		return 0, errBadJSON
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	seq := w.seq + 1
+	line := make([]byte, 0, len(body)+160)
+	line = append(line, body[:len(body)-1]...)
+	if len(body) > 2 {
+		line = append(line, ',')
+	}
+	line = append(line, `"seq":`...)
+	line = strconv.AppendUint(line, seq, 10)
+	line = append(line, `,"prev":"`...)
+	line = append(line, w.prev...)
+	line = append(line, '"')
+	hash := chainHash(line)
+	line = append(line, `,"hash":"`...)
+	line = append(line, hash...)
+	line = append(line, "\"}\n"...)
+
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	w.seq, w.prev = seq, hash
+	if err := w.writeHead(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *auditWriter) writeHead() error {
+	_, err := w.head.WriteAt([]byte(fmt.Sprintf("%020d %s\n", w.seq, w.prev)), 0)
+	return err
+}
+
+func (w *auditWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.out.Close()
+	if herr := w.head.Close(); err == nil {
+		err = herr
+	}
+	return err
+}
+
+// chainHash hashes a record whose closing brace is still missing.
+func chainHash(open []byte) string {
+	h := sha256.New()
+	h.Write(open)
+	h.Write([]byte{'}'})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func readHead(f *os.File) (uint64, string, bool) {
+	buf := make([]byte, headWidth)
+	n, _ := f.ReadAt(buf, 0)
+	var seq uint64
+	var hash string
+	if n != headWidth {
+		return 0, "", false
+	}
+	if _, err := fmt.Sscanf(string(buf), "%d %s", &seq, &hash); err != nil || len(hash) != len(GenesisHash) {
+		return 0, "", false
+	}
+	return seq, hash, true
+}
+
+// lastRecord reads the chain position from the newest record in path.
+func lastRecord(path string) (uint64, string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", false
+	}
+	defer f.Close()
+	var last []byte
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) > 0 {
+			last = append(last[:0], sc.Bytes()...)
+		}
+	}
+	rec, err := parseAuditLine(last)
+	if err != nil {
+		return 0, "", false
+	}
+	return rec.Seq, rec.Hash, true
+}
+
+type auditRecord struct {
+	Seq  uint64 `json:"seq"`
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+// parseAuditLine checks the hash of one line and returns its chain fields.
+func parseAuditLine(line []byte) (auditRecord, error) {
+	var rec auditRecord
+	i := bytes.LastIndex(line, []byte(`,"hash":"`))
+	if i < 0 || !bytes.HasSuffix(line, []byte(`"}`)) {
+		return rec, errors.New("no hash")
+	}
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return rec, err
+	}
+	if got := chainHash(line[:i]); got != rec.Hash || string(line[i+9:len(line)-2]) != rec.Hash {
+		return rec, errors.New("hash mismatch")
+	}
+	return rec, nil
+}
+
+// AuditFiles returns path and its rotated files, oldest first.
+func AuditFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	rotated, err := filepath.Glob(stem + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rotated) // lumberjack stamps sort in time order
+	files := rotated
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("zrlogger: no audit files at %s", path)
+	}
+	return files, nil
+}
+
+// AuditProblem is one break in the chain.
+type AuditProblem struct {
+	File string
+	Line int // 0: the file as a whole
+	Msg  string
+}
+
+func (p AuditProblem) String() string {
+	if p.Line == 0 {
+		return p.File + ": " + p.Msg
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+}
+
+// AuditReport is the outcome of VerifyAudit.
+type AuditReport struct {
+	Files    []string
+	Records  uint64
+	LastSeq  uint64
+	LastHash string
+	Problems []AuditProblem
+}
+
+func (r *AuditReport) OK() bool { return len(r.Problems) == 0 }
+
+func (r *AuditReport) add(file string, line int, format string, args ...any) {
+	r.Problems = append(r.Problems, AuditProblem{File: file, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// VerifyAudit checks the chain across files, oldest first, from the genesis
+// record on: every hash, every link and the seq numbering. head, when not
+// empty, is the .head file the chain must end at.
+func VerifyAudit(files []string, head string) (*AuditReport, error) {
+	r := &AuditReport{Files: files, LastHash: GenesisHash}
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		n := 0
+		for sc.Scan() {
+			n++
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			rec, err := parseAuditLine(line)
+			if err != nil {
+				r.add(name, n, "record modified: %v", err)
+				// resync on the record's own claims so one edit is one problem
+				r.LastSeq, r.LastHash = rec.Seq, rec.Hash
+				continue
+			}
+			switch {
+			case rec.Seq != r.LastSeq+1:
+				r.add(name, n, "seq %d follows %d: records missing or reordered", rec.Seq, r.LastSeq)
+			case rec.Prev != r.LastHash:
+				r.add(name, n, "prev hash does not match record %d", r.LastSeq)
+			}
+			r.Records++
+			r.LastSeq, r.LastHash = rec.Seq, rec.Hash
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if n == 0 {
+			r.add(name, 0, "empty file")
+		}
+	}
+	if head == "" {
+		return r, nil
+	}
+	hf, err := os.Open(head)
+	if err != nil {
+		return nil, err
+	}
+	defer hf.Close()
+	seq, hash, ok := readHead(hf)
+	switch {
+	case !ok:
+		r.add(head, 0, "unreadable head")
+	case seq > r.LastSeq:
+		r.add(head, 0, "chain ends at seq %d but head is at %d: truncated", r.LastSeq, seq)
+	case seq < r.LastSeq:
+		r.add(head, 0, "chain runs to seq %d past head %d", r.LastSeq, seq)
+	case hash != r.LastHash:
+		r.add(head, 0, "last record hash does not match head")
+	}
+	return r, nil
+}
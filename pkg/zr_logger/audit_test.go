@@ -0,0 +1,147 @@
+package zrlogger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeChain writes n records to a fresh audit file and returns its path.
+func writeChain(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	appendChain(t, path, 1, n)
+	return path
+}
+
+func appendChain(t *testing.T, path string, from, n int) {
+	t.Helper()
+	w, err := openAudit(&Options{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < from+n; i++ {
+		if _, err := fmt.Fprintf(w, `{"level":"info","n":%d,"message":"event"}`+"\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func verify(t *testing.T, path string) *AuditReport {
+	t.Helper()
+	r, err := VerifyAudit([]string{path}, path+".head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func editLines(t *testing.T, path string, edit func([][]byte) [][]byte) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if err := os.WriteFile(path, bytes.Join(edit(lines), nil), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditChainIntact(t *testing.T) {
+	path := writeChain(t, 5)
+	appendChain(t, path, 6, 2) // reopened writers continue the chain
+	r := verify(t, path)
+	if !r.OK() || r.Records != 7 || r.LastSeq != 7 {
+		t.Fatalf("records %d, last %d, problems %v", r.Records, r.LastSeq, r.Problems)
+	}
+}
+
+func TestAuditTamper(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, path string)
+		want   string
+	}{
+		{
+			name: "line modified",
+			tamper: func(t *testing.T, path string) {
+				editLines(t, path, func(l [][]byte) [][]byte {
+					l[2] = bytes.Replace(l[2], []byte(`"n":3`), []byte(`"n":9`), 1)
+					return l
+				})
+			},
+			want: "record modified",
+		},
+		{
+			name: "line deleted",
+			tamper: func(t *testing.T, path string) {
+				editLines(t, path, func(l [][]byte) [][]byte { return append(l[:2], l[3:]...) })
+			},
+			want: "records missing",
+		},
+		{
+			name: "file truncated",
+			tamper: func(t *testing.T, path string) {
+				editLines(t, path, func(l [][]byte) [][]byte { return l[:3] })
+			},
+			want: "truncated",
+		},
+		{
+			name: "head disagrees",
+			tamper: func(t *testing.T, path string) {
+				h := fmt.Sprintf("%020d %s\n", 5, GenesisHash)
+				if err := os.WriteFile(path+".head", []byte(h), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "does not match head",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeChain(t, 5)
+			tt.tamper(t, path)
+			r := verify(t, path)
+			if r.OK() {
+				t.Fatal("tampering not detected")
+			}
+			var msgs []string
+			for _, p := range r.Problems {
+				msgs = append(msgs, p.String())
+			}
+			if got := strings.Join(msgs, "\n"); !strings.Contains(got, tt.want) {
+				t.Errorf("problems %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// A crash between the record and the .head write leaves .head stale; the
+// next writer picks up from the file and keeps the chain whole.
+func TestAuditStaleHead(t *testing.T) {
+	path := writeChain(t, 3)
+	stale, err := os.ReadFile(path + ".head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendChain(t, path, 4, 1)
+	if err := os.WriteFile(path+".head", stale, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	appendChain(t, path, 5, 2)
+	r := verify(t, path)
+	if !r.OK() || r.LastSeq != 6 {
+		t.Fatalf("last %d, problems %v", r.LastSeq, r.Problems)
+	}
+}
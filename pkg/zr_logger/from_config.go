@@ -39,11 +39,9 @@ func OptionsFromConfig(cfg *config.Config) *Options {
 	}
 
 	// set file path
-	filePath := ""
-	if cfg.Log.File.Path != "" {
+	filePath := cfg.Log.File.Path
+	if filePath == "" {
 		filePath = "logs/app.log"
-	} else {
-		filePath = cfg.Log.File.Path
 	}
 
 	sizeMB := cfg.Log.File.MaxSizeMB
@@ -61,6 +59,9 @@ func OptionsFromConfig(cfg *config.Config) *Options {
 		maxAgeDays = cfg.Log.File.MaxAgeDays
 	}
 
+	// time based rotation; Validate rejects unknown intervals
+	interval, _ := ParseInterval(cfg.Log.Rotation.Interval)
+
 	// time & duration
 	timeFormat := cfg.Log.Time.FieldFormat
 	if timeFormat == "" || strings.EqualFold(timeFormat, "RFC3339Nano") {
@@ -85,6 +86,15 @@ func OptionsFromConfig(cfg *config.Config) *Options {
 			burstPeriod = parsed
 		}
 	}
+	var maxLevel *zerolog.Level
+	if l, ok := tryParseLevel(strings.TrimSpace(cfg.Log.Sampler.Level)); ok {
+		maxLevel = &l
+	}
+	var reportEvery time.Duration
+	if d, err := time.ParseDuration(strings.TrimSpace(cfg.Log.Sampler.ReportInterval)); err == nil && d > 0 {
+		reportEvery = d
+	}
+
 	return &Options{
 		Env:           env,
 		Service:       cfg.Log.Metadata.Service,
@@ -95,6 +105,8 @@ func OptionsFromConfig(cfg *config.Config) *Options {
 		ConsoleEnable:        dev,
 		ConsolePipe:          cfg.Log.Console.Pipe || dev,
 		ConsoleFieldsExclude: cfg.Log.Console.FieldsExclude,
+		ConsoleFieldOrder:    cfg.Log.Console.FieldOrder,
+		ConsoleColor:         strings.TrimSpace(cfg.Log.Console.Color),
 
 		// File
 		FileEnable:     cfg.Log.File.Enable || true,
@@ -104,6 +116,9 @@ func OptionsFromConfig(cfg *config.Config) *Options {
 		FileMaxAgeDays: maxAgeDays,
 		FileCompress:   cfg.Log.File.Compress,
 
+		FileRotateInterval: interval,
+		FileRotateKeep:     cfg.Log.Rotation.Count,
+
 		// Time
 		TimeFieldFormat:      timeFormat,
 		DurationFieldUnit:    durUnit,
@@ -119,5 +134,82 @@ func OptionsFromConfig(cfg *config.Config) *Options {
 		SamplerBurstPeriod: burstPeriod,
 		SamplerNextEveryN:  cfg.Log.Sampler.NextEveryN,
 		SamplerBasicN:      cfg.Log.Sampler.BasicN,
+		SamplerMaxLevel:    maxLevel,
+		SamplingLevel:      levelSamplingFromConfig(cfg.Log.Sampler.Levels),
+
+		SamplerReportInterval: reportEvery,
+
+		Sinks: sinksFromConfig(cfg.Log.Sinks),
+	}
+}
+
+func sinksFromConfig(sinks []config.LogSink) []SinkOptions {
+	out := make([]SinkOptions, 0, len(sinks))
+	for _, s := range sinks {
+		so := SinkOptions{
+			Name:          strings.TrimSpace(s.Name),
+			Type:          strings.TrimSpace(s.Type),
+			Format:        strings.TrimSpace(s.Format),
+			Path:          strings.TrimSpace(s.Path),
+			Address:       strings.TrimSpace(s.Address),
+			Tag:           s.Tag,
+			Size:          s.Size,
+			FieldsInclude: s.FieldsInclude,
+			FieldsExclude: s.FieldsExclude,
+			FieldOrder:    s.FieldOrder,
+			Color:         strings.TrimSpace(s.Color),
+		}
+		if l, ok := tryParseLevel(strings.TrimSpace(s.Level)); ok {
+			so.Level = &l
+		}
+		out = append(out, so)
+	}
+	return out
+}
+
+// levelSamplingFromConfig maps log.sampler.levels; nil when empty.
+func levelSamplingFromConfig(levels map[string]config.LogLevelSampler) *LevelSamplingOptions {
+	if len(levels) == 0 {
+		return nil
+	}
+	ls := &LevelSamplingOptions{}
+	for name, l := range levels {
+		spec := &SamplerSpec{Burst: l.Burst, NextEveryN: l.NextEveryN, BasicN: l.BasicN}
+		if d, err := time.ParseDuration(strings.TrimSpace(l.BurstPeriod)); err == nil {
+			spec.Period = d
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "trace":
+			ls.Trace = spec
+		case "debug":
+			ls.Debug = spec
+		case "info":
+			ls.Info = spec
+		case "warn", "warning":
+			ls.Warning = spec
+		}
+	}
+	return ls
+}
+
+// LevelFromConfig resolves the level Init would use for cfg; use it with
+// SetLevel after a config reload.
+func LevelFromConfig(cfg *config.Config) zerolog.Level {
+	return resolveLevel(OptionsFromConfig(cfg))
+}
+
+// ApplyLevels sets the sinks of l to the levels in a reloaded cfg: the
+// default level, then each sink's own. Sinks missing from l are skipped;
+// adding or removing sinks needs a restart.
+func ApplyLevels(l *Logger, cfg *config.Config) {
+	l.SetLevel(LevelFromConfig(cfg))
+	for _, so := range sinksFromConfig(cfg.Log.Sinks) {
+		name := so.Name
+		if name == "" {
+			name = strings.ToLower(so.Type)
+		}
+		if so.Level != nil {
+			_ = l.SetSinkLevel(name, *so.Level)
+		}
 	}
 }
@@ -0,0 +1,79 @@
+package zrlogger
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// Sink levels are atomic and every logger is built at trace level behind a
+// gate sampler, so level changes reach loggers that were already handed out.
+
+// gate drops events no sink wants before they are built, then hands over to
+// the sampling configured for the target. Gated events are not counted as
+// dropped.
+type gate struct {
+	sinks []*sink
+	next  zerolog.Sampler
+}
+
+func (g *gate) Sample(l zerolog.Level) bool {
+	if l < minLevel(g.sinks, zerolog.Disabled) {
+		return false
+	}
+	return g.next == nil || g.next.Sample(l)
+}
+
+// SetLevel sets every sink to lvl.
+func (l *Logger) SetLevel(lvl zerolog.Level) {
+	lvl = clampLevel(lvl)
+	for _, s := range l.sinks {
+		s.level.Store(int32(lvl))
+	}
+	l.syncGlobal()
+}
+
+// SetSinkLevel sets the level of the named sink.
+func (l *Logger) SetSinkLevel(name string, lvl zerolog.Level) error {
+	for _, s := range l.sinks {
+		if s.name == name {
+			s.level.Store(int32(clampLevel(lvl)))
+			l.syncGlobal()
+			return nil
+		}
+	}
+	return fmt.Errorf("zrlogger: no sink %q", name)
+}
+
+// Shift moves every sink step levels, negative is more verbose, within
+// trace..panic.
+func (l *Logger) Shift(step int) {
+	for _, s := range l.sinks {
+		s.level.Store(int32(clampLevel(s.getLevel() + zerolog.Level(step))))
+	}
+	l.syncGlobal()
+}
+
+// Levels returns the level of each sink by name.
+func (l *Logger) Levels() map[string]string {
+	out := make(map[string]string, len(l.sinks))
+	for _, s := range l.sinks {
+		out[s.name] = s.getLevel().String()
+	}
+	return out
+}
+
+// syncGlobal keeps the zerolog global level at the lowest sink level when
+// the logger owns it, so other zerolog loggers follow too.
+func (l *Logger) syncGlobal() {
+	if l.global && len(l.sinks) > 0 {
+		zerolog.SetGlobalLevel(minLevel(l.sinks, zerolog.Disabled))
+	}
+}
+
+// SetLevel sets every sink of the singleton, including those behind
+// Console and File.
+func SetLevel(lvl zerolog.Level) { rootLogger.SetLevel(lvl) }
+
+// SetSinkLevel sets one sink of the singleton.
+func SetSinkLevel(name string, lvl zerolog.Level) error { return rootLogger.SetSinkLevel(name, lvl) }
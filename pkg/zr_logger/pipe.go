@@ -0,0 +1,343 @@
+package zrlogger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"github.com/rs/zerolog"
+)
+
+// Color modes of the pipe and console formats.
+const (
+	ColorAuto   = "auto" // when writing to a terminal
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+var levelColors = map[string]string{
+	"TRACE": "\x1b[35m",   // magenta
+	"DEBUG": "\x1b[33m",   // yellow
+	"INFO":  "\x1b[32m",   // green
+	"WARN":  "\x1b[31m",   // red
+	"ERROR": "\x1b[1;31m", // bold red
+	"FATAL": "\x1b[1;31m",
+	"PANIC": "\x1b[1;31m",
+}
+
+const colorReset = "\x1b[0m"
+
+var errBadJSON = errors.New("zrlogger: malformed event")
+
+// pipeWriter renders JSON events as
+//
+//	time | LEVEL | message | k=v | k=v
+//	    at func (source:line)
+//
+// without decoding into a map. Fields come in order's priority, then
+// alphabetically; nested objects flatten to parent.child=v and a stack
+// array prints one frame per line. Not safe for concurrent use; sinks
+// serialize writes.
+type pipeWriter struct {
+	out              io.Writer
+	order            map[string]int // field priority, lower first
+	color            bool
+	include, exclude map[string]struct{}
+
+	buf    []byte
+	fields []pipeField
+}
+
+type pipeField struct {
+	key, val string
+	rank     int
+}
+
+func newPipeWriter(out io.Writer, order []string, color bool) *pipeWriter {
+	w := &pipeWriter{out: out, color: color}
+	if len(order) > 0 {
+		w.order = make(map[string]int, len(order))
+		for i, k := range order {
+			if _, dup := w.order[k]; !dup {
+				w.order[k] = i
+			}
+		}
+	}
+	return w
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	line, err := w.format(p)
+	if err != nil {
+		return w.out.Write(p) // not an event, pass through
+	}
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *pipeWriter) format(p []byte) ([]byte, error) {
+	var ts, lvl, msg string
+	var stack []byte
+	w.fields = w.fields[:0]
+	err := eachField(p, func(key string, val []byte) error {
+		switch key {
+		case zerolog.TimestampFieldName:
+			ts = scalar(val)
+		case zerolog.LevelFieldName:
+			lvl = strings.ToUpper(scalar(val))
+		case zerolog.MessageFieldName:
+			msg = scalar(val)
+		case zerolog.ErrorStackFieldName:
+			if w.keep(key) {
+				stack = val
+			}
+		default:
+			if !w.keep(key) {
+				return nil
+			}
+			return w.add(key, key, val)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(w.fields, func(i, j int) bool {
+		a, b := w.fields[i], w.fields[j]
+		if a.rank != b.rank {
+			return a.rank < b.rank
+		}
+		return a.key < b.key
+	})
+
+	b := w.buf[:0]
+	sep := func() {
+		if len(b) > 0 {
+			b = append(b, " | "...)
+		}
+	}
+	if ts != "" {
+		b = append(b, ts...)
+	}
+	if lvl != "" {
+		sep()
+		if c, ok := levelColors[lvl]; ok && w.color {
+			b = append(append(append(b, c...), lvl...), colorReset...)
+		} else {
+			b = append(b, lvl...)
+		}
+	}
+	if msg != "" {
+		sep()
+		b = append(b, msg...)
+	}
+	for _, f := range w.fields {
+		sep()
+		b = append(append(append(b, f.key...), '='), quoteIfNeeded(f.val)...)
+	}
+	if stack != nil {
+		b = appendStack(b, stack)
+	}
+	b = append(b, '\n')
+	w.buf = b
+	return b, nil
+}
+
+// keep applies the field filters to a top-level key.
+func (w *pipeWriter) keep(key string) bool {
+	if _, skip := w.exclude[key]; skip {
+		return false
+	}
+	if w.include == nil {
+		return true
+	}
+	_, ok := w.include[key]
+	return ok
+}
+
+// add appends val under key, flattening objects; top is the top-level key
+// that ranks the field.
+func (w *pipeWriter) add(top, key string, val []byte) error {
+	if len(val) > 0 && val[0] == '{' {
+		return eachField(val, func(k string, v []byte) error {
+			return w.add(top, key+"."+k, v)
+		})
+	}
+	rank, ok := w.order[top]
+	if !ok {
+		rank = len(w.order)
+	}
+	w.fields = append(w.fields, pipeField{key: key, val: scalar(val), rank: rank})
+	return nil
+}
+
+// appendStack renders a pkgerrors stack, [{"func","line","source"}...], one
+// frame per line; anything else prints as stack=<json>.
+func appendStack(b, stack []byte) []byte {
+	var frames []map[string]string
+	if err := json.Unmarshal(stack, &frames); err != nil {
+		return append(append(b, " | stack="...), stack...)
+	}
+	for _, f := range frames {
+		b = append(b, "\n    at "...)
+		b = append(b, f["func"]...)
+		b = append(b, " ("...)
+		b = append(b, f["source"]...)
+		b = append(b, ':')
+		b = append(b, f["line"]...)
+		b = append(b, ')')
+	}
+	return b
+}
+
+// quoteIfNeeded keeps values that could be confused with the separators
+// on one unambiguous token.
+func quoteIfNeeded(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if strings.ContainsAny(s, " |=\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// scalar is the text of a JSON value: strings unquoted, anything else as
+// written.
+func scalar(val []byte) string {
+	if len(val) < 2 || val[0] != '"' {
+		return string(val)
+	}
+	inner := val[1 : len(val)-1]
+	if bytes.IndexByte(inner, '\\') < 0 {
+		return string(inner)
+	}
+	var s string
+	if err := json.Unmarshal(val, &s); err != nil {
+		return string(inner)
+	}
+	return s
+}
+
+// eachField calls fn with every key and raw value of the JSON object in b,
+// in document order.
+func eachField(b []byte, fn func(key string, val []byte) error) error {
+	i := skipSpace(b, 0)
+	if i >= len(b) || b[i] != '{' {
+		return errBadJSON
+	}
+	i = skipSpace(b, i+1)
+	if i < len(b) && b[i] == '}' {
+		return nil
+	}
+	for i < len(b) {
+		if b[i] != '"' {
+			return errBadJSON
+		}
+		end, err := valueEnd(b, i)
+		if err != nil {
+			return err
+		}
+		key := scalar(b[i:end])
+		i = skipSpace(b, end)
+		if i >= len(b) || b[i] != ':' {
+			return errBadJSON
+		}
+		i = skipSpace(b, i+1)
+		end, err = valueEnd(b, i)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, b[i:end]); err != nil {
+			return err
+		}
+		i = skipSpace(b, end)
+		if i >= len(b) {
+			break
+		}
+		switch b[i] {
+		case ',':
+			i = skipSpace(b, i+1)
+		case '}':
+			return nil
+		default:
+			return errBadJSON
+		}
+	}
+	return errBadJSON
+}
+
+// valueEnd returns the index just past the JSON value starting at i.
+func valueEnd(b []byte, i int) (int, error) {
+	if i >= len(b) {
+		return 0, errBadJSON
+	}
+	switch b[i] {
+	case '"':
+		for j := i + 1; j < len(b); j++ {
+			switch b[j] {
+			case '\\':
+				j++
+			case '"':
+				return j + 1, nil
+			}
+		}
+		return 0, errBadJSON
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(b); j++ {
+			switch b[j] {
+			case '"':
+				end, err := valueEnd(b, j)
+				if err != nil {
+					return 0, err
+				}
+				j = end - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return 0, errBadJSON
+	default:
+		j := i
+		for j < len(b) && !strings.ContainsRune(",}] \t\r\n", rune(b[j])) {
+			j++
+		}
+		if j == i {
+			return 0, errBadJSON
+		}
+		return j, nil
+	}
+}
+
+func skipSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\r' || b[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// useColor resolves a color mode for out.
+func useColor(mode string, out io.Writer) bool {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	f, ok := out.(*os.File)
+	return ok && (isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd()))
+}
@@ -0,0 +1,100 @@
+package zrlogger
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPipeFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		order   []string
+		color   bool
+		include []string
+		exclude []string
+		in      string
+		want    string
+	}{
+		{
+			name: "fields sorted",
+			in:   `{"level":"info","zeta":1,"alpha":"a","time":"2024-01-01T00:00:00Z","message":"started"}`,
+			want: "2024-01-01T00:00:00Z | INFO | started | alpha=a | zeta=1\n",
+		},
+		{
+			name:  "field order first",
+			order: []string{"kind", "stan"},
+			in:    `{"time":"t","level":"warn","message":"timeout","rrn":"R1","stan":"000123","kind":"timeout","age":3}`,
+			want:  "t | WARN | timeout | kind=timeout | stan=000123 | age=3 | rrn=R1\n",
+		},
+		{
+			name: "nested objects flatten",
+			in:   `{"level":"debug","message":"m","req":{"mti":"0200","de":{"39":"00"}},"n":null,"ok":true}`,
+			want: "DEBUG | m | n=null | ok=true | req.de.39=00 | req.mti=0200\n",
+		},
+		{
+			name: "quoting",
+			in:   `{"level":"error","message":"bad | thing","path":"a b","eq":"k=v","empty":"","esc":"tab\there","arr":[1,2]}`,
+			want: "ERROR | bad | thing | arr=[1,2] | empty=\"\" | eq=\"k=v\" | esc=\"tab\\there\" | path=\"a b\"\n",
+		},
+		{
+			name: "stack one frame per line",
+			in:   `{"level":"error","message":"failed","error":"boom","stack":[{"func":"run","line":"12","source":"main.go"},{"func":"main","line":"3","source":"main.go"}]}`,
+			want: "ERROR | failed | error=boom\n    at run (main.go:12)\n    at main (main.go:3)\n",
+		},
+		{
+			name: "unknown stack shape",
+			in:   `{"level":"error","message":"failed","stack":"raw"}`,
+			want: "ERROR | failed | stack=\"raw\"\n",
+		},
+		{
+			name:  "color",
+			color: true,
+			in:    `{"level":"info","message":"hi"}`,
+			want:  "\x1b[32mINFO\x1b[0m | hi\n",
+		},
+		{
+			name:    "include",
+			include: []string{"keep"},
+			in:      `{"time":"t","level":"info","message":"m","keep":1,"drop":2}`,
+			want:    "t | INFO | m | keep=1\n",
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"drop", "stack"},
+			in:      `{"level":"info","message":"m","keep":1,"drop":2,"stack":[]}`,
+			want:    "INFO | m | keep=1\n",
+		},
+		{
+			name: "not an event passes through",
+			in:   "plain text\n",
+			want: "plain text\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := newPipeWriter(&out, tt.order, tt.color)
+			w.include, w.exclude = setFromSlice(tt.include), setFromSlice(tt.exclude)
+			n, err := w.Write([]byte(tt.in))
+			if err != nil || n != len(tt.in) {
+				t.Fatalf("Write = %d, %v; want %d, nil", n, err, len(tt.in))
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// The writer reuses its buffers; a second event must not carry over
+// fields from the first.
+func TestPipeFormatReuse(t *testing.T) {
+	var out bytes.Buffer
+	w := newPipeWriter(&out, nil, false)
+	w.Write([]byte(`{"level":"info","message":"one","a":1,"b":2}`))
+	w.Write([]byte(`{"level":"info","message":"two","c":3}`))
+	want := "INFO | one | a=1 | b=2\nINFO | two | c=3\n"
+	if got := out.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package zrlogger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Interval is how often the file log starts a new period.
+type Interval string
+
+const (
+	IntervalNone   Interval = "" // size based only
+	IntervalHourly Interval = "hourly"
+	IntervalDaily  Interval = "daily"
+	IntervalWeekly Interval = "weekly"
+)
+
+// ParseInterval accepts "", "hourly", "daily" and "weekly".
+func ParseInterval(s string) (Interval, error) {
+	switch iv := Interval(strings.ToLower(strings.TrimSpace(s))); iv {
+	case IntervalNone, IntervalHourly, IntervalDaily, IntervalWeekly:
+		return iv, nil
+	}
+	return IntervalNone, fmt.Errorf("unknown rotation interval %q, want hourly|daily|weekly", s)
+}
+
+// start is the beginning of the period holding t.
+func (iv Interval) start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch iv {
+	case IntervalHourly:
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case IntervalWeekly:
+		day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		return day.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7)) // back to Monday
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// stamp names the period starting at t.
+func (iv Interval) stamp(t time.Time) string {
+	switch iv {
+	case IntervalHourly:
+		return t.Format("2006-01-02T15")
+	case IntervalWeekly:
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", y, w)
+	default:
+		return t.Format("2006-01-02")
+	}
+}
+
+// isStamp reports whether s could be a period name from stamp.
+func (iv Interval) isStamp(s string) bool {
+	switch iv {
+	case IntervalHourly:
+		_, err := time.Parse("2006-01-02T15", s)
+		return err == nil
+	case IntervalWeekly:
+		var y, w int
+		n, err := fmt.Sscanf(s, "%04d-W%02d", &y, &w)
+		return err == nil && n == 2 && w >= 1 && w <= 53 && fmt.Sprintf("%04d-W%02d", y, w) == s
+	default:
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	}
+}
+
+// periodWriter writes logs/app.log as logs/app-<period>/app-<period>.log,
+// switching folder when the period ends. Within a period lumberjack still
+// rotates by size. Only the newest keep period folders are kept (0: all).
+type periodWriter struct {
+	mu       sync.Mutex
+	interval Interval
+	dir      string // parent of the period folders
+	stem     string // "app"
+	ext      string // ".log"
+	keep     int
+	now      func() time.Time
+
+	// lumberjack settings applied to every period
+	maxSize, maxBackups, maxAge int
+	compress                    bool
+
+	period time.Time
+	lj     *lumberjack.Logger
+}
+
+func newPeriodWriter(path string, iv Interval, keep int, maxSize, maxBackups, maxAge int, compress bool) *periodWriter {
+	ext := filepath.Ext(path)
+	return &periodWriter{
+		interval:   iv,
+		dir:        filepath.Dir(path),
+		stem:       strings.TrimSuffix(filepath.Base(path), ext),
+		ext:        ext,
+		keep:       keep,
+		now:        time.Now,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+		compress:   compress,
+	}
+}
+
+func (w *periodWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if start := w.interval.start(w.now()); w.lj == nil || !start.Equal(w.period) {
+		if err := w.open(start); err != nil {
+			return 0, err
+		}
+	}
+	return w.lj.Write(p)
+}
+
+func (w *periodWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.lj == nil {
+		return nil
+	}
+	err := w.lj.Close()
+	w.lj = nil
+	return err
+}
+
+// open switches to the period starting at start.
+func (w *periodWriter) open(start time.Time) error {
+	if w.lj != nil {
+		_ = w.lj.Close()
+	}
+	name := w.stem + "-" + w.interval.stamp(start)
+	dir := filepath.Join(w.dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	w.period = start
+	w.lj = &lumberjack.Logger{
+		Filename:   filepath.Join(dir, name+w.ext),
+		MaxSize:    w.maxSize,
+		MaxBackups: w.maxBackups,
+		MaxAge:     w.maxAge,
+		Compress:   w.compress,
+	}
+	w.prune()
+	return nil
+}
+
+// prune removes the oldest period folders beyond keep. Stamps sort in time
+// order, so the names do. Folders whose suffix is not a stamp of the
+// interval, e.g. app-backup, are left alone.
+func (w *periodWriter) prune() {
+	if w.keep <= 0 {
+		return
+	}
+	dirs, _ := filepath.Glob(filepath.Join(w.dir, w.stem+"-*"))
+	var periods []string
+	for _, d := range dirs {
+		suffix := strings.TrimPrefix(filepath.Base(d), w.stem+"-")
+		if !w.interval.isStamp(suffix) {
+			continue
+		}
+		if fi, err := os.Stat(d); err == nil && fi.IsDir() {
+			periods = append(periods, d)
+		}
+	}
+	sort.Strings(periods)
+	for len(periods) > w.keep {
+		_ = os.RemoveAll(periods[0])
+		periods = periods[1:]
+	}
+}
@@ -0,0 +1,82 @@
+package zrlogger
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIntervalStamp(t *testing.T) {
+	at := time.Date(2024, 3, 7, 15, 42, 0, 0, time.UTC) // a Thursday
+	tests := []struct {
+		iv    Interval
+		start time.Time
+		stamp string
+	}{
+		{IntervalHourly, time.Date(2024, 3, 7, 15, 0, 0, 0, time.UTC), "2024-03-07T15"},
+		{IntervalDaily, time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC), "2024-03-07"},
+		{IntervalWeekly, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), "2024-W10"},
+	}
+	for _, tt := range tests {
+		start := tt.iv.start(at)
+		if !start.Equal(tt.start) {
+			t.Errorf("%s: start %v, want %v", tt.iv, start, tt.start)
+		}
+		if got := tt.iv.stamp(start); got != tt.stamp {
+			t.Errorf("%s: stamp %q, want %q", tt.iv, got, tt.stamp)
+		}
+		if !tt.iv.isStamp(tt.stamp) {
+			t.Errorf("%s: %q not a stamp", tt.iv, tt.stamp)
+		}
+	}
+	for _, s := range []string{"backup", "old", "2024-13-01", "2024-W60", "2024-03-07T15x"} {
+		if IntervalDaily.isStamp(s) || IntervalWeekly.isStamp(s) || IntervalHourly.isStamp(s) {
+			t.Errorf("%q taken for a stamp", s)
+		}
+	}
+}
+
+func entries(t *testing.T, dir string) []string {
+	t.Helper()
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, de := range des {
+		names = append(names, de.Name())
+	}
+	return names
+}
+
+func TestPeriodRollover(t *testing.T) {
+	dir := t.TempDir()
+	for _, other := range []string{"app-backup", "app-old", "app-2024-W01"} {
+		if err := os.Mkdir(filepath.Join(dir, other), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2024, 3, 7, 10, 0, 0, 0, time.UTC)
+	w := newPeriodWriter(filepath.Join(dir, "app.log"), IntervalDaily, 2, 1, 0, 0, false)
+	w.now = func() time.Time { return now }
+	defer w.Close()
+
+	for day := 0; day < 4; day++ {
+		if _, err := w.Write([]byte("line\n")); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(24 * time.Hour)
+	}
+
+	want := []string{"app-2024-03-09", "app-2024-03-10", "app-2024-W01", "app-backup", "app-old"}
+	if got := entries(t, dir); !slices.Equal(got, want) {
+		t.Fatalf("entries %v, want %v", got, want)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "app-2024-03-10", "app-2024-03-10.log"))
+	if err != nil || string(b) != "line\n" {
+		t.Errorf("newest period: %q, %v", b, err)
+	}
+}
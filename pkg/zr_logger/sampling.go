@@ -0,0 +1,136 @@
+package zrlogger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const numLevels = int(zerolog.PanicLevel-zerolog.TraceLevel) + 1
+
+func levelIndex(l zerolog.Level) int { return int(l - zerolog.TraceLevel) }
+
+// levelSampler picks a sampler per level and counts what it drops. Levels
+// without a sampler always pass. Error and above are never sampled: a
+// dropped fatal event still exits and a dropped panic still panics, both
+// without their message.
+type levelSampler struct {
+	samplers [numLevels]zerolog.Sampler
+	dropped  *levelDrops
+}
+
+// set reports whether l takes a sampler; error and above do not.
+func (s *levelSampler) set(l zerolog.Level, sm zerolog.Sampler) bool {
+	if i := levelIndex(l); i >= 0 && l < zerolog.ErrorLevel {
+		s.samplers[i] = sm
+		return true
+	}
+	return false
+}
+
+func (s *levelSampler) Sample(l zerolog.Level) bool {
+	i := levelIndex(l)
+	if i < 0 || i >= numLevels || s.samplers[i] == nil || s.samplers[i].Sample(l) {
+		return true
+	}
+	s.dropped[i].Add(1)
+	return false
+}
+
+type levelDrops [numLevels]atomic.Uint64
+
+// dropTable holds the drop counters of every target logger.
+type dropTable struct {
+	mu      sync.Mutex
+	targets map[string]*levelDrops
+}
+
+// drops counts for the singleton; New and NewTargets get their own.
+var drops = newDropTable()
+
+func newDropTable() *dropTable {
+	return &dropTable{targets: make(map[string]*levelDrops)}
+}
+
+func (t *dropTable) target(name string) *levelDrops {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.targets[name]
+	if !ok {
+		d = new(levelDrops)
+		t.targets[name] = d
+	}
+	return d
+}
+
+// Dropped returns the events dropped by sampling so far, by target
+// ("console", "file") and level name. Zero counts are left out.
+func Dropped() map[string]map[string]uint64 { return drops.dropped() }
+
+// Dropped is like the package Dropped, for a logger from New.
+func (l *Logger) Dropped() map[string]map[string]uint64 {
+	if l.drops == nil {
+		return nil
+	}
+	return l.drops.dropped()
+}
+
+func (t *dropTable) dropped() map[string]map[string]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]map[string]uint64)
+	for name, d := range t.targets {
+		for i := range d {
+			if n := d[i].Load(); n > 0 {
+				if out[name] == nil {
+					out[name] = make(map[string]uint64)
+				}
+				out[name][(zerolog.TraceLevel + zerolog.Level(i)).String()] = n
+			}
+		}
+	}
+	return out
+}
+
+// reportDrops logs the events dropped in each interval through the logger
+// of their target: L for "log", Console or File for the others. Quiet
+// intervals log nothing.
+func reportDrops(every time.Duration) {
+	last := map[string]map[string]uint64{}
+	for range time.Tick(every) {
+		last = logDrops(Dropped(), last, every, dropLogger)
+	}
+}
+
+func dropLogger(target string) zerolog.Logger {
+	switch target {
+	case "console":
+		return consoleLogger
+	case "file":
+		return fileLogger
+	}
+	return rootLogger.Logger
+}
+
+// logDrops logs what cur adds to last and returns cur.
+func logDrops(cur, last map[string]map[string]uint64, every time.Duration, logger func(string) zerolog.Logger) map[string]map[string]uint64 {
+	for target, levels := range cur {
+		ev := zerolog.Dict()
+		n := uint64(0)
+		for lvl, v := range levels {
+			if d := v - last[target][lvl]; d > 0 {
+				ev = ev.Uint64(lvl, d)
+				n += d
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		// the report itself is never sampled
+		l := logger(target).Sample(nil)
+		l.Log().Str("target", target).Uint64("dropped", n).Dict("levels", ev).Dur("interval", every).Msg("log events dropped by sampling")
+	}
+	return cur
+}
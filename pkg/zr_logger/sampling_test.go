@@ -0,0 +1,64 @@
+package zrlogger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Drops of the "log" facade are reported through L, so the report is kept
+// with the file off.
+func TestDropReportWithoutFile(t *testing.T) {
+	root, console, file := rootLogger, consoleLogger, fileLogger
+	defer func() { rootLogger, consoleLogger, fileLogger = root, console, file }()
+
+	var out bytes.Buffer
+	// console-only setup: the facade writes out, the file logger nowhere;
+	// the warn gate must not hold back the unleveled report
+	rootLogger = &Logger{Logger: zerolog.New(&out).Level(zerolog.WarnLevel)}
+	consoleLogger, fileLogger = rootLogger.Logger, zerolog.Nop()
+
+	cur := map[string]map[string]uint64{"log": {"debug": 7}}
+	last := logDrops(cur, nil, time.Minute, dropLogger)
+	got := out.String()
+	for _, want := range []string{`"target":"log"`, `"dropped":7`, `"levels":{"debug":7}`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("report %q lacks %s", got, want)
+		}
+	}
+
+	out.Reset()
+	logDrops(map[string]map[string]uint64{"log": {"debug": 7}}, last, time.Minute, dropLogger)
+	if out.Len() != 0 {
+		t.Errorf("quiet interval logged %q", out.String())
+	}
+	logDrops(map[string]map[string]uint64{"log": {"debug": 10}}, last, time.Minute, dropLogger)
+	if !strings.Contains(out.String(), `"dropped":3`) {
+		t.Errorf("interval report %q, want the 3 new drops", out.String())
+	}
+}
+
+// Error and above pass even when a global sampler would drop everything.
+func TestSamplerSkipsErrors(t *testing.T) {
+	top := zerolog.PanicLevel
+	o := &Options{EnableSampling: true, SamplerBasicN: 1000, SamplerMaxLevel: &top}
+	var d levelDrops
+	s := buildLevelSampler(o, &d)
+	if s == nil {
+		t.Fatal("no sampler")
+	}
+	for i := 0; i < 10; i++ {
+		s.Sample(zerolog.InfoLevel)
+		for _, l := range []zerolog.Level{zerolog.ErrorLevel, zerolog.FatalLevel, zerolog.PanicLevel} {
+			if !s.Sample(l) {
+				t.Fatalf("%s sampled", l)
+			}
+		}
+	}
+	if n := d[levelIndex(zerolog.InfoLevel)].Load(); n == 0 {
+		t.Error("info not sampled")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package zrlogger
+
+import "context"
+
+// WatchSignals is a no-op without SIGUSR1/SIGUSR2.
+func WatchSignals(ctx context.Context) {}
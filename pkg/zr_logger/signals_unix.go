@@ -0,0 +1,34 @@
+//go:build unix
+
+package zrlogger
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchSignals shifts the singleton's sink levels until ctx ends: SIGUSR1
+// one step more verbose, SIGUSR2 one step quieter.
+func WatchSignals(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-ch:
+				step := -1
+				if sig == syscall.SIGUSR2 {
+					step = 1
+				}
+				l := L()
+				l.Shift(step)
+				l.Log().Str("signal", sig.String()).Interface("levels", l.Levels()).Msg("log levels changed")
+			}
+		}
+	}()
+}
@@ -0,0 +1,360 @@
+package zrlogger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Sink types.
+const (
+	SinkConsole = "console" // stdout
+	SinkFile    = "file"    // rotating file, see the File* and FileRotate* options
+	SinkJSON    = "json"    // plain append-only file
+	SinkSyslog  = "syslog"  // local or remote syslog, JSON payload
+	SinkRing    = "ring"    // last Size events in memory, see Logger.Ring
+	SinkAudit   = "audit"   // hash chained JSON file, only written through Audit
+)
+
+// Sink formats.
+const (
+	FormatJSON    = "json"
+	FormatPipe    = "pipe"    // time | LEVEL | message | k=v ...
+	FormatConsole = "console" // zerolog.ConsoleWriter
+)
+
+// SinkOptions configure one output of the Logger facade.
+type SinkOptions struct {
+	Name          string
+	Type          string
+	Level         *zerolog.Level // nil: the logger level
+	Format        string         // empty: pipe for console, json otherwise
+	Path          string         // file and json sinks
+	Address       string         // syslog: "udp://host:514", "unix:///dev/log"; empty: local
+	Tag           string         // syslog tag, default the service name
+	Size          int            // ring entries, default 1000
+	FieldsInclude []string       // keep only these, plus time, level and message
+	FieldsExclude []string
+	FieldOrder    []string // pipe: fields listed first, the rest alphabetically
+	Color         string   // pipe, console: auto|always|never, default auto
+}
+
+// Logger fans every event out to its sinks; each sink filters by its own
+// level and fields, so callers log once and routing lives in config.
+type Logger struct {
+	zerolog.Logger
+	audit  zerolog.Logger
+	sinks  []*sink
+	global bool // level changes update the zerolog global level
+	drops  *dropTable
+
+	durations DurationFormat
+}
+
+// Dur adds d to e in the logger's duration unit, unlike e.Dur, which
+// follows the zerolog globals.
+func (l *Logger) Dur(e *zerolog.Event, key string, d time.Duration) *zerolog.Event {
+	return l.durations.Dur(e, key, d)
+}
+
+// Audit writes to the audit sinks only, unsampled; a disabled logger when
+// there are none. Log with Log(), levels do not apply.
+func (l *Logger) Audit() zerolog.Logger { return l.audit }
+
+// Ring returns the in-memory buffer of the named ring sink, or nil.
+func (l *Logger) Ring(name string) *Ring {
+	for _, s := range l.sinks {
+		if s.name == name && s.ring != nil {
+			return s.ring
+		}
+	}
+	return nil
+}
+
+// Sinks lists the sink names in config order.
+func (l *Logger) Sinks() []string {
+	names := make([]string, len(l.sinks))
+	for i, s := range l.sinks {
+		names[i] = s.name
+	}
+	return names
+}
+
+// Close flushes and closes file and syslog sinks.
+func (l *Logger) Close() error {
+	var first error
+	for _, s := range l.sinks {
+		if s.closer == nil {
+			continue
+		}
+		s.mu.Lock()
+		if err := s.closer.Close(); err != nil && first == nil {
+			first = err
+		}
+		s.mu.Unlock()
+	}
+	return first
+}
+
+type sink struct {
+	name, kind string
+	level      atomic.Int32 // zerolog.Level
+
+	mu     sync.Mutex
+	w      io.Writer           // formatted output
+	lw     zerolog.LevelWriter // syslog, keeps the level as priority
+	closer io.Closer
+	ring   *Ring
+
+	include, exclude map[string]struct{}
+}
+
+func (s *sink) Write(p []byte) (int, error) {
+	return s.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (s *sink) getLevel() zerolog.Level { return zerolog.Level(s.level.Load()) }
+
+func (s *sink) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	if l < s.getLevel() {
+		return len(p), nil
+	}
+	out := p
+	if s.include != nil || s.exclude != nil {
+		out = filterFields(p, s.include, s.exclude)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.lw != nil {
+		_, err = s.lw.WriteLevel(l, out)
+	} else {
+		_, err = s.w.Write(out)
+	}
+	return len(p), err
+}
+
+// legacySinks maps the Console*/File* options to sinks named "console" and
+// "file", for setups without a sink list.
+func legacySinks(o *Options) []SinkOptions {
+	var out []SinkOptions
+	if o.ConsoleEnable {
+		format := FormatConsole
+		if o.ConsolePipe {
+			format = FormatPipe
+		}
+		out = append(out, SinkOptions{
+			Name:          "console",
+			Type:          SinkConsole,
+			Format:        format,
+			FieldsExclude: o.ConsoleFieldsExclude,
+			FieldOrder:    o.ConsoleFieldOrder,
+			Color:         o.ConsoleColor,
+		})
+	}
+	if o.FileEnable {
+		out = append(out, SinkOptions{Name: "file", Type: SinkFile, Path: o.FilePath})
+	}
+	return out
+}
+
+func buildSink(o *Options, so SinkOptions, level zerolog.Level) (*sink, error) {
+	s := &sink{
+		name:    so.Name,
+		kind:    strings.ToLower(strings.TrimSpace(so.Type)),
+		include: setFromSlice(so.FieldsInclude),
+		exclude: setFromSlice(so.FieldsExclude),
+	}
+	if so.Level != nil {
+		level = clampLevel(*so.Level)
+	}
+	s.level.Store(int32(level))
+	if s.name == "" {
+		s.name = s.kind
+	}
+
+	format := strings.ToLower(strings.TrimSpace(so.Format))
+	var out io.Writer
+	switch s.kind {
+	case SinkConsole:
+		out = os.Stdout
+		if format == "" {
+			format = FormatPipe
+		}
+	case SinkFile:
+		fo := *o
+		if so.Path != "" {
+			fo.FilePath = so.Path
+		}
+		w, err := buildFileWriter(&fo)
+		if err != nil {
+			return nil, err
+		}
+		out = w
+		if c, ok := w.(io.Closer); ok {
+			s.closer = c
+		}
+	case SinkJSON:
+		if so.Path == "" {
+			return nil, fmt.Errorf("sink %q: path required", s.name)
+		}
+		f, err := openAppend(so.Path)
+		if err != nil {
+			return nil, err
+		}
+		out, s.closer = f, f
+	case SinkSyslog:
+		tag := so.Tag
+		if tag == "" {
+			tag = o.Service
+		}
+		lw, c, err := dialSyslog(so.Address, tag)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", s.name, err)
+		}
+		s.lw, s.closer = lw, c
+		return s, nil
+	case SinkRing:
+		s.ring = NewRing(so.Size)
+		out = s.ring
+	case SinkAudit:
+		if so.Path == "" {
+			return nil, fmt.Errorf("sink %q: path required", s.name)
+		}
+		w, err := openAudit(o, so.Path)
+		if err != nil {
+			return nil, fmt.Errorf("sink %q: %w", s.name, err)
+		}
+		s.w, s.closer = w, w
+		return s, nil
+	default:
+		return nil, fmt.Errorf("sink %q: unknown type %q", s.name, so.Type)
+	}
+
+	switch format {
+	case "", FormatJSON:
+		s.w = out
+	case FormatPipe:
+		pw := newPipeWriter(out, so.FieldOrder, useColor(so.Color, out))
+		// the writer filters while it formats, no JSON round trip
+		pw.include, pw.exclude = s.include, s.exclude
+		s.include, s.exclude = nil, nil
+		s.w = pw
+	case FormatConsole:
+		s.w = zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339, NoColor: !useColor(so.Color, out)}
+	default:
+		return nil, fmt.Errorf("sink %q: unknown format %q", s.name, so.Format)
+	}
+	return s, nil
+}
+
+func openAppend(path string) (*os.File, error) {
+	if err := mkdirFor(path); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+}
+
+// filterFields drops excluded keys, or keeps only included ones plus time,
+// level and message, from a JSON event. Invalid JSON passes unchanged.
+func filterFields(p []byte, include, exclude map[string]struct{}) []byte {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(p, &m); err != nil {
+		return p
+	}
+	for k := range m {
+		if _, skip := exclude[k]; skip {
+			delete(m, k)
+			continue
+		}
+		if include == nil {
+			continue
+		}
+		switch k {
+		case zerolog.TimestampFieldName, zerolog.LevelFieldName, zerolog.MessageFieldName:
+			continue
+		}
+		if _, keep := include[k]; !keep {
+			delete(m, k)
+		}
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return p
+	}
+	return append(b, '\n')
+}
+
+// minLevel is the lowest sink level, so the logger builds every event some
+// sink wants.
+func minLevel(sinks []*sink, def zerolog.Level) zerolog.Level {
+	if len(sinks) == 0 {
+		return def
+	}
+	lvl := zerolog.Disabled
+	for _, s := range sinks {
+		if sl := s.getLevel(); sl < lvl {
+			lvl = sl
+		}
+	}
+	return lvl
+}
+
+func levelWriter(sinks []*sink) io.Writer {
+	ws := make([]io.Writer, len(sinks))
+	for i, s := range sinks {
+		ws[i] = s
+	}
+	return zerolog.MultiLevelWriter(ws...)
+}
+
+// Ring keeps the newest events in memory, e.g. for an admin page or a
+// crash dump.
+type Ring struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+// NewRing keeps size events; size <= 0 means 1000.
+func NewRing(size int) *Ring {
+	if size <= 0 {
+		size = 1000
+	}
+	return &Ring{lines: make([][]byte, size)}
+}
+
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = append([]byte(nil), p...)
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	return len(p), nil
+}
+
+// Lines returns the buffered events, oldest first.
+func (r *Ring) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	if r.full {
+		for _, l := range r.lines[r.next:] {
+			out = append(out, strings.TrimRight(string(l), "\n"))
+		}
+	}
+	for _, l := range r.lines[:r.next] {
+		out = append(out, strings.TrimRight(string(l), "\n"))
+	}
+	return out
+}
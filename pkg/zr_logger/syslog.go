@@ -0,0 +1,33 @@
+//go:build !windows && !plan9
+
+package zrlogger
+
+import (
+	"io"
+	"log/syslog"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// dialSyslog connects to addr ("udp://host:514", "tcp://host:601",
+// "unix:///dev/log"); empty means the local daemon.
+func dialSyslog(addr, tag string) (zerolog.LevelWriter, io.Closer, error) {
+	network, raddr := "", ""
+	if addr = strings.TrimSpace(addr); addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		network, raddr = u.Scheme, u.Host
+		if strings.HasPrefix(network, "unix") {
+			raddr = u.Path
+		}
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zerolog.SyslogLevelWriter(w), w, nil
+}
@@ -0,0 +1,15 @@
+//go:build windows || plan9
+
+package zrlogger
+
+import (
+	"errors"
+	"io"
+
+	"github.com/rs/zerolog"
+)
+
+// dialSyslog fails: log/syslog does not exist here.
+func dialSyslog(addr, tag string) (zerolog.LevelWriter, io.Closer, error) {
+	return nil, nil, errors.New("syslog sink not supported on this platform")
+}
@@ -1,12 +1,13 @@
 // Package zrlogger — zerolog with selecting log path
-// - Singleton: Init + Get/Both/File/Console + SetLevel
-// - DI: New + NewTargets
+// - Singleton: Init + L/File/Console + SetLevel/SetSinkLevel
+// - Runtime levels: atomic per sink; WatchSignals, Admin, config reload
+// - DI: New + NewTargets; set no zerolog globals, durations per instance via DurationFormat; env only via Options.Getenv
+// - Sinks: console, rotating file, JSON file, syslog, in-memory ring; L fans out to all
 // - File: JSON + lumberjack rotation
-// - Console: Pipe format  time | LEVEL | message | k=v ...
+// - Console: Pipe format  time | LEVEL | message | k=v ..., stable field order, colors on a TTY
 package zrlogger
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -24,6 +25,7 @@ import (
 
 var (
 	once          sync.Once
+	rootLogger    = &Logger{Logger: zerolog.Nop(), audit: zerolog.Nop()}
 	consoleLogger zerolog.Logger
 	fileLogger    zerolog.Logger
 	initErr       error
@@ -41,6 +43,8 @@ type Options struct {
 	ConsoleEnable        bool
 	ConsolePipe          bool
 	ConsoleFieldsExclude []string
+	ConsoleFieldOrder    []string // pipe fields listed first, the rest alphabetically
+	ConsoleColor         string   // auto|always|never, default auto
 
 	// File (JSON)
 	FileEnable     bool
@@ -50,7 +54,13 @@ type Options struct {
 	FileMaxAgeDays int // default 14
 	FileCompress   bool
 
-	// Time and Duration
+	// Time based rotation, combined with the size cap: the log goes to
+	// <dir>/<stem>-<period>/<stem>-<period>.log
+	FileRotateInterval Interval
+	FileRotateKeep     int // period folders kept, 0 keeps all
+
+	// Time and Duration, per logger. zerolog's own Event.Dur reads its
+	// globals, which only Init sets; Logger.Dur and DurationFormat use these.
 	TimeFieldFormat      string
 	DurationFieldUnit    time.Duration
 	DurationFieldInteger bool
@@ -65,14 +75,29 @@ type Options struct {
 	SamplerBurstPeriod time.Duration
 	SamplerNextEveryN  uint32
 	SamplerBasicN      uint32
+	SamplerMaxLevel    *zerolog.Level // the sampler above skips higher levels; nil: up to warn
 	SamplingLevel      *LevelSamplingOptions
+	// how often dropped event counts are logged, 0: never
+	SamplerReportInterval time.Duration
 
 	// Hooks
 	Hooks []zerolog.Hook
+
+	// Sinks of the L facade; empty: "console" and "file" from the options above
+	Sinks []SinkOptions
+
+	// Process inputs, injectable for deterministic output. Getenv nil reads
+	// the process env in Init and nothing in New/NewTargets.
+	Getenv   func(string) string
+	Now      func() time.Time // nil: time.Now
+	Hostname string           // empty: os.Hostname
+	PID      int              // 0: os.Getpid
 }
 
+// LevelSamplingOptions are per-level samplers. Error and above are never
+// sampled.
 type LevelSamplingOptions struct {
-	Trace, Debug, Info, Warning, Error, Fatal, Panic *SamplerSpec
+	Trace, Debug, Info, Warning *SamplerSpec
 }
 type SamplerSpec struct {
 	Burst      uint32
@@ -83,85 +108,149 @@ type SamplerSpec struct {
 
 // --------- Singleton API ---------
 
+// Init builds the singleton and, unlike New, also sets the zerolog globals
+// (time format, duration unit, error stacks, global level) for other
+// zerolog users in the process.
 func Init(opts *Options) error {
 	once.Do(func() {
-		lConsole, lFile, err := buildAll(opts)
+		o := populateOptions(opts)
+		root, lConsole, lFile, err := buildAll(o, drops)
 		if err != nil {
 			initErr = err
 			return
 		}
-		consoleLogger, fileLogger = lConsole, lFile
+		applyGlobals(o)
+		root.global = o.ApplyToGlobal
+		root.syncGlobal()
+		rootLogger, consoleLogger, fileLogger = root, lConsole, lFile
+		if o.SamplerReportInterval > 0 {
+			go reportDrops(o.SamplerReportInterval)
+		}
 	})
 	return initErr
 }
 
-// Get: logger default based on config
+// L is the facade over every configured sink but audit ones; log through
+// it once.
+func L() *Logger { return rootLogger }
+
+// Audit is L().Audit().
+func Audit() zerolog.Logger { return rootLogger.audit }
+
+// Console and File reach only the console sinks, or only the others.
 func Console() zerolog.Logger { return consoleLogger }
 func File() zerolog.Logger    { return fileLogger }
 
-func SetLevel(l zerolog.Level) {
-	zerolog.SetGlobalLevel(l)
-	consoleLogger = consoleLogger.Level(l)
-	fileLogger = fileLogger.Level(l)
-}
-
 // --------- DI (without singleton) ---------
 
+// New builds a facade that is independent of the singleton. Log durations
+// with its Dur: zerolog's Event.Dur encodes with the process globals, as
+// Stack does with zerolog.ErrorStackMarshaler.
+func New(opts *Options) (*Logger, error) {
+	l, _, _, err := buildAll(populateOptions(isolated(opts)), newDropTable())
+	return l, err
+}
+
 // NewTargets: سه logger جدا (console/file/both) می‌دهد
+// Log durations with DurationFormatOf(opts).Dur.
 func NewTargets(opts *Options) (console zerolog.Logger, file zerolog.Logger, err error) {
-	c, f, err := buildAll(opts)
+	_, c, f, err := buildAll(populateOptions(isolated(opts)), newDropTable())
 	return c, f, err
 }
 
-// --------- Internal ---------
+// isolated copies opts with an empty env unless one is given.
+func isolated(opts *Options) *Options {
+	o := &Options{}
+	if opts != nil {
+		*o = *opts
+	}
+	if o.Getenv == nil {
+		o.Getenv = func(string) string { return "" }
+	}
+	return o
+}
 
-func buildAll(in *Options) (consoleOnly, fileOnly zerolog.Logger, err error) {
-	o := populateOptions(in)
+// DurationFormat encodes durations like zerolog's Event.Dur, with a unit
+// of its own instead of the zerolog globals.
+type DurationFormat struct {
+	Unit    time.Duration // 0: millisecond
+	Integer bool
+}
 
-	// zerolog global configs
-	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
-	if o.TimeFieldFormat != "" {
-		zerolog.TimeFieldFormat = o.TimeFieldFormat
-	} else {
-		zerolog.TimeFieldFormat = time.RFC3339Nano
+// DurationFormatOf is the format New and NewTargets give opts.
+func DurationFormatOf(opts *Options) DurationFormat {
+	return populateOptions(isolated(opts)).durationFormat()
+}
+
+// Dur adds d to e under key.
+func (f DurationFormat) Dur(e *zerolog.Event, key string, d time.Duration) *zerolog.Event {
+	unit := f.Unit
+	if unit <= 0 {
+		unit = time.Millisecond
 	}
-	if o.DurationFieldUnit == 0 {
-		o.DurationFieldUnit = time.Millisecond
+	if f.Integer {
+		return e.Int64(key, int64(d/unit))
 	}
+	return e.Float64(key, float64(d)/float64(unit))
+}
+
+func (o *Options) durationFormat() DurationFormat {
+	return DurationFormat{Unit: o.DurationFieldUnit, Integer: o.DurationFieldInteger}
+}
+
+// --------- Internal ---------
+
+// applyGlobals sets the zerolog globals from o; Init only.
+func applyGlobals(o *Options) {
+	zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	zerolog.TimeFieldFormat = o.TimeFieldFormat
 	zerolog.DurationFieldUnit = o.DurationFieldUnit
 	zerolog.DurationFieldInteger = o.DurationFieldInteger
+}
 
-	// level
+// buildAll builds the loggers from populated options without touching
+// zerolog globals; sampling drops are counted in dt.
+func buildAll(o *Options, dt *dropTable) (all *Logger, consoleOnly, fileOnly zerolog.Logger, err error) {
+	// level: the default of sinks without their own
 	level := resolveLevel(o)
-	if o.ApplyToGlobal {
-		zerolog.SetGlobalLevel(level)
-	}
 
-	// writers
-	var wConsole io.Writer = io.Discard
-	var wFile io.Writer = io.Discard
-
-	if o.ConsoleEnable {
-		pc, cerr := buildConsoleWriter(o)
-		if cerr != nil {
-			return consoleOnly, fileOnly, fmt.Errorf("console writer: %w", cerr)
+	// sinks
+	specs := o.Sinks
+	if len(specs) == 0 {
+		specs = legacySinks(o)
+	}
+	var sinks, logSinks, consoleSinks, otherSinks, auditSinks []*sink
+	for _, spec := range specs {
+		s, serr := buildSink(o, spec, level)
+		if serr != nil {
+			(&Logger{sinks: sinks}).Close()
+			return nil, consoleOnly, fileOnly, fmt.Errorf("sink writer: %w", serr)
 		}
-		wConsole = pc
-	}
-	if o.FileEnable {
-		pf, ferr := buildFileWriter(o)
-		if ferr != nil {
-			return consoleOnly, fileOnly, fmt.Errorf("file writer: %w", ferr)
+		sinks = append(sinks, s)
+		switch s.kind {
+		case SinkAudit:
+			auditSinks = append(auditSinks, s)
+			continue
+		case SinkConsole:
+			consoleSinks = append(consoleSinks, s)
+		default:
+			otherSinks = append(otherSinks, s)
 		}
-		wFile = pf
+		logSinks = append(logSinks, s)
 	}
 
-	// Build contextual base (shared)
-	base := func(w io.Writer) zerolog.Logger {
-		ctx := zerolog.New(zerolog.SyncWriter(w)).Level(level).With().Timestamp()
+	// Build contextual base (shared); sinks lock for themselves
+	base := func(ss []*sink, target string) zerolog.Logger {
+		ctx := zerolog.New(levelWriter(ss)).Level(zerolog.TraceLevel).With()
 
-		hostname, _ := os.Hostname()
-		pid := os.Getpid()
+		hostname := o.Hostname
+		if hostname == "" {
+			hostname, _ = os.Hostname()
+		}
+		pid := o.PID
+		if pid == 0 {
+			pid = os.Getpid()
+		}
 		buildInfo, _ := debug.ReadBuildInfo()
 		var gitRevision, goVersion string
 		if buildInfo != nil {
@@ -173,8 +262,8 @@ func buildAll(in *Options) (consoleOnly, fileOnly zerolog.Logger, err error) {
 				}
 			}
 		} else {
-			goVersion = strings.TrimSpace(os.Getenv("GO_VERSION"))
-			gitRevision = strings.TrimSpace(os.Getenv("GIT_COMMIT"))
+			goVersion = o.getenv("GO_VERSION", "")
+			gitRevision = o.getenv("GIT_COMMIT", "")
 		}
 
 		if o.Service != "" {
@@ -195,16 +284,16 @@ func buildAll(in *Options) (consoleOnly, fileOnly zerolog.Logger, err error) {
 		if goVersion != "" {
 			ctx = ctx.Str("go_version", goVersion)
 		}
-		l := ctx.Logger()
+		l := ctx.Logger().Hook(clock{now: o.Now, format: o.TimeFieldFormat})
 		if o.EnableCaller {
 			l = l.With().Caller().Logger()
 		}
-		// sampling
-		if o.SamplingLevel != nil {
-			l = l.Sample(buildLevelSampler(o.SamplingLevel))
-		} else if o.EnableSampling {
-			l = l.Sample(buildSampler(o))
+		// level gate, then sampling
+		g := &gate{sinks: ss}
+		if ls := buildLevelSampler(o, dt.target(target)); ls != nil {
+			g.next = ls
 		}
+		l = l.Sample(g)
 		// hooks
 		for _, h := range o.Hooks {
 			l = l.Hook(h)
@@ -213,46 +302,27 @@ func buildAll(in *Options) (consoleOnly, fileOnly zerolog.Logger, err error) {
 	}
 
 	// Assemble target loggers
-	consoleOnly = base(wConsole)
-	fileOnly = base(wFile)
+	all = &Logger{Logger: base(logSinks, "log"), sinks: sinks, drops: dt, audit: zerolog.Nop(), durations: o.durationFormat()}
+	if len(auditSinks) > 0 {
+		actx := zerolog.New(levelWriter(auditSinks)).With()
+		if o.Service != "" {
+			actx = actx.Str("service", o.Service)
+		}
+		all.audit = actx.Logger().Hook(clock{now: o.Now, format: o.TimeFieldFormat})
+	}
+	consoleOnly = base(consoleSinks, "console")
+	fileOnly = base(otherSinks, "file")
 
 	return
 }
 
-// Console writer (Pipe format) یا ConsoleWriter معمولی
-func buildConsoleWriter(o *Options) (io.Writer, error) {
-	if o.ConsolePipe {
-		return &pipeConsoleWriter{
-			Out: os.Stdout,
-			// Out:          os.Stderr,
-			TimeKey:      "time",
-			LevelKey:     "level",
-			MessageKey:   "message",
-			TimeLayout:   time.RFC3339,
-			UppercaseLvl: true,
-			Exclude:      setFromSlice(o.ConsoleFieldsExclude),
-		}, nil
-	}
-	//if you do not use pipe, you can use from zerolog default
-	cw := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
-		TimeFormat: time.RFC3339,
-	}
-	if len(o.ConsoleFieldsExclude) > 0 {
-		cw.FieldsExclude = append([]string(nil), o.ConsoleFieldsExclude...)
-	}
-	return cw, nil
-}
-
-// File writer: JSON + lumberjack
+// File writer: JSON + lumberjack, in period folders when rotating by time
 func buildFileWriter(o *Options) (io.Writer, error) {
 	if o.FilePath == "" {
 		o.FilePath = "logs/app.log"
 	}
-	if dir := filepath.Dir(o.FilePath); dir != "" && dir != "." {
-		if err := os.MkdirAll(dir, 0o755); err != nil {
-			return nil, err
-		}
+	if err := mkdirFor(o.FilePath); err != nil {
+		return nil, err
 	}
 	size := o.FileMaxSizeMB
 	if size <= 0 {
@@ -266,6 +336,13 @@ func buildFileWriter(o *Options) (io.Writer, error) {
 	if age < 0 {
 		age = 0
 	}
+	if o.FileRotateInterval != IntervalNone {
+		w := newPeriodWriter(o.FilePath, o.FileRotateInterval, o.FileRotateKeep, size, backups, age, o.FileCompress)
+		if o.Now != nil {
+			w.now = o.Now
+		}
+		return w, nil
+	}
 	lj := &lumberjack.Logger{
 		Filename:   o.FilePath,
 		MaxSize:    size,
@@ -276,71 +353,11 @@ func buildFileWriter(o *Options) (io.Writer, error) {
 	return lj, nil
 }
 
-// Pipe-console writer: JSON event → "time | LEVEL | msg | k=v ..."
-type pipeConsoleWriter struct {
-	Out          io.Writer
-	TimeKey      string
-	LevelKey     string
-	MessageKey   string
-	TimeLayout   string
-	UppercaseLvl bool
-	Exclude      map[string]struct{}
-}
-
-func (w *pipeConsoleWriter) Write(p []byte) (int, error) {
-	var m map[string]any
-	if err := json.Unmarshal(p, &m); err != nil {
-
-		return w.Out.Write(p)
-	}
-	// time
-	ts := ""
-	if v, ok := m[w.TimeKey]; ok {
-		switch t := v.(type) {
-		case string:
-			ts = t
-		case float64:
-			ts = fmt.Sprintf("%.0f", t)
-		default:
-			ts = fmt.Sprint(v)
-		}
-		delete(m, w.TimeKey)
-	}
-	// level
-	lvl := ""
-	if v, ok := m[w.LevelKey]; ok {
-		lvl = fmt.Sprint(v)
-		if w.UppercaseLvl {
-			lvl = strings.ToUpper(lvl)
-		}
-		delete(m, w.LevelKey)
+func mkdirFor(path string) error {
+	if dir := filepath.Dir(path); dir != "" && dir != "." {
+		return os.MkdirAll(dir, 0o755)
 	}
-	// message
-	msg := ""
-	if v, ok := m[w.MessageKey]; ok {
-		msg = fmt.Sprint(v)
-		delete(m, w.MessageKey)
-	}
-
-	parts := make([]string, 0, len(m)+3)
-	if ts != "" {
-		parts = append(parts, ts)
-	}
-	if lvl != "" {
-		parts = append(parts, lvl)
-	}
-	if msg != "" {
-		parts = append(parts, msg)
-	}
-
-	for k, v := range m {
-		if _, skip := w.Exclude[k]; skip {
-			continue
-		}
-		parts = append(parts, fmt.Sprintf("%s=%v", k, v))
-	}
-	line := strings.Join(parts, " | ") + "\n"
-	return w.Out.Write([]byte(line))
+	return nil
 }
 
 func setFromSlice(ss []string) map[string]struct{} {
@@ -359,6 +376,29 @@ func setFromSlice(ss []string) map[string]struct{} {
 
 // --------- helpers---------
 
+// clock stamps events from an injectable clock in the logger's own time
+// format, instead of zerolog's global TimestampFunc and TimeFieldFormat.
+type clock struct {
+	now    func() time.Time
+	format string
+}
+
+func (c clock) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	t := c.now()
+	switch c.format {
+	case zerolog.TimeFormatUnix:
+		e.Int64(zerolog.TimestampFieldName, t.Unix())
+	case zerolog.TimeFormatUnixMs:
+		e.Int64(zerolog.TimestampFieldName, t.UnixMilli())
+	case zerolog.TimeFormatUnixMicro:
+		e.Int64(zerolog.TimestampFieldName, t.UnixMicro())
+	case zerolog.TimeFormatUnixNano:
+		e.Int64(zerolog.TimestampFieldName, t.UnixNano())
+	default:
+		e.Str(zerolog.TimestampFieldName, t.Format(c.format))
+	}
+}
+
 func buildSampler(o *Options) zerolog.Sampler {
 	if o.SamplerBurst > 0 && o.SamplerBurstPeriod > 0 {
 		var next zerolog.Sampler
@@ -374,18 +414,39 @@ func buildSampler(o *Options) zerolog.Sampler {
 	if o.SamplerBasicN > 0 {
 		return &zerolog.BasicSampler{N: o.SamplerBasicN}
 	}
-	return allowAllSampler{}
+	return nil
 }
 
-func buildLevelSampler(ls *LevelSamplingOptions) zerolog.LevelSampler {
-	var s zerolog.LevelSampler
-	s.TraceSampler = samplerSpec(ls.Trace)
-	s.DebugSampler = samplerSpec(ls.Debug)
-	s.InfoSampler = samplerSpec(ls.Info)
-	s.WarnSampler = samplerSpec(ls.Warning)
-	s.ErrorSampler = samplerSpec(ls.Error)
-	// s.FatalSampler = samplerSpec(ls.Fatal)
-	// s.PanicSampler = samplerSpec(ls.Panic)
+// buildLevelSampler puts the global sampler on every level up to
+// SamplerMaxLevel, below error, then the per-level samplers on top. Drops
+// are counted in dropped. nil when nothing is sampled.
+func buildLevelSampler(o *Options, dropped *levelDrops) zerolog.Sampler {
+	s := &levelSampler{dropped: dropped}
+	sampled := false
+	if o.EnableSampling {
+		if global := buildSampler(o); global != nil {
+			for lvl := zerolog.TraceLevel; lvl <= zerolog.PanicLevel; lvl++ {
+				if o.SamplerMaxLevel == nil || lvl <= *o.SamplerMaxLevel {
+					sampled = s.set(lvl, global) || sampled
+				}
+			}
+		}
+	}
+	if ls := o.SamplingLevel; ls != nil {
+		for lvl, spec := range map[zerolog.Level]*SamplerSpec{
+			zerolog.TraceLevel: ls.Trace,
+			zerolog.DebugLevel: ls.Debug,
+			zerolog.InfoLevel:  ls.Info,
+			zerolog.WarnLevel:  ls.Warning,
+		} {
+			if sm := samplerSpec(spec); sm != nil {
+				sampled = s.set(lvl, sm) || sampled
+			}
+		}
+	}
+	if !sampled {
+		return nil
+	}
 	return s
 }
 
@@ -416,7 +477,7 @@ func resolveLevel(o *Options) zerolog.Level {
 	if o.Level != nil {
 		return clampLevel(*o.Level)
 	}
-	if s := strings.TrimSpace(os.Getenv("LOG_LEVEL")); s != "" {
+	if s := o.getenv("LOG_LEVEL", ""); s != "" {
 		if lvl, ok := tryParseLevel(s); ok {
 			return clampLevel(lvl)
 		}
@@ -475,10 +536,10 @@ func populateOptions(in *Options) *Options {
 
 	// default‌s
 	if o.Env == "" {
-		o.Env = getenv("APP_ENV", "development")
+		o.Env = o.getenv("APP_ENV", "development")
 	}
 	if o.FilePath == "" {
-		o.FilePath = getenv("LOG_FILE_PATH", "logs/app.log")
+		o.FilePath = o.getenv("LOG_FILE_PATH", "logs/app.log")
 	}
 	if !o.ConsoleEnable && !o.FileEnable {
 		if strings.ToLower(o.Env) == "development" {
@@ -490,21 +551,24 @@ func populateOptions(in *Options) *Options {
 	}
 	if o.FileEnable {
 		if o.FileMaxSizeMB == 0 {
-			o.FileMaxSizeMB = atoi(getenv("LOG_FILE_MAX_MB", "50"))
+			o.FileMaxSizeMB = atoi(o.getenv("LOG_FILE_MAX_MB", "50"))
 		}
 		if o.FileMaxBackups == 0 {
-			o.FileMaxBackups = atoi(getenv("LOG_FILE_BACKUPS", "10"))
+			o.FileMaxBackups = atoi(o.getenv("LOG_FILE_BACKUPS", "10"))
 		}
 		if o.FileMaxAgeDays == 0 {
-			o.FileMaxAgeDays = atoi(getenv("LOG_FILE_MAX_AGE_DAYS", "14"))
+			o.FileMaxAgeDays = atoi(o.getenv("LOG_FILE_MAX_AGE_DAYS", "14"))
+		}
+		o.FileCompress = o.FileCompress || strings.EqualFold(o.getenv("LOG_FILE_COMPRESS", "true"), "true")
+		if o.FileRotateInterval == IntervalNone {
+			o.FileRotateInterval, _ = ParseInterval(o.getenv("LOG_FILE_ROTATE_INTERVAL", ""))
 		}
-		o.FileCompress = o.FileCompress || strings.EqualFold(getenv("LOG_FILE_COMPRESS", "true"), "true")
 	}
 	if o.TimeFieldFormat == "" {
-		o.TimeFieldFormat = getenv("LOG_TIME_FORMAT", time.RFC3339Nano)
+		o.TimeFieldFormat = o.getenv("LOG_TIME_FORMAT", time.RFC3339Nano)
 	}
 	if o.DurationFieldUnit == 0 {
-		switch strings.ToLower(getenv("LOG_DURATION_UNIT", "ms")) {
+		switch strings.ToLower(o.getenv("LOG_DURATION_UNIT", "ms")) {
 		case "ns":
 			o.DurationFieldUnit = time.Nanosecond
 		case "us", "µs":
@@ -518,23 +582,30 @@ func populateOptions(in *Options) *Options {
 		}
 	}
 	if !o.EnableCaller {
-		o.EnableCaller = strings.EqualFold(getenv("LOG_CALLER", "true"), "true")
+		o.EnableCaller = strings.EqualFold(o.getenv("LOG_CALLER", "true"), "true")
 	}
 	if !o.EnablePIDHost {
-		o.EnablePIDHost = strings.EqualFold(getenv("LOG_PID_HOST", "true"), "true")
+		o.EnablePIDHost = strings.EqualFold(o.getenv("LOG_PID_HOST", "true"), "true")
 	}
 	if !o.ApplyToGlobal {
-		o.ApplyToGlobal = strings.EqualFold(getenv("LOG_APPLY_TO_GLOBAL", "true"), "true")
+		o.ApplyToGlobal = strings.EqualFold(o.getenv("LOG_APPLY_TO_GLOBAL", "true"), "true")
 	}
 
 	if !o.ConsolePipe && strings.ToLower(o.Env) == "development" {
 		o.ConsolePipe = true
 	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
 	return o
 }
 
-func getenv(k, def string) string {
-	if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+func (o *Options) getenv(k, def string) string {
+	get := o.Getenv
+	if get == nil {
+		get = os.Getenv
+	}
+	if v := strings.TrimSpace(get(k)); v != "" {
 		return v
 	}
 	return def
@@ -543,8 +614,3 @@ func atoi(s string) int {
 	n, _ := strconv.Atoi(s)
 	return n
 }
-
-// allowAllSampler
-type allowAllSampler struct{}
-
-func (allowAllSampler) Sample(zerolog.Level) bool { return true }
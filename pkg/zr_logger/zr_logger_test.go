@@ -0,0 +1,111 @@
+package zrlogger
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// newRing builds a DI logger writing JSON to a ring sink and returns it
+// with a func that decodes the last event.
+func newRing(t *testing.T, opts Options) (*Logger, func() map[string]any) {
+	t.Helper()
+	opts.Sinks = []SinkOptions{{Name: "mem", Type: SinkRing}}
+	l, err := New(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, func() map[string]any {
+		t.Helper()
+		lines := l.Ring("mem").Lines()
+		if len(lines) == 0 {
+			t.Fatal("nothing logged")
+		}
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(lines[len(lines)-1]), &ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+}
+
+func TestNewInjectedInputs(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	env := map[string]string{"APP_ENV": "staging", "LOG_LEVEL": "warn"}
+	l, last := newRing(t, Options{
+		Service:       "svc",
+		Getenv:        func(k string) string { return env[k] },
+		Now:           func() time.Time { return at },
+		Hostname:      "box1",
+		PID:           42,
+		EnablePIDHost: true,
+	})
+
+	l.Info().Msg("hidden")
+	if n := len(l.Ring("mem").Lines()); n != 0 {
+		t.Fatalf("LOG_LEVEL=warn from Getenv not applied, %d lines", n)
+	}
+	l.Warn().Msg("shown")
+	ev := last()
+	want := map[string]any{
+		"time":    at.Format(time.RFC3339Nano),
+		"env":     "staging",
+		"service": "svc",
+		"host":    "box1",
+		"pid":     float64(42),
+		"message": "shown",
+	}
+	for k, v := range want {
+		if ev[k] != v {
+			t.Errorf("%s = %v, want %v", k, ev[k], v)
+		}
+	}
+}
+
+// Without Getenv the process env is not read.
+func TestNewIgnoresProcessEnv(t *testing.T) {
+	t.Setenv("APP_ENV", "from-process")
+	t.Setenv("LOG_LEVEL", "error")
+	l, last := newRing(t, Options{})
+	l.Info().Msg("kept")
+	if ev := last(); ev["env"] != "development" {
+		t.Errorf("env %v, want the default", ev["env"])
+	}
+}
+
+// Two loggers keep their own duration units and leave the globals alone.
+func TestNewDurations(t *testing.T) {
+	unit, integer := zerolog.DurationFieldUnit, zerolog.DurationFieldInteger
+
+	ms, lastMs := newRing(t, Options{})
+	sec, lastSec := newRing(t, Options{DurationFieldUnit: time.Second})
+	us, lastUs := newRing(t, Options{DurationFieldUnit: time.Microsecond, DurationFieldInteger: true})
+
+	d := 1500 * time.Millisecond
+	ms.Dur(ms.Info(), "d", d).Msg("")
+	sec.Dur(sec.Info(), "d", d).Msg("")
+	us.Dur(us.Info(), "d", d).Msg("")
+	for name, tc := range map[string]struct {
+		last func() map[string]any
+		want float64
+	}{
+		"ms": {lastMs, 1500},
+		"s":  {lastSec, 1.5},
+		"us": {lastUs, 1500000},
+	} {
+		if got := tc.last()["d"]; got != tc.want {
+			t.Errorf("%s: %v, want %v", name, got, tc.want)
+		}
+	}
+
+	f := DurationFormatOf(&Options{DurationFieldUnit: time.Second, DurationFieldInteger: true})
+	if f != (DurationFormat{Unit: time.Second, Integer: true}) {
+		t.Errorf("DurationFormatOf: %+v", f)
+	}
+	if zerolog.DurationFieldUnit != unit || zerolog.DurationFieldInteger != integer {
+		t.Error("New changed the zerolog duration globals")
+	}
+}
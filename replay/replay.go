@@ -0,0 +1,322 @@
+// Package replay resends captured ISO messages to a TCP endpoint, keeping
+// their original spacing or scaling it, and compares every response with
+// the one captured for the same request.
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/framing"
+	"github.com/msn60/isotcpdump/parser"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+type Outcome string
+
+const (
+	OutcomeSame       Outcome = "same"        // response matches the captured one
+	OutcomeDiffer     Outcome = "differ"      // see Exchange.Diffs
+	OutcomeNew        Outcome = "new"         // answered, the capture had no response
+	OutcomeNoResponse Outcome = "no_response" // nothing back within the response timeout
+)
+
+// Options for a replay.
+type Options struct {
+	Target          string           // host:port
+	Speed           float64          // 1 keeps capture timing, 2 is twice as fast; 0 sends back to back
+	Header          framing.Header   // length header on the wire
+	Direction       stream.Direction // captured messages to send; the others are the expected responses
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration     // wait for responses after the last send
+	IgnoreFields    []int             // not compared; nil: DefaultIgnoreFields, empty: none
+	Mask            []parser.MaskRule // applied to values in Diffs
+}
+
+func OptionsFromConfig(cfg *config.Config) Options {
+	rc := cfg.Replay
+	h, _ := framing.Parse(rc.Framing)
+	o := Options{
+		Target:          strings.TrimSpace(rc.Target),
+		Speed:           rc.Speed,
+		Header:          h,
+		Direction:       stream.Direction(strings.ToLower(strings.TrimSpace(rc.Direction))),
+		DialTimeout:     parseDuration(rc.DialTimeout, 5*time.Second),
+		ResponseTimeout: parseDuration(rc.ResponseTimeout, 10*time.Second),
+		IgnoreFields:    rc.IgnoreFields,
+		Mask:            parser.MaskRulesFromConfig(cfg),
+	}
+	return o
+}
+
+// DefaultIgnoreFields are the clock fields: transmission time (7) and local
+// time and date (12, 13).
+var DefaultIgnoreFields = []int{7, 12, 13}
+
+func (o Options) withDefaults() Options {
+	if o.Header == "" {
+		o.Header = framing.Default
+	}
+	if o.Direction == "" {
+		o.Direction = stream.DirectionInput
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.ResponseTimeout <= 0 {
+		o.ResponseTimeout = 10 * time.Second
+	}
+	if o.IgnoreFields == nil {
+		o.IgnoreFields = DefaultIgnoreFields
+	}
+	if o.Speed < 0 {
+		o.Speed = 0
+	}
+	return o
+}
+
+// Diff is one field that differs; field 0 is the MTI.
+type Diff struct {
+	Field    int    `json:"field"`
+	Original string `json:"original"`
+	Replayed string `json:"replayed"`
+}
+
+// Exchange is one replayed request and what came back.
+type Exchange struct {
+	Key      string          `json:"key"`
+	Sent     time.Time       `json:"sent"`
+	Latency  time.Duration   `json:"latency"`
+	Request  *parser.Message `json:"-"`
+	Original *parser.Message `json:"-"` // captured response, nil if none
+	Replayed *parser.Message `json:"-"`
+	Outcome  Outcome         `json:"outcome"`
+	Diffs    []Diff          `json:"diffs,omitempty"`
+}
+
+type Stats struct {
+	Sent       int `json:"sent"`
+	Received   int `json:"received"`
+	Same       int `json:"same"`
+	Differ     int `json:"differ"`
+	New        int `json:"new"`
+	NoResponse int `json:"no_response"`
+	Unexpected int `json:"unexpected"` // responses to nothing we sent
+	Skipped    int `json:"skipped"`    // duplicates and unparsable messages
+}
+
+type Result struct {
+	Stats
+	Exchanges []Exchange
+}
+
+// Run sends the opts.Direction messages of recs in capture order and waits
+// for their responses. It stops early, with what it has, when ctx ends.
+func Run(ctx context.Context, opts Options, recs []stream.Record) (*Result, error) {
+	opts = opts.withDefaults()
+	res := &Result{}
+
+	var send []stream.Record
+	originals := make(map[string][]*parser.Message) // key -> captured responses, in order
+	for _, rec := range recs {
+		switch {
+		case rec.Duplicate != "" || rec.Msg == nil || len(rec.Raw) == 0:
+			if rec.Direction == opts.Direction {
+				res.Skipped++
+			}
+		case rec.Direction == opts.Direction:
+			send = append(send, rec)
+		case parser.IsResponse(rec.Msg.MTI):
+			key := rec.Msg.MatchKey()
+			originals[key] = append(originals[key], rec.Msg)
+		}
+	}
+	sort.SliceStable(send, func(i, j int) bool { return send[i].Time.Before(send[j].Time) })
+
+	d := net.Dialer{Timeout: opts.DialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", opts.Target)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	defer conn.Close()
+
+	// requests sharing a key are answered first in, first out
+	var (
+		mu      sync.Mutex
+		pending = make(map[string][]int) // key -> indexes in res.Exchanges
+		open    int                      // exchanges still in pending
+		done    = make(chan struct{})
+		waiting = make(chan struct{}, 1) // poked whenever pending drains
+	)
+	go func() {
+		defer close(done)
+		for {
+			raw, err := opts.Header.Read(conn)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			msg, _ := parser.Parse(raw)
+			mu.Lock()
+			res.Received++
+			var q []int
+			if msg != nil {
+				q = pending[msg.MatchKey()]
+			}
+			if len(q) == 0 {
+				res.Unexpected++
+			} else {
+				if len(q) == 1 {
+					delete(pending, msg.MatchKey())
+				} else {
+					pending[msg.MatchKey()] = q[1:]
+				}
+				open--
+				ex := &res.Exchanges[q[0]]
+				ex.Replayed, ex.Latency = msg, now.Sub(ex.Sent)
+				ex.Outcome, ex.Diffs = compare(ex.Original, msg, opts)
+			}
+			empty := open == 0
+			mu.Unlock()
+			if empty {
+				select {
+				case waiting <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	start := time.Now()
+	var sendErr error
+sending:
+	for _, rec := range send {
+		if opts.Speed > 0 {
+			at := start.Add(time.Duration(float64(rec.Time.Sub(send[0].Time)) / opts.Speed))
+			select {
+			case <-time.After(time.Until(at)):
+			case <-ctx.Done():
+				break sending
+			}
+		}
+		frame, err := opts.Header.Frame(rec.Raw)
+		if err != nil {
+			res.Skipped++
+			continue
+		}
+		key := rec.Msg.MatchKey()
+		var original *parser.Message
+		if q := originals[key]; len(q) > 0 {
+			original, originals[key] = q[0], q[1:]
+		}
+		mu.Lock()
+		res.Exchanges = append(res.Exchanges, Exchange{
+			Key:      key,
+			Sent:     time.Now(),
+			Request:  rec.Msg,
+			Original: original,
+			Outcome:  OutcomeNoResponse,
+		})
+		pending[key] = append(pending[key], len(res.Exchanges)-1)
+		open++
+		mu.Unlock()
+		if _, err := conn.Write(frame); err != nil {
+			sendErr = fmt.Errorf("replay: %w", err)
+			break
+		}
+		res.Sent++
+	}
+
+	timeout := time.NewTimer(opts.ResponseTimeout)
+	defer timeout.Stop()
+waitResponses:
+	for sendErr == nil {
+		mu.Lock()
+		left := open
+		mu.Unlock()
+		if left == 0 {
+			break
+		}
+		select {
+		case <-waiting: // may be stale from while sending, check again
+		case <-done:
+			break waitResponses
+		case <-timeout.C:
+			break waitResponses
+		case <-ctx.Done():
+			break waitResponses
+		}
+	}
+	conn.Close()
+	<-done
+
+	for _, ex := range res.Exchanges {
+		switch ex.Outcome {
+		case OutcomeSame:
+			res.Same++
+		case OutcomeDiffer:
+			res.Differ++
+		case OutcomeNew:
+			res.New++
+		case OutcomeNoResponse:
+			res.NoResponse++
+		}
+	}
+	if sendErr != nil && !errors.Is(ctx.Err(), context.Canceled) {
+		return res, sendErr
+	}
+	return res, nil
+}
+
+// compare lists the fields where replayed differs from original.
+func compare(original, replayed *parser.Message, opts Options) (Outcome, []Diff) {
+	if original == nil {
+		return OutcomeNew, nil
+	}
+	ignore := make(map[int]bool, len(opts.IgnoreFields))
+	for _, f := range opts.IgnoreFields {
+		ignore[f] = true
+	}
+	var diffs []Diff
+	if original.MTI != replayed.MTI {
+		diffs = append(diffs, Diff{Field: 0, Original: original.MTI, Replayed: replayed.MTI})
+	}
+	fields := make(map[int]bool)
+	for f := range original.Fields {
+		fields[f] = true
+	}
+	for f := range replayed.Fields {
+		fields[f] = true
+	}
+	nums := make([]int, 0, len(fields))
+	for f := range fields {
+		if !ignore[f] {
+			nums = append(nums, f)
+		}
+	}
+	sort.Ints(nums)
+	a, b := original.Masked(opts.Mask), replayed.Masked(opts.Mask)
+	for _, f := range nums {
+		if original.Fields[f] != replayed.Fields[f] {
+			diffs = append(diffs, Diff{Field: f, Original: a[f], Replayed: b[f]})
+		}
+	}
+	if len(diffs) > 0 {
+		return OutcomeDiffer, diffs
+	}
+	return OutcomeSame, nil
+}
+
+func parseDuration(s string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(s)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
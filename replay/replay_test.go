@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/msn60/isotcpdump/parser"
+	"github.com/msn60/isotcpdump/simulate"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+// startHost answers after 100ms, so requests sent back to back are all
+// pending before the first response.
+func startHost(t *testing.T, rules []simulate.Rule) string {
+	t.Helper()
+	delay := simulate.Delay{Dist: simulate.DistFixed, Min: 100 * time.Millisecond}
+	h := simulate.New(simulate.Options{Seed: 1, Delay: delay, Rules: rules})
+	if err := h.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Shutdown(context.Background()) })
+	return h.Addr()
+}
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// exchange is a captured request and, with code set, its response.
+func exchange(t *testing.T, at time.Duration, mti, proc, stan, code string) []stream.Record {
+	t.Helper()
+	req := &parser.Message{MTI: mti, Fields: map[int]string{
+		3: proc, 4: "000000010000", 11: stan, 37: "240101000001", 41: "TRM00001",
+	}}
+	raw, err := parser.Pack(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := []stream.Record{{Time: t0.Add(at), Direction: stream.DirectionInput, Msg: req, Raw: raw}}
+	if code == "" {
+		return recs
+	}
+	resp := &parser.Message{MTI: parser.ResponseMTI(mti), Fields: map[int]string{39: code}}
+	for f, v := range req.Fields {
+		resp.Fields[f] = v
+	}
+	raw, err = parser.Pack(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(recs, stream.Record{Time: t0.Add(at + 10*time.Millisecond), Direction: stream.DirectionOutput, Msg: resp, Raw: raw})
+}
+
+func TestRun(t *testing.T) {
+	addr := startHost(t, []simulate.Rule{
+		{MTI: "0200", Match: map[int]string{3: "31*"}, Codes: []simulate.Code{{Code: "51", Weight: 1}}},
+		{MTI: "0100", Drop: 1},
+	})
+	var recs []stream.Record
+	recs = append(recs, exchange(t, 0, "0200", "000000", "000001", "00")...) // same
+	recs = append(recs, exchange(t, 1, "0200", "310000", "000002", "00")...) // differ: 51
+	recs = append(recs, exchange(t, 2, "0200", "000000", "000003", "")...)   // new
+	recs = append(recs, exchange(t, 3, "0100", "000000", "000004", "00")...) // dropped
+	recs = append(recs, exchange(t, 4, "0200", "000000", "000001", "00")...) // same key as the first, both pending
+
+	res, err := Run(context.Background(), Options{Target: addr, ResponseTimeout: 500 * time.Millisecond}, recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{Sent: 5, Received: 4, Same: 2, Differ: 1, New: 1, NoResponse: 1}
+	if res.Stats != want {
+		t.Errorf("stats %+v, want %+v", res.Stats, want)
+	}
+	wantOutcomes := []Outcome{OutcomeSame, OutcomeDiffer, OutcomeNew, OutcomeNoResponse, OutcomeSame}
+	for i, ex := range res.Exchanges {
+		if i < len(wantOutcomes) && ex.Outcome != wantOutcomes[i] {
+			t.Errorf("exchange %d (%s): %s, want %s", i, ex.Key, ex.Outcome, wantOutcomes[i])
+		}
+	}
+	if d := res.Exchanges[1].Diffs; len(d) != 1 || d[0].Field != 39 || d[0].Original != "00" || d[0].Replayed != "51" {
+		t.Errorf("differ diffs %+v, want field 39 00 -> 51", d)
+	}
+}
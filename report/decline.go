@@ -0,0 +1,241 @@
+// Package report builds analysis reports on top of the aggregator snapshot.
+package report
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/constants"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+// Counts of transaction outcomes.
+type Counts struct {
+	Total    int `json:"total"`
+	Approved int `json:"approved"`
+	Declined int `json:"declined"`
+	Timeout  int `json:"timeout"`
+}
+
+func (c *Counts) add(outcome string) {
+	c.Total++
+	switch outcome {
+	case constants.OutcomeApproved:
+		c.Approved++
+	case constants.OutcomeTimeout:
+		c.Timeout++
+	default:
+		c.Declined++
+	}
+}
+
+// ApprovalRate is approved / total, 0 when nothing was seen.
+func (c Counts) ApprovalRate() float64 {
+	if c.Total == 0 {
+		return 0
+	}
+	return float64(c.Approved) / float64(c.Total)
+}
+
+// Group is the outcome breakdown for one value of a dimension.
+type Group struct {
+	Key string `json:"key"`
+	Counts
+}
+
+// CodeCount is how often a DE39 value was seen.
+type CodeCount struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Outcome     string `json:"outcome"`
+	Count       int    `json:"count"`
+}
+
+// DeclineReport breaks down approvals, declines and timeouts.
+type DeclineReport struct {
+	Bucket     time.Duration `json:"bucket"`
+	Totals     Counts        `json:"totals"`
+	Codes      []CodeCount   `json:"codes"`
+	ByBucket   []Group       `json:"by_bucket"`
+	ByServer   []Group       `json:"by_server"`
+	ByMerchant []Group       `json:"by_merchant"` // DE42
+	ByTerminal []Group       `json:"by_terminal"` // DE41
+	ByAcquirer []Group       `json:"by_acquirer"` // DE32
+	ByPath     []Group       `json:"by_path"`     // crossnetwork path
+}
+
+// Options for building a DeclineReport.
+type Options struct {
+	Bucket  time.Duration
+	Codes   map[string]constants.ResponseCode
+	Servers []config.Server
+	Matcher matcher.Options
+}
+
+// OptionsFromConfig merges the configured DE39 table over the defaults.
+func OptionsFromConfig(cfg *config.Config) Options {
+	bucket := time.Minute
+	if d, err := time.ParseDuration(strings.TrimSpace(cfg.Report.Bucket)); err == nil && d > 0 {
+		bucket = d
+	}
+	codes := make(map[string]constants.ResponseCode, len(constants.ResponseCodes)+len(cfg.Report.ResponseCodes))
+	for k, v := range constants.ResponseCodes {
+		codes[k] = v
+	}
+	for _, rc := range cfg.Report.ResponseCodes {
+		outcome := strings.ToLower(strings.TrimSpace(rc.Outcome))
+		if outcome == "" {
+			outcome = constants.OutcomeDeclined
+		}
+		codes[rc.Code] = constants.ResponseCode{Description: rc.Description, Outcome: outcome}
+	}
+	return Options{
+		Bucket:  bucket,
+		Codes:   codes,
+		Servers: cfg.Server,
+		Matcher: matcher.OptionsFromConfig(cfg),
+	}
+}
+
+// transaction is a request and/or its response.
+type transaction struct {
+	req, resp *stream.Record
+}
+
+func (t *transaction) first() *stream.Record {
+	if t.req != nil {
+		return t.req
+	}
+	return t.resp
+}
+
+// field prefers the response value and falls back to the request.
+func (t *transaction) field(n int) string {
+	if t.resp != nil && t.resp.Msg.Has(n) {
+		return t.resp.Msg.Field(n)
+	}
+	if t.req != nil {
+		return t.req.Msg.Field(n)
+	}
+	return ""
+}
+
+// BuildDecline pairs requests with responses through the matcher and
+// tallies the outcomes. Requests left without a response count as timeouts;
+// late responses are not counted again.
+func BuildDecline(snap *stream.IsoStreamResponse, opts Options) *DeclineReport {
+	if opts.Bucket <= 0 {
+		opts.Bucket = time.Minute
+	}
+	if opts.Codes == nil {
+		opts.Codes = constants.ResponseCodes
+	}
+
+	records := make([]stream.Record, len(snap.Records))
+	copy(records, snap.Records)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	var txs []*transaction
+	opts.Matcher.MaxEvents = 0 // consumed through OnEvent
+	m := matcher.New(opts.Matcher).OnEvent(func(ev matcher.Event) {
+		t := &transaction{req: ev.Request, resp: ev.Response}
+		if ev.Kind == matcher.KindLate || isNetworkManagement(t.first().Msg.MTI) {
+			return
+		}
+		txs = append(txs, t)
+	})
+	for _, rec := range records {
+		m.Observe(rec)
+	}
+	m.Flush()
+
+	rep := &DeclineReport{Bucket: opts.Bucket}
+	codes := make(map[string]*CodeCount)
+	buckets := make(map[string]*Group)
+	servers := make(map[string]*Group)
+	merchants := make(map[string]*Group)
+	terminals := make(map[string]*Group)
+	acquirers := make(map[string]*Group)
+	paths := make(map[string]*Group)
+
+	for _, t := range txs {
+		code, desc, outcome := "", "no response", constants.OutcomeTimeout
+		if t.resp != nil {
+			code = t.resp.Msg.Field(39)
+			if rc, ok := opts.Codes[code]; ok {
+				desc, outcome = rc.Description, rc.Outcome
+			} else {
+				desc, outcome = "unknown", constants.OutcomeDeclined
+			}
+		}
+
+		cc, ok := codes[code]
+		if !ok {
+			cc = &CodeCount{Code: code, Description: desc, Outcome: outcome}
+			codes[code] = cc
+		}
+		cc.Count++
+
+		rep.Totals.add(outcome)
+		first := t.first()
+		tally(buckets, first.Time.Truncate(opts.Bucket).Format(time.RFC3339), outcome)
+		tally(servers, stream.ServerName(opts.Servers, first), outcome)
+		tally(merchants, strings.TrimSpace(t.field(42)), outcome)
+		tally(terminals, strings.TrimSpace(t.field(41)), outcome)
+		tally(acquirers, strings.TrimSpace(t.field(32)), outcome)
+		tally(paths, first.Path, outcome)
+	}
+
+	for _, cc := range codes {
+		rep.Codes = append(rep.Codes, *cc)
+	}
+	sort.Slice(rep.Codes, func(i, j int) bool {
+		if rep.Codes[i].Count != rep.Codes[j].Count {
+			return rep.Codes[i].Count > rep.Codes[j].Count
+		}
+		return rep.Codes[i].Code < rep.Codes[j].Code
+	})
+	rep.ByBucket = sortedGroups(buckets, false)
+	rep.ByServer = sortedGroups(servers, true)
+	rep.ByMerchant = sortedGroups(merchants, true)
+	rep.ByTerminal = sortedGroups(terminals, true)
+	rep.ByAcquirer = sortedGroups(acquirers, true)
+	rep.ByPath = sortedGroups(paths, true)
+	return rep
+}
+
+// ---- helpers ----
+
+func isNetworkManagement(mti string) bool {
+	return len(mti) == 4 && mti[1] == '8'
+}
+
+func tally(m map[string]*Group, key, outcome string) {
+	if key == "" {
+		key = "-"
+	}
+	g, ok := m[key]
+	if !ok {
+		g = &Group{Key: key}
+		m[key] = g
+	}
+	g.add(outcome)
+}
+
+// sortedGroups orders by key, or by volume when byTotal is set.
+func sortedGroups(m map[string]*Group, byTotal bool) []Group {
+	out := make([]Group, 0, len(m))
+	for _, g := range m {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if byTotal && out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Write renders rep as "text", "csv" or "json".
+func (rep *DeclineReport) Write(w io.Writer, format string) error {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", "text":
+		return rep.WriteText(w)
+	case "csv":
+		return rep.WriteCSV(w)
+	case "json":
+		return rep.WriteJSON(w)
+	default:
+		return fmt.Errorf("report: unknown format %q", format)
+	}
+}
+
+func (rep *DeclineReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
+
+// WriteCSV writes one row per dimension value; response codes use the
+// "code" dimension with the count in the total column.
+func (rep *DeclineReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"dimension", "key", "description", "total", "approved", "declined", "timeout", "approval_rate"})
+
+	_ = cw.Write(groupRow("total", Group{Key: "all", Counts: rep.Totals}))
+	for _, c := range rep.Codes {
+		_ = cw.Write([]string{"code", c.Code, c.Description, strconv.Itoa(c.Count), "", "", "", ""})
+	}
+	for _, d := range rep.dimensions() {
+		for _, g := range d.groups {
+			_ = cw.Write(groupRow(d.name, g))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func (rep *DeclineReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	t := rep.Totals
+	fmt.Fprintf(tw, "Transactions: %d\tapproved: %d\tdeclined: %d\ttimeout: %d\tapproval rate: %s\n",
+		t.Total, t.Approved, t.Declined, t.Timeout, percent(t.ApprovalRate()))
+
+	fmt.Fprintln(tw, "\nResponse codes")
+	fmt.Fprintln(tw, "CODE\tDESCRIPTION\tOUTCOME\tCOUNT")
+	for _, c := range rep.Codes {
+		code := c.Code
+		if code == "" {
+			code = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", code, c.Description, c.Outcome, c.Count)
+	}
+
+	for _, d := range rep.dimensions() {
+		fmt.Fprintf(tw, "\nBy %s\n", d.name)
+		fmt.Fprintln(tw, "KEY\tTOTAL\tAPPROVED\tDECLINED\tTIMEOUT\tAPPROVAL")
+		for _, g := range d.groups {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\n",
+				g.Key, g.Total, g.Approved, g.Declined, g.Timeout, percent(g.ApprovalRate()))
+		}
+	}
+	return tw.Flush()
+}
+
+type dimension struct {
+	name   string
+	groups []Group
+}
+
+func (rep *DeclineReport) dimensions() []dimension {
+	return []dimension{
+		{"bucket", rep.ByBucket},
+		{"server", rep.ByServer},
+		{"merchant", rep.ByMerchant},
+		{"terminal", rep.ByTerminal},
+		{"acquirer", rep.ByAcquirer},
+		{"path", rep.ByPath},
+	}
+}
+
+func groupRow(dim string, g Group) []string {
+	return []string{
+		dim, g.Key, "",
+		strconv.Itoa(g.Total),
+		strconv.Itoa(g.Approved),
+		strconv.Itoa(g.Declined),
+		strconv.Itoa(g.Timeout),
+		strconv.FormatFloat(g.ApprovalRate(), 'f', 4, 64),
+	}
+}
+
+func percent(f float64) string {
+	return strconv.FormatFloat(f*100, 'f', 2, 64) + "%"
+}
@@ -0,0 +1,415 @@
+// Package simulate is a mock ISO 8583 host: it reads framed requests,
+// answers them from a rule table, and with a fixed seed answers the same
+// traffic the same way, so captures of it make reproducible fixtures.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/framing"
+	"github.com/msn60/isotcpdump/parser"
+)
+
+// DefaultStrip are request fields hosts do not echo: track 2 (35), track 1
+// (45), PIN block (52) and ICC data (55).
+var DefaultStrip = []int{35, 45, 52, 55}
+
+type Dist string
+
+const (
+	DistFixed       Dist = "fixed"       // Min
+	DistUniform     Dist = "uniform"     // Min..Max
+	DistNormal      Dist = "normal"      // Mean, StdDev, clamped to Min..Max
+	DistExponential Dist = "exponential" // Min plus an exponential with mean Mean, capped at Max
+)
+
+// Delay is a response delay distribution; the zero value answers at once.
+type Delay struct {
+	Dist         Dist
+	Min, Max     time.Duration
+	Mean, StdDev time.Duration
+}
+
+func (d Delay) zero() bool { return d == Delay{} }
+
+// sample draws one delay from d.
+func (d Delay) sample(r *rand.Rand) time.Duration {
+	var v time.Duration
+	switch d.Dist {
+	case DistUniform:
+		if d.Max > d.Min {
+			v = d.Min + time.Duration(r.Int63n(int64(d.Max-d.Min)+1))
+		} else {
+			v = d.Min
+		}
+	case DistNormal:
+		v = d.Mean + time.Duration(r.NormFloat64()*float64(d.StdDev))
+	case DistExponential:
+		v = d.Min + time.Duration(r.ExpFloat64()*float64(d.Mean))
+	default:
+		return d.Min
+	}
+	if v < d.Min {
+		v = d.Min
+	}
+	if d.Max > 0 && v > d.Max {
+		v = d.Max
+	}
+	return v
+}
+
+type Code struct {
+	Code   string
+	Weight int
+}
+
+// Rule answers the requests it matches.
+type Rule struct {
+	MTI         string         // empty: any request
+	Match       map[int]string // field -> value; a trailing * matches a prefix
+	ResponseMTI string         // empty: parser.ResponseMTI of the request
+	Codes       []Code         // DE39 by weight; empty: "00"
+	Drop        float64        // share of matching requests left unanswered
+	Delay       *Delay         // nil: Options.Delay
+}
+
+func (r *Rule) matches(m *parser.Message) bool {
+	if r.MTI != "" && r.MTI != m.MTI {
+		return false
+	}
+	for f, want := range r.Match {
+		got, ok := m.Fields[f]
+		if !ok {
+			return false
+		}
+		if p, prefix := strings.CutSuffix(want, "*"); prefix {
+			if !strings.HasPrefix(got, p) {
+				return false
+			}
+		} else if got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// code picks a DE39 value by weight.
+func (r *Rule) code(rnd *rand.Rand) string {
+	total := 0
+	for _, c := range r.Codes {
+		total += c.Weight
+	}
+	if total <= 0 {
+		if len(r.Codes) > 0 {
+			return r.Codes[0].Code
+		}
+		return "00"
+	}
+	n := rnd.Intn(total)
+	for _, c := range r.Codes {
+		if n < c.Weight {
+			return c.Code
+		}
+		n -= c.Weight
+	}
+	return r.Codes[len(r.Codes)-1].Code
+}
+
+// Options for a Host.
+type Options struct {
+	Header framing.Header
+	Seed   int64 // 0: seeded from the clock
+	Delay  Delay
+	Strip  []int // nil: DefaultStrip, empty: none
+	Rules  []Rule
+}
+
+func OptionsFromConfig(cfg *config.Config) Options {
+	sc := cfg.Simulate
+	h, _ := framing.Parse(sc.Framing)
+	o := Options{
+		Header: h,
+		Seed:   sc.Seed,
+		Delay:  delayFromConfig(sc.Delay),
+		Strip:  sc.Strip,
+	}
+	for _, rc := range sc.Rules {
+		r := Rule{
+			MTI:         strings.TrimSpace(rc.MTI),
+			ResponseMTI: strings.TrimSpace(rc.ResponseMTI),
+			Drop:        rc.Drop,
+		}
+		if len(rc.Match) > 0 {
+			r.Match = make(map[int]string, len(rc.Match))
+			for f, v := range rc.Match {
+				if n, err := strconv.Atoi(strings.TrimSpace(f)); err == nil {
+					r.Match[n] = v
+				}
+			}
+		}
+		for _, c := range rc.Codes {
+			r.Codes = append(r.Codes, Code{Code: strings.TrimSpace(c.Code), Weight: c.Weight})
+		}
+		if d := delayFromConfig(rc.Delay); !d.zero() {
+			r.Delay = &d
+		}
+		o.Rules = append(o.Rules, r)
+	}
+	return o
+}
+
+func delayFromConfig(dc config.SimDelay) Delay {
+	d := Delay{
+		Dist:   Dist(strings.ToLower(strings.TrimSpace(dc.Dist))),
+		Min:    parseDuration(dc.Min),
+		Max:    parseDuration(dc.Max),
+		Mean:   parseDuration(dc.Mean),
+		StdDev: parseDuration(dc.StdDev),
+	}
+	if d.Dist == "" && !d.zero() {
+		d.Dist = DistFixed
+	}
+	return d
+}
+
+func (o Options) withDefaults() Options {
+	if o.Header == "" {
+		o.Header = framing.Default
+	}
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	if o.Strip == nil {
+		o.Strip = DefaultStrip
+	}
+	return o
+}
+
+type Stats struct {
+	Connections uint64 `json:"connections"`
+	Requests    uint64 `json:"requests"`
+	Responses   uint64 `json:"responses"`
+	Dropped     uint64 `json:"dropped"`
+	Unparsable  uint64 `json:"unparsable"` // frames that are not ISO messages, or not requests
+}
+
+// Host answers requests on every connection it accepts.
+type Host struct {
+	opts  Options
+	strip map[int]bool
+
+	mu    sync.Mutex // guards rnd and conns
+	rnd   *rand.Rand
+	conns map[net.Conn]struct{}
+
+	ln   net.Listener
+	wg   sync.WaitGroup
+	done chan struct{}
+
+	connections, requests, responses, dropped, unparsable atomic.Uint64
+}
+
+func New(opts Options) *Host {
+	opts = opts.withDefaults()
+	h := &Host{
+		opts:  opts,
+		strip: make(map[int]bool, len(opts.Strip)),
+		rnd:   rand.New(rand.NewSource(opts.Seed)),
+		conns: make(map[net.Conn]struct{}),
+	}
+	for _, f := range opts.Strip {
+		h.strip[f] = true
+	}
+	return h
+}
+
+// Seed is the seed in use, so a run with a random one can be repeated.
+func (h *Host) Seed() int64 { return h.opts.Seed }
+
+// Respond builds the reply to req, or returns nil to leave it unanswered,
+// with the delay to wait before sending it.
+func (h *Host) Respond(req *parser.Message) (*parser.Message, time.Duration) {
+	rule := &Rule{}
+	for i := range h.opts.Rules {
+		if h.opts.Rules[i].matches(req) {
+			rule = &h.opts.Rules[i]
+			break
+		}
+	}
+	delay := h.opts.Delay
+	if rule.Delay != nil {
+		delay = *rule.Delay
+	}
+
+	h.mu.Lock()
+	drop := rule.Drop > 0 && h.rnd.Float64() < rule.Drop
+	code := rule.code(h.rnd)
+	wait := delay.sample(h.rnd)
+	h.mu.Unlock()
+	if drop {
+		return nil, 0
+	}
+
+	resp := &parser.Message{MTI: rule.ResponseMTI, Fields: make(map[int]string, len(req.Fields)+1)}
+	if resp.MTI == "" {
+		resp.MTI = parser.ResponseMTI(req.MTI)
+	}
+	for f, v := range req.Fields {
+		if f > 1 && !h.strip[f] {
+			resp.Fields[f] = v
+		}
+	}
+	resp.Fields[39] = code
+	return resp, wait
+}
+
+// Start listens on addr (e.g. "127.0.0.1:5000") and serves in the
+// background.
+func (h *Host) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("simulate: %w", err)
+	}
+	h.ln = ln
+	h.done = make(chan struct{})
+	go h.serve()
+	return nil
+}
+
+func (h *Host) serve() {
+	defer close(h.done)
+	for {
+		conn, err := h.ln.Accept()
+		if err != nil {
+			return
+		}
+		h.connections.Add(1)
+		h.mu.Lock()
+		h.conns[conn] = struct{}{}
+		h.mu.Unlock()
+		h.wg.Add(1)
+		go h.handle(conn)
+	}
+}
+
+// handle reads requests off conn and answers each one after its delay, so
+// a slow answer does not hold back the ones after it.
+func (h *Host) handle(conn net.Conn) {
+	defer h.wg.Done()
+	var (
+		writeMu sync.Mutex
+		replies sync.WaitGroup
+	)
+	defer func() {
+		replies.Wait()
+		conn.Close()
+		h.mu.Lock()
+		delete(h.conns, conn)
+		h.mu.Unlock()
+	}()
+	for {
+		raw, err := h.opts.Header.Read(conn)
+		if err != nil {
+			return
+		}
+		req, err := parser.Parse(raw)
+		if err != nil || !parser.IsRequest(req.MTI) {
+			h.unparsable.Add(1)
+			continue
+		}
+		h.requests.Add(1)
+		resp, wait := h.Respond(req)
+		if resp == nil {
+			h.dropped.Add(1)
+			continue
+		}
+		body, err := parser.Pack(resp)
+		if err != nil {
+			h.unparsable.Add(1)
+			continue
+		}
+		frame, err := h.opts.Header.Frame(body)
+		if err != nil {
+			h.unparsable.Add(1)
+			continue
+		}
+		replies.Add(1)
+		go func() {
+			defer replies.Done()
+			if wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-h.done:
+					return
+				}
+			}
+			writeMu.Lock()
+			_, err := conn.Write(frame)
+			writeMu.Unlock()
+			if err == nil {
+				h.responses.Add(1)
+			}
+		}()
+	}
+}
+
+// Addr is the address actually listened on, useful with port 0.
+func (h *Host) Addr() string {
+	if h.ln == nil {
+		return ""
+	}
+	return h.ln.Addr().String()
+}
+
+func (h *Host) Stats() Stats {
+	return Stats{
+		Connections: h.connections.Load(),
+		Requests:    h.requests.Load(),
+		Responses:   h.responses.Load(),
+		Dropped:     h.dropped.Load(),
+		Unparsable:  h.unparsable.Load(),
+	}
+}
+
+// Shutdown stops accepting, closes open connections and waits for their
+// handlers; delayed replies not yet sent are abandoned.
+func (h *Host) Shutdown(ctx context.Context) error {
+	if h.ln == nil {
+		return nil
+	}
+	err := h.ln.Close()
+	<-h.done
+	h.mu.Lock()
+	for c := range h.conns {
+		c.Close()
+	}
+	h.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+func parseDuration(s string) time.Duration {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
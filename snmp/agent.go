@@ -0,0 +1,299 @@
+// Package snmp is a minimal read-only SNMPv1/v2c agent that exposes the
+// capture counters, globally under snmp.root_oid and per crossnetwork path
+// under each crossnetwork.net[].oid. SNMPv1 has no Counter64, so v1
+// requests see only the Gauge32, INTEGER and OCTET STRING objects.
+//
+// Global objects, under the root OID R:
+//
+//	R.1.0  packets            Counter64
+//	R.2.0  payload packets    Counter64
+//	R.3.0  flows              Counter64
+//	R.4.0  active streams     Gauge32
+//	R.5.0  bytes reassembled  Counter64
+//	R.6.0  framing errors     Counter64
+//	R.7.0  resync bytes       Counter64
+//	R.8.0  pending requests   Gauge32
+//	R.9.0  messages           Counter64 (input and output, duplicates excluded)
+//	R.10.0 parse errors       Counter64
+//
+// Per path, under its OID P:
+//
+//	P.1.0  path name          OCTET STRING
+//	P.2.0  path index         INTEGER
+//	P.3.0  input messages     Counter64
+//	P.4.0  output messages    Counter64
+//	P.5.0  duplicates         Counter64
+//	P.6.0  matched            Counter64
+//	P.7.0  declined           Counter64
+//	P.8.0  timeouts           Counter64
+//	P.9.0  orphans            Counter64 (requests and responses)
+//	P.10.0 parse errors       Counter64
+package snmp
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/constants"
+	"github.com/msn60/isotcpdump/crossnet"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/report"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+// DefaultRootOID is used when snmp.root_oid is empty.
+const DefaultRootOID = "1.3.6.1.4.1.10.2.1"
+
+// errors in response PDUs
+const (
+	errNoError     = 0
+	errNoSuchName  = 2 // v1
+	errReadOnly    = 4 // v1
+	errNotWritable = 17
+)
+
+type pathStats struct {
+	path                                 crossnet.Path
+	oid                                  OID
+	input, output, duplicates            uint64
+	matched, declined, timeouts, orphans uint64
+	parseErrors                          uint64
+}
+
+type value struct {
+	tag byte
+	raw []byte
+}
+
+type entry struct {
+	oid OID
+	val value
+}
+
+// Agent answers Get, GetNext and GetBulk from the live counters.
+type Agent struct {
+	community string
+	root      OID
+	agg       *stream.Aggregator
+	m         *matcher.Matcher
+
+	mu          sync.Mutex
+	codes       map[string]constants.ResponseCode
+	paths       map[string]*pathStats // by path name
+	messages    uint64
+	parseErrors uint64
+
+	conn net.PacketConn
+	done chan struct{}
+}
+
+// New builds an agent for the paths configured in cfg; agg and m are read
+// on every request, m may be nil.
+func New(cfg *config.Config, agg *stream.Aggregator, m *matcher.Matcher) (*Agent, error) {
+	a := &Agent{
+		community: cfg.SNMP.Community,
+		agg:       agg,
+		m:         m,
+	}
+	if strings.TrimSpace(a.community) == "" {
+		a.community = "public"
+	}
+	rootOID := strings.TrimSpace(cfg.SNMP.RootOID)
+	if rootOID == "" {
+		rootOID = DefaultRootOID
+	}
+	root, err := ParseOID(rootOID)
+	if err != nil {
+		return nil, err
+	}
+	a.root = root
+	if err := a.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetConfig picks up the paths and response codes of a reloaded config.
+// Counters of paths that are still configured carry over.
+func (a *Agent) SetConfig(cfg *config.Config) error {
+	labeler, err := crossnet.New(cfg)
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]*pathStats)
+	for _, p := range labeler.Paths() {
+		oid, err := ParseOID(p.OID)
+		if err != nil {
+			return err
+		}
+		paths[p.Name] = &pathStats{path: p, oid: oid}
+	}
+	codes := report.OptionsFromConfig(cfg).Codes
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, ps := range paths {
+		if old, ok := a.paths[name]; ok {
+			old.path, old.oid = ps.path, ps.oid
+			paths[name] = old
+		}
+	}
+	a.paths, a.codes = paths, codes
+	return nil
+}
+
+// ObserveRecord counts messages; hook it with Aggregator.OnRecord.
+func (a *Agent) ObserveRecord(rec stream.Record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if rec.Duplicate == "" {
+		a.messages++
+	}
+	if rec.ParseErr != nil {
+		a.parseErrors++
+	}
+	ps, ok := a.paths[rec.Path]
+	if !ok {
+		return
+	}
+	switch {
+	case rec.Duplicate != "":
+		ps.duplicates++
+	case rec.Direction == stream.DirectionInput:
+		ps.input++
+	default:
+		ps.output++
+	}
+	if rec.ParseErr != nil {
+		ps.parseErrors++
+	}
+}
+
+// ObserveEvent counts matcher outcomes; hook it with Matcher.OnEvent.
+func (a *Agent) ObserveEvent(ev matcher.Event) {
+	rec := ev.Request
+	if rec == nil {
+		rec = ev.Response
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ps, ok := a.paths[rec.Path]
+	if !ok {
+		return
+	}
+	switch ev.Kind {
+	case matcher.KindMatched:
+		ps.matched++
+		code := ev.Response.Msg.Field(39)
+		if rc, ok := a.codes[code]; !ok || rc.Outcome != constants.OutcomeApproved {
+			ps.declined++
+		}
+	case matcher.KindTimeout:
+		ps.timeouts++
+	case matcher.KindOrphanRequest, matcher.KindOrphanResponse:
+		ps.orphans++
+	}
+}
+
+// tree is a sorted snapshot of every object.
+func (a *Agent) tree() []entry {
+	var out []entry
+	add := func(oid OID, v value) { out = append(out, entry{oid, v}) }
+
+	c := a.agg.Counters()
+	pending := 0
+	if a.m != nil {
+		pending = a.m.Pending()
+	}
+
+	a.mu.Lock()
+	r := a.root
+	add(r.Append(1, 0), counter64(c.Packets))
+	add(r.Append(2, 0), counter64(c.PayloadPackets))
+	add(r.Append(3, 0), counter64(c.Flows))
+	add(r.Append(4, 0), gauge32(c.ActiveStreams))
+	add(r.Append(5, 0), counter64(c.BytesReassembled))
+	add(r.Append(6, 0), counter64(c.FramingErrors))
+	add(r.Append(7, 0), counter64(c.ResyncBytes))
+	add(r.Append(8, 0), gauge32(int64(pending)))
+	add(r.Append(9, 0), counter64(int64(a.messages)))
+	add(r.Append(10, 0), counter64(int64(a.parseErrors)))
+	for _, ps := range a.paths {
+		p := ps.oid
+		add(p.Append(1, 0), value{tagOctetString, []byte(ps.path.Name)})
+		add(p.Append(2, 0), value{tagInteger, encodeInt(int64(ps.path.Index))})
+		add(p.Append(3, 0), counter64(int64(ps.input)))
+		add(p.Append(4, 0), counter64(int64(ps.output)))
+		add(p.Append(5, 0), counter64(int64(ps.duplicates)))
+		add(p.Append(6, 0), counter64(int64(ps.matched)))
+		add(p.Append(7, 0), counter64(int64(ps.declined)))
+		add(p.Append(8, 0), counter64(int64(ps.timeouts)))
+		add(p.Append(9, 0), counter64(int64(ps.orphans)))
+		add(p.Append(10, 0), counter64(int64(ps.parseErrors)))
+	}
+	a.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].oid.Compare(out[j].oid) < 0 })
+	return out
+}
+
+func counter64(v int64) value { return value{tagCounter64, encodeUint(uint64(v))} }
+
+func gauge32(v int64) value {
+	if v < 0 {
+		v = 0
+	}
+	return value{tagGauge32, encodeUint(uint64(uint32(v)))}
+}
+
+// Start listens on addr (e.g. "127.0.0.1:1161") and serves in the
+// background.
+func (a *Agent) Start(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	a.conn = conn
+	a.done = make(chan struct{})
+	go a.serve()
+	return nil
+}
+
+func (a *Agent) serve() {
+	defer close(a.done)
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := a.Handle(buf[:n]); resp != nil {
+			_, _ = a.conn.WriteTo(resp, from)
+		}
+	}
+}
+
+// Addr is the address actually listened on, useful with port 0.
+func (a *Agent) Addr() string {
+	if a.conn == nil {
+		return ""
+	}
+	return a.conn.LocalAddr().String()
+}
+
+func (a *Agent) Shutdown(ctx context.Context) error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,232 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMPv1/v2c.
+const (
+	tagInteger     byte = 0x02
+	tagOctetString byte = 0x04
+	tagNull        byte = 0x05
+	tagOID         byte = 0x06
+	tagSequence    byte = 0x30
+	tagGauge32     byte = 0x42
+	tagCounter64   byte = 0x46
+
+	tagNoSuchObject byte = 0x80
+	tagEndOfMibView byte = 0x82
+
+	pduGet      byte = 0xa0
+	pduGetNext  byte = 0xa1
+	pduResponse byte = 0xa2
+	pduSet      byte = 0xa3
+	pduGetBulk  byte = 0xa5
+)
+
+var errTruncated = errors.New("snmp: truncated packet")
+
+type tlv struct {
+	tag byte
+	val []byte
+}
+
+func readTLV(b []byte) (tlv, []byte, error) {
+	if len(b) < 2 {
+		return tlv{}, nil, errTruncated
+	}
+	tag, n, i := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		octets := n & 0x7f
+		if octets == 0 || octets > 4 || len(b) < 2+octets {
+			return tlv{}, nil, errTruncated
+		}
+		n = 0
+		for _, c := range b[2 : 2+octets] {
+			n = n<<8 | int(c)
+		}
+		i += octets
+	}
+	if n < 0 || len(b)-i < n {
+		return tlv{}, nil, errTruncated
+	}
+	return tlv{tag: tag, val: b[i : i+n]}, b[i+n:], nil
+}
+
+// readTLVs splits the contents of a constructed value.
+func readTLVs(b []byte) ([]tlv, error) {
+	var out []tlv
+	for len(b) > 0 {
+		t, rest, err := readTLV(b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+		b = rest
+	}
+	return out, nil
+}
+
+func appendTLV(dst []byte, tag byte, val []byte) []byte {
+	dst = append(dst, tag)
+	switch n := len(val); {
+	case n < 0x80:
+		dst = append(dst, byte(n))
+	case n <= 0xff:
+		dst = append(dst, 0x81, byte(n))
+	default:
+		dst = append(dst, 0x82, byte(n>>8), byte(n))
+	}
+	return append(dst, val...)
+}
+
+func encodeInt(v int64) []byte {
+	b := []byte{byte(v)}
+	for (v > 0x7f || v < -0x80) && len(b) < 8 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return b
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, fmt.Errorf("snmp: bad integer length %d", len(b))
+	}
+	v := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+// encodeUint is the content of an unsigned application type.
+func encodeUint(v uint64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// OID is a dotted object identifier as arcs.
+type OID []uint32
+
+// ParseOID reads "1.3.6.1.4.1"; a leading dot is allowed.
+func ParseOID(s string) (OID, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), ".")
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("snmp: invalid oid %q", s)
+	}
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("snmp: invalid oid %q", s)
+		}
+		oid[i] = uint32(v)
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] >= 40) {
+		return nil, fmt.Errorf("snmp: invalid oid %q", s)
+	}
+	return oid, nil
+}
+
+func (o OID) String() string {
+	parts := make([]string, len(o))
+	for i, v := range o {
+		parts[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Append returns o followed by arcs, leaving o untouched.
+func (o OID) Append(arcs ...uint32) OID {
+	out := make(OID, 0, len(o)+len(arcs))
+	return append(append(out, o...), arcs...)
+}
+
+// Compare orders OIDs lexicographically by arc.
+func (o OID) Compare(p OID) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		switch {
+		case o[i] < p[i]:
+			return -1
+		case o[i] > p[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(p):
+		return -1
+	case len(o) > len(p):
+		return 1
+	}
+	return 0
+}
+
+func encodeOID(o OID) []byte {
+	if len(o) < 2 {
+		return []byte{0}
+	}
+	b := appendBase128(nil, o[0]*40+o[1])
+	for _, v := range o[2:] {
+		b = appendBase128(b, v)
+	}
+	return b
+}
+
+func appendBase128(dst []byte, v uint32) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	return append(dst, tmp[i:]...)
+}
+
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errors.New("snmp: empty oid")
+	}
+	var arcs []uint32
+	var v uint32
+	for i, c := range b {
+		if v > 0x1ffffff {
+			return nil, errors.New("snmp: oid arc overflow")
+		}
+		v = v<<7 | uint32(c&0x7f)
+		if c&0x80 != 0 {
+			if i == len(b)-1 {
+				return nil, errTruncated
+			}
+			continue
+		}
+		arcs = append(arcs, v)
+		v = 0
+	}
+	first := arcs[0]
+	var oid OID
+	switch {
+	case first < 40:
+		oid = OID{0, first}
+	case first < 80:
+		oid = OID{1, first - 40}
+	default:
+		oid = OID{2, first - 80}
+	}
+	return append(oid, arcs[1:]...), nil
+}
@@ -0,0 +1,240 @@
+package snmp
+
+import (
+	"sort"
+)
+
+const (
+	version1  = 0
+	version2c = 1
+
+	maxBulkRepetitions = 100
+)
+
+type request struct {
+	version   int64
+	community string
+	pduType   byte
+	requestID int64
+	field2    int64 // error status, or non-repeaters for GetBulk
+	field3    int64 // error index, or max-repetitions for GetBulk
+	oids      []OID
+}
+
+type varbind struct {
+	oid OID
+	val value
+}
+
+// Handle answers one request packet. Malformed packets, unknown versions
+// and wrong communities get no response.
+func (a *Agent) Handle(packet []byte) []byte {
+	req, err := parseRequest(packet)
+	if err != nil || (req.version != version1 && req.version != version2c) || req.community != a.community {
+		return nil
+	}
+	tree := a.tree()
+	if req.version == version1 {
+		tree = v1View(tree)
+	}
+
+	var (
+		vbs    []varbind
+		status int64 = errNoError
+		index  int64
+	)
+	fail := func(s int64, i int) {
+		if status == errNoError {
+			status, index = s, int64(i+1)
+		}
+	}
+
+	switch req.pduType {
+	case pduGet:
+		for i, oid := range req.oids {
+			if e, ok := lookup(tree, oid); ok {
+				vbs = append(vbs, varbind{oid, e.val})
+				continue
+			}
+			if req.version == version1 {
+				fail(errNoSuchName, i)
+			}
+			vbs = append(vbs, varbind{oid, value{tagNoSuchObject, nil}})
+		}
+	case pduGetNext:
+		for i, oid := range req.oids {
+			if e, ok := next(tree, oid); ok {
+				vbs = append(vbs, varbind{e.oid, e.val})
+				continue
+			}
+			if req.version == version1 {
+				fail(errNoSuchName, i)
+			}
+			vbs = append(vbs, varbind{oid, value{tagEndOfMibView, nil}})
+		}
+	case pduGetBulk:
+		if req.version == version1 {
+			return nil
+		}
+		vbs = getBulk(tree, req.oids, int(req.field2), int(req.field3))
+	case pduSet:
+		for _, oid := range req.oids {
+			vbs = append(vbs, varbind{oid, value{tagNull, nil}})
+		}
+		if req.version == version1 {
+			fail(errReadOnly, 0)
+		} else {
+			fail(errNotWritable, 0)
+		}
+	default:
+		return nil
+	}
+
+	if status != errNoError {
+		// v1 and failed sets echo the request bindings
+		vbs = vbs[:0]
+		for _, oid := range req.oids {
+			vbs = append(vbs, varbind{oid, value{tagNull, nil}})
+		}
+	}
+	return encodeResponse(req, status, index, vbs)
+}
+
+// v1View drops the Counter64 objects SNMPv1 cannot carry, so v1 Get
+// answers noSuchName for them and GetNext skips them (RFC 2576 4.2.2).
+func v1View(tree []entry) []entry {
+	out := make([]entry, 0, len(tree))
+	for _, e := range tree {
+		if e.val.tag != tagCounter64 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func getBulk(tree []entry, oids []OID, nonRepeaters, maxReps int) []varbind {
+	if nonRepeaters < 0 {
+		nonRepeaters = 0
+	}
+	if nonRepeaters > len(oids) {
+		nonRepeaters = len(oids)
+	}
+	if maxReps < 0 {
+		maxReps = 0
+	}
+	if maxReps > maxBulkRepetitions {
+		maxReps = maxBulkRepetitions
+	}
+	var vbs []varbind
+	step := func(oid OID) varbind {
+		if e, ok := next(tree, oid); ok {
+			return varbind{e.oid, e.val}
+		}
+		return varbind{oid, value{tagEndOfMibView, nil}}
+	}
+	for _, oid := range oids[:nonRepeaters] {
+		vbs = append(vbs, step(oid))
+	}
+	cur := append([]OID(nil), oids[nonRepeaters:]...)
+	for r := 0; r < maxReps && len(cur) > 0; r++ {
+		ended := true
+		for i, oid := range cur {
+			vb := step(oid)
+			vbs = append(vbs, vb)
+			cur[i] = vb.oid
+			if vb.val.tag != tagEndOfMibView {
+				ended = false
+			}
+		}
+		if ended {
+			break
+		}
+	}
+	return vbs
+}
+
+func lookup(tree []entry, oid OID) (entry, bool) {
+	i := sort.Search(len(tree), func(i int) bool { return tree[i].oid.Compare(oid) >= 0 })
+	if i < len(tree) && tree[i].oid.Compare(oid) == 0 {
+		return tree[i], true
+	}
+	return entry{}, false
+}
+
+func next(tree []entry, oid OID) (entry, bool) {
+	i := sort.Search(len(tree), func(i int) bool { return tree[i].oid.Compare(oid) > 0 })
+	if i < len(tree) {
+		return tree[i], true
+	}
+	return entry{}, false
+}
+
+func parseRequest(b []byte) (*request, error) {
+	msg, _, err := readTLV(b)
+	if err != nil {
+		return nil, err
+	}
+	if msg.tag != tagSequence {
+		return nil, errTruncated
+	}
+	parts, err := readTLVs(msg.val)
+	if err != nil || len(parts) != 3 || parts[0].tag != tagInteger || parts[1].tag != tagOctetString {
+		return nil, errTruncated
+	}
+	req := &request{community: string(parts[1].val), pduType: parts[2].tag}
+	if req.version, err = decodeInt(parts[0].val); err != nil {
+		return nil, err
+	}
+
+	pdu, err := readTLVs(parts[2].val)
+	if err != nil || len(pdu) != 4 || pdu[3].tag != tagSequence {
+		return nil, errTruncated
+	}
+	for i, dst := range []*int64{&req.requestID, &req.field2, &req.field3} {
+		if pdu[i].tag != tagInteger {
+			return nil, errTruncated
+		}
+		if *dst, err = decodeInt(pdu[i].val); err != nil {
+			return nil, err
+		}
+	}
+
+	list, err := readTLVs(pdu[3].val)
+	if err != nil {
+		return nil, err
+	}
+	for _, vb := range list {
+		fields, err := readTLVs(vb.val)
+		if err != nil || vb.tag != tagSequence || len(fields) != 2 || fields[0].tag != tagOID {
+			return nil, errTruncated
+		}
+		oid, err := decodeOID(fields[0].val)
+		if err != nil {
+			return nil, err
+		}
+		req.oids = append(req.oids, oid)
+	}
+	return req, nil
+}
+
+func encodeResponse(req *request, status, index int64, vbs []varbind) []byte {
+	var list []byte
+	for _, vb := range vbs {
+		var b []byte
+		b = appendTLV(b, tagOID, encodeOID(vb.oid))
+		b = appendTLV(b, vb.val.tag, vb.val.raw)
+		list = appendTLV(list, tagSequence, b)
+	}
+
+	var pdu []byte
+	pdu = appendTLV(pdu, tagInteger, encodeInt(req.requestID))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(status))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(index))
+	pdu = appendTLV(pdu, tagSequence, list)
+
+	var msg []byte
+	msg = appendTLV(msg, tagInteger, encodeInt(req.version))
+	msg = appendTLV(msg, tagOctetString, []byte(req.community))
+	msg = appendTLV(msg, pduResponse, pdu)
+	return appendTLV(nil, tagSequence, msg)
+}
@@ -0,0 +1,101 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/matcher"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+func testAgent(t *testing.T) *Agent {
+	t.Helper()
+	cfg := &config.Config{SNMP: config.SNMP{RootOID: "1.3.6.1.4.1.99"}}
+	a, err := New(cfg, stream.NewAggregator(0), matcher.New(matcher.Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func encodeRequest(version int64, pduType byte, oids ...string) []byte {
+	var list []byte
+	for _, s := range oids {
+		oid, _ := ParseOID(s)
+		var vb []byte
+		vb = appendTLV(vb, tagOID, encodeOID(oid))
+		vb = appendTLV(vb, tagNull, nil)
+		list = appendTLV(list, tagSequence, vb)
+	}
+	var pdu []byte
+	pdu = appendTLV(pdu, tagInteger, encodeInt(7))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(0))
+	pdu = appendTLV(pdu, tagInteger, encodeInt(0))
+	pdu = appendTLV(pdu, tagSequence, list)
+	var msg []byte
+	msg = appendTLV(msg, tagInteger, encodeInt(version))
+	msg = appendTLV(msg, tagOctetString, []byte("public"))
+	msg = appendTLV(msg, pduType, pdu)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// decodeResponse returns the error status and the bindings of a response.
+func decodeResponse(t *testing.T, b []byte) (int64, []varbind) {
+	t.Helper()
+	msg, _, err := readTLV(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := readTLVs(msg.val)
+	if err != nil || len(parts) != 3 || parts[2].tag != pduResponse {
+		t.Fatalf("not a response: %x", b)
+	}
+	pdu, err := readTLVs(parts[2].val)
+	if err != nil || len(pdu) != 4 {
+		t.Fatalf("bad pdu: %x", parts[2].val)
+	}
+	status, _ := decodeInt(pdu[1].val)
+	list, _ := readTLVs(pdu[3].val)
+	var vbs []varbind
+	for _, vb := range list {
+		f, _ := readTLVs(vb.val)
+		oid, _ := decodeOID(f[0].val)
+		vbs = append(vbs, varbind{oid, value{f[1].tag, f[1].val}})
+	}
+	return status, vbs
+}
+
+func TestV1HasNoCounter64(t *testing.T) {
+	a := testAgent(t)
+
+	// v2c gets the Counter64
+	status, vbs := decodeResponse(t, a.Handle(encodeRequest(version2c, pduGet, "1.3.6.1.4.1.99.1.0")))
+	if status != errNoError || len(vbs) != 1 || vbs[0].val.tag != tagCounter64 {
+		t.Errorf("v2c get packets: status %d, %+v", status, vbs)
+	}
+
+	// v1 get: noSuchName
+	status, _ = decodeResponse(t, a.Handle(encodeRequest(version1, pduGet, "1.3.6.1.4.1.99.1.0")))
+	if status != errNoSuchName {
+		t.Errorf("v1 get packets: status %d, want noSuchName", status)
+	}
+
+	// v1 walk: no Counter64, and active streams (R.4.0) is the first object
+	oid := "1.3.6.1.4.1.99"
+	for i := 0; ; i++ {
+		status, vbs := decodeResponse(t, a.Handle(encodeRequest(version1, pduGetNext, oid)))
+		if status == errNoSuchName {
+			break
+		}
+		if status != errNoError || len(vbs) != 1 {
+			t.Fatalf("v1 getnext %s: status %d", oid, status)
+		}
+		if vbs[0].val.tag == tagCounter64 {
+			t.Errorf("v1 getnext %s: Counter64 at %s", oid, vbs[0].oid)
+		}
+		if i == 0 && vbs[0].oid.String() != "1.3.6.1.4.1.99.4.0" {
+			t.Errorf("v1 getnext from the root: %s, want 1.3.6.1.4.1.99.4.0", vbs[0].oid)
+		}
+		oid = vbs[0].oid.String()
+	}
+}
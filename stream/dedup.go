@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"slices"
+	"strconv"
+	"time"
+)
+
+type DuplicateKind string
+
+const (
+	DuplicateRetransmission DuplicateKind = "retransmission" // same bytes on the same flow
+	DuplicateResend         DuplicateKind = "resend"         // same MTI+STAN+terminal on any flow
+)
+
+type contentKey struct {
+	flow string
+	sum  [sha256.Size]byte
+}
+
+type seenAt struct {
+	content contentKey
+	resend  string
+	at      time.Time
+}
+
+// FlushAge is how long the capture loop lets the assembler hold an idle
+// stream before flushing it, so how late, in capture time, a record may
+// reach the aggregator behind newer ones from other streams.
+const FlushAge = 2 * time.Minute
+
+// deduper remembers messages for a capture-time window. Records arrive in
+// time order per stream but not across streams, so each key keeps every
+// time it was seen, and pruning waits FlushAge past the window for records
+// still held by the assembler.
+type deduper struct {
+	window   time.Duration
+	lateness time.Duration
+	latest   time.Time
+	content  map[contentKey][]time.Time
+	resends  map[string][]time.Time
+	queue    seenHeap
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{
+		window:   window,
+		lateness: FlushAge,
+		content:  make(map[contentKey][]time.Time),
+		resends:  make(map[string][]time.Time),
+	}
+}
+
+// check classifies rec (raw is the framed message) and remembers it.
+func (d *deduper) check(rec *Record, raw []byte) DuplicateKind {
+	if rec.Time.After(d.latest) {
+		d.latest = rec.Time
+		d.prune()
+	}
+
+	ck := contentKey{
+		flow: rec.SrcIP + ":" + strconv.Itoa(rec.SrcPort) + ">" + rec.DstIP + ":" + strconv.Itoa(rec.DstPort),
+		sum:  sha256.Sum256(raw),
+	}
+	rk := ""
+	if rec.Msg != nil && rec.Msg.Has(11) {
+		rk = rec.Msg.MTI + "_" + rec.Msg.Field(11) + "_" + rec.Msg.Field(41)
+	}
+
+	var kind DuplicateKind
+	if d.within(d.content[ck], rec.Time) {
+		kind = DuplicateRetransmission
+	} else if rk != "" && d.within(d.resends[rk], rec.Time) {
+		kind = DuplicateResend
+	}
+
+	d.content[ck] = append(d.content[ck], rec.Time)
+	if rk != "" {
+		d.resends[rk] = append(d.resends[rk], rec.Time)
+	}
+	heap.Push(&d.queue, seenAt{content: ck, resend: rk, at: rec.Time})
+	return kind
+}
+
+// within reports whether one of seen is no more than the window from t,
+// either way.
+func (d *deduper) within(seen []time.Time, t time.Time) bool {
+	for _, s := range seen {
+		if diff := t.Sub(s); diff <= d.window && diff >= -d.window {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets sightings no record still to come can be a duplicate of.
+func (d *deduper) prune() {
+	before := d.latest.Add(-d.window - d.lateness)
+	for len(d.queue) > 0 && d.queue[0].at.Before(before) {
+		s := heap.Pop(&d.queue).(seenAt)
+		forget(d.content, s.content, s.at)
+		if s.resend != "" {
+			forget(d.resends, s.resend, s.at)
+		}
+	}
+}
+
+func forget[K comparable](m map[K][]time.Time, k K, at time.Time) {
+	times := m[k]
+	if i := slices.IndexFunc(times, at.Equal); i >= 0 {
+		times = slices.Delete(times, i, i+1)
+	}
+	if len(times) == 0 {
+		delete(m, k)
+	} else {
+		m[k] = times
+	}
+}
+
+// seenHeap orders sightings oldest first.
+type seenHeap []seenAt
+
+func (h seenHeap) Len() int           { return len(h) }
+func (h seenHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h seenHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *seenHeap) Push(x any)        { *h = append(*h, x.(seenAt)) }
+func (h *seenHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
@@ -0,0 +1,103 @@
+package stream
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msn60/isotcpdump/parser"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// msg is a 0200 from srcPort with STAN stan at t0+at; raw stands in for
+// the framed bytes.
+func msg(srcPort int, stan, raw string, at time.Duration) (*Record, []byte) {
+	return &Record{
+		Time:    t0.Add(at),
+		SrcIP:   "10.0.0.1",
+		SrcPort: srcPort,
+		DstIP:   "10.0.0.9",
+		DstPort: 5000,
+		Msg:     &parser.Message{MTI: "0200", Fields: map[int]string{11: stan, 41: "TERM0001"}},
+	}, []byte(raw)
+}
+
+func TestDeduper(t *testing.T) {
+	const window = 5 * time.Second
+	type seen struct {
+		port int
+		stan string
+		raw  string
+		at   time.Duration
+		want DuplicateKind
+	}
+	tests := []struct {
+		name string
+		in   []seen
+	}{
+		{"retransmission in window", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40001, "000001", "a", 4 * time.Second, DuplicateRetransmission},
+		}},
+		{"retransmission past window", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40001, "000001", "a", 6 * time.Second, ""},
+		}},
+		{"resend on another flow in window", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40002, "000001", "b", 3 * time.Second, DuplicateResend},
+		}},
+		{"same bytes on another flow is a resend", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40002, "000001", "a", time.Second, DuplicateResend},
+		}},
+		{"resend past window", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40002, "000001", "b", 6 * time.Second, ""},
+		}},
+		{"other stan", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40002, "000002", "b", time.Second, ""},
+		}},
+		// the assembler flushes a stalled stream late: its older records
+		// arrive after newer ones from other flows
+		{"late record in window", []seen{
+			{40001, "000001", "a", 0, ""},
+			{40003, "000009", "z", time.Minute, ""},
+			{40001, "000001", "a", 2 * time.Second, DuplicateRetransmission},
+			{40002, "000001", "b", 3 * time.Second, DuplicateResend},
+		}},
+		{"late record past window", []seen{
+			{40001, "000001", "a", time.Minute, ""},
+			{40001, "000001", "a", 0, ""},
+			{40002, "000001", "b", 10 * time.Second, ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDeduper(window)
+			for i, s := range tt.in {
+				rec, raw := msg(s.port, s.stan, s.raw, s.at)
+				if got := d.check(rec, raw); got != s.want {
+					t.Errorf("record %d: %q, want %q", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+// Sightings are forgotten once past the window and the flush age.
+func TestDeduperPrunes(t *testing.T) {
+	d := newDeduper(time.Second)
+	for i := 0; i < 1000; i++ {
+		rec, raw := msg(40001, "000001", "a", time.Duration(i)*time.Second)
+		d.check(rec, raw)
+	}
+	limit := int((time.Second + FlushAge) / time.Second)
+	if n := len(d.queue); n > limit+1 {
+		t.Errorf("%d sightings kept, want at most %d", n, limit+1)
+	}
+	if n := len(d.content) + len(d.resends); n != 2 {
+		t.Errorf("%d keys kept, want 2", n)
+	}
+}
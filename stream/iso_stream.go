@@ -1,23 +1,82 @@
 package stream
 
 import (
+	"encoding/binary"
 	"fmt"
-	"io"
 	"net"
-	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/tcpassembly"
-	"github.com/google/gopacket/tcpassembly/tcpreader"
+	"github.com/msn60/isotcpdump/config"
+	"github.com/msn60/isotcpdump/crossnet"
+	"github.com/msn60/isotcpdump/framing"
+	"github.com/msn60/isotcpdump/parser"
 )
 
+type Direction string
+
+const (
+	DirectionInput  Direction = "input"  // towards fw
+	DirectionOutput Direction = "output" // from fw
+)
+
+// Record is one framed ISO message together with where and when it was seen.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	SrcIP     string
+	SrcPort   int
+	DstIP     string
+	DstPort   int
+	Key       string
+	Msg       *parser.Message
+	Raw       []byte // message bytes without the length header
+	ParseErr  error
+	Duplicate DuplicateKind // empty for first sightings
+	Path      string        // crossnetwork path of the flow, empty when unlabeled
+	PathOID   string
+}
+
+// Counters are cheap running totals, readable while the capture runs.
+type Counters struct {
+	Packets          int64 `json:"packets"`
+	PayloadPackets   int64 `json:"payload_packets"`
+	Flows            int64 `json:"flows"`
+	ActiveStreams    int64 `json:"active_streams"`
+	BytesReassembled int64 `json:"bytes_reassembled"`
+	FramingErrors    int64 `json:"framing_errors"`
+	ResyncBytes      int64 `json:"resync_bytes"`
+}
+
+// PathCounts are the messages seen on one crossnetwork path.
+type PathCounts struct {
+	Path       string `json:"path"`
+	OID        string `json:"oid"`
+	Input      int    `json:"input"`
+	Output     int    `json:"output"`
+	Duplicates int    `json:"duplicates"`
+}
+
 type IsoStreamResponse struct {
+	Counters
 	InputRows           [][]string
 	OutputRows          [][]string
+	Records             []Record
 	TotalInputMessages  int
 	TotalOutputMessages int
+	DroppedRecords      int
+
+	// duplicates are not part of the totals above
+	DuplicateInputMessages  int
+	DuplicateOutputMessages int
+	Retransmissions         int
+	Resends                 int
+
+	ByPath []PathCounts // labeled flows only, in order of first sighting
 }
 
 // ---- Aggregator for all streams----
@@ -29,6 +88,26 @@ type Aggregator struct {
 	totalInputMessages  int
 	totalOutputMessages int
 	maxCSVRows          int
+	records             []Record
+	maxRecords          int
+	droppedRecords      int
+	onRecord            []func(Record)
+
+	packets          atomic.Int64
+	payloadPackets   atomic.Int64
+	flows            atomic.Int64
+	activeStreams    atomic.Int64
+	bytesReassembled atomic.Int64
+	framingErrors    atomic.Int64
+	resyncBytes      atomic.Int64
+
+	dedup                   *deduper
+	duplicateInputMessages  int
+	duplicateOutputMessages int
+	duplicates              map[DuplicateKind]int
+
+	paths     map[string]*PathCounts
+	pathOrder []string
 }
 
 func NewAggregator(maxCSVRows int) *Aggregator {
@@ -36,24 +115,124 @@ func NewAggregator(maxCSVRows int) *Aggregator {
 		inputRows:  make([][]string, 0, maxCSVRows),
 		outputRows: make([][]string, 0, maxCSVRows),
 		maxCSVRows: maxCSVRows,
+		maxRecords: maxCSVRows,
+		duplicates: make(map[DuplicateKind]int),
+		paths:      make(map[string]*PathCounts),
+	}
+}
+
+// WithDuplicateWindow enables duplicate detection over window of capture
+// time; 0 disables it.
+func (a *Aggregator) WithDuplicateWindow(window time.Duration) *Aggregator {
+	if window > 0 {
+		a.dedup = newDeduper(window)
+	} else {
+		a.dedup = nil
+	}
+	return a
+}
+
+// CountPacket records a captured TCP packet.
+func (a *Aggregator) CountPacket(hasPayload bool) {
+	a.packets.Add(1)
+	if hasPayload {
+		a.payloadPackets.Add(1)
+	}
+}
+
+func (a *Aggregator) Counters() Counters {
+	return Counters{
+		Packets:          a.packets.Load(),
+		PayloadPackets:   a.payloadPackets.Load(),
+		Flows:            a.flows.Load(),
+		ActiveStreams:    a.activeStreams.Load(),
+		BytesReassembled: a.bytesReassembled.Load(),
+		FramingErrors:    a.framingErrors.Load(),
+		ResyncBytes:      a.resyncBytes.Load(),
 	}
 }
 
-func (a *Aggregator) addInputRow(row []string) {
+func (a *Aggregator) checkDuplicate(rec *Record, raw []byte) DuplicateKind {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dedup == nil {
+		return ""
+	}
+	kind := a.dedup.check(rec, raw)
+	if kind != "" {
+		a.duplicates[kind]++
+	}
+	return kind
+}
+
+// WithMaxRecords sets how many decoded records are kept for reports.
+func (a *Aggregator) WithMaxRecords(n int) *Aggregator {
+	if n > 0 {
+		a.maxRecords = n
+	}
+	return a
+}
+
+// OnRecord registers fn to receive every decoded record, including those
+// beyond the max records limit.
+func (a *Aggregator) OnRecord(fn func(Record)) *Aggregator {
+	a.onRecord = append(a.onRecord, fn)
+	return a
+}
+
+func (a *Aggregator) addRecord(rec Record) {
+	a.mu.Lock()
+	if len(a.records) < a.maxRecords {
+		a.records = append(a.records, rec)
+	} else {
+		a.droppedRecords++
+	}
+	if rec.Path != "" {
+		pc, ok := a.paths[rec.Path]
+		if !ok {
+			pc = &PathCounts{Path: rec.Path, OID: rec.PathOID}
+			a.paths[rec.Path] = pc
+			a.pathOrder = append(a.pathOrder, rec.Path)
+		}
+		switch {
+		case rec.Duplicate != "":
+			pc.Duplicates++
+		case rec.Direction == DirectionInput:
+			pc.Input++
+		default:
+			pc.Output++
+		}
+	}
+	a.mu.Unlock()
+
+	for _, fn := range a.onRecord {
+		fn(rec)
+	}
+}
+
+func (a *Aggregator) addInputRow(row []string, dup bool) {
 	a.mu.Lock()
 	if len(a.inputRows) < a.maxCSVRows {
 		a.inputRows = append(a.inputRows, row)
 	}
-	a.totalInputMessages++
+	if dup {
+		a.duplicateInputMessages++
+	} else {
+		a.totalInputMessages++
+	}
 	a.mu.Unlock()
 }
 
-func (a *Aggregator) addOutputRow(row []string) {
+func (a *Aggregator) addOutputRow(row []string, dup bool) {
 	a.mu.Lock()
 	if len(a.outputRows) < a.maxCSVRows {
 		a.outputRows = append(a.outputRows, row)
 	}
-	a.totalOutputMessages++
+	if dup {
+		a.duplicateOutputMessages++
+	} else {
+		a.totalOutputMessages++
+	}
 	a.mu.Unlock()
 }
 
@@ -66,26 +245,33 @@ func (a *Aggregator) Snapshot() *IsoStreamResponse {
 	copy(outIn, a.inputRows)
 	outOut := make([][]string, len(a.outputRows))
 	copy(outOut, a.outputRows)
+	outRec := make([]Record, len(a.records))
+	copy(outRec, a.records)
+	byPath := make([]PathCounts, 0, len(a.pathOrder))
+	for _, p := range a.pathOrder {
+		byPath = append(byPath, *a.paths[p])
+	}
 
 	return &IsoStreamResponse{
+		Counters:            a.Counters(),
 		InputRows:           outIn,
 		OutputRows:          outOut,
+		Records:             outRec,
 		TotalInputMessages:  a.totalInputMessages,
 		TotalOutputMessages: a.totalOutputMessages,
-	}
-}
+		DroppedRecords:      a.droppedRecords,
 
-// ---- helpers ----
+		DuplicateInputMessages:  a.duplicateInputMessages,
+		DuplicateOutputMessages: a.duplicateOutputMessages,
+		Retransmissions:         a.duplicates[DuplicateRetransmission],
+		Resends:                 a.duplicates[DuplicateResend],
 
-func isDigits(s string) bool {
-	for _, b := range s {
-		if b < '0' || b > '9' {
-			return false
-		}
+		ByPath: byPath,
 	}
-	return true
 }
 
+// ---- helpers ----
+
 func isLikelyISO8583(data []byte) bool {
 	if len(data) < 4 {
 		return false
@@ -110,72 +296,147 @@ func extractKey(payload []byte) (string, error) {
 	return fmt.Sprintf("%s_%s_%s", mti, pan, proc), nil
 }
 
+// ServerName finds the configured server on either end of the flow,
+// preferring an ip+port match over an ip-only match.
+func ServerName(servers []config.Server, rec *Record) string {
+	ipOnly := ""
+	for _, s := range servers {
+		ip := net.ParseIP(strings.TrimSpace(s.IP))
+		if ip == nil {
+			continue
+		}
+		for _, end := range []struct {
+			ip   string
+			port int
+		}{{rec.SrcIP, rec.SrcPort}, {rec.DstIP, rec.DstPort}} {
+			if !ip.Equal(net.ParseIP(end.ip)) {
+				continue
+			}
+			for _, p := range s.Ports {
+				if p == end.port {
+					return s.Name
+				}
+			}
+			if ipOnly == "" {
+				ipOnly = s.Name
+			}
+		}
+	}
+	return ipOnly
+}
+
 // ---- stream types ----
 
+// isoStream receives reassembled bytes directly from the assembler, so every
+// message keeps the capture timestamp of the packet that completed it.
 type isoStream struct {
 	net, transport gopacket.Flow
-	reader         tcpreader.ReaderStream
+	buffer         []byte
 	srcIP          string
 	dstIP          string
+	srcPort        int
+	dstPort        int
 
 	fwIP string
 	agg  *Aggregator
+	path crossnet.Path
+
+	resyncing bool // skipping bytes until a valid header
+}
+
+func (h *isoStream) Reassembled(reassemblies []tcpassembly.Reassembly) {
+	for _, r := range reassemblies {
+		if r.Skip != 0 {
+			// bytes lost; drop the partial frame and resync on the next header
+			if len(h.buffer) > 0 {
+				h.agg.framingErrors.Add(1)
+			}
+			h.buffer = h.buffer[:0]
+		}
+		h.agg.bytesReassembled.Add(int64(len(r.Bytes)))
+		h.buffer = append(h.buffer, r.Bytes...)
+		h.drain(r.Seen)
+	}
+}
+
+func (h *isoStream) ReassemblyComplete() {
+	h.agg.activeStreams.Add(-1)
 }
 
-func (h *isoStream) run() {
-	buffer := make([]byte, 0)
-	tmp := make([]byte, 4096)
+// resync drops one byte; a run of dropped bytes counts as one framing error.
+func (h *isoStream) resync() {
+	if !h.resyncing {
+		h.resyncing = true
+		h.agg.framingErrors.Add(1)
+	}
+	h.agg.resyncBytes.Add(1)
+	h.buffer = h.buffer[1:]
+}
 
+func (h *isoStream) drain(seen time.Time) {
+	hdr := framing.Default
 	for {
-		n, err := h.reader.Read(tmp)
-		if n > 0 {
-			buffer = append(buffer, tmp[:n]...)
-			for {
-				if len(buffer) < 4 {
-					break
-				}
-				if !isDigits(string(buffer[:4])) {
-					buffer = buffer[1:]
-					continue
-				}
-				length, err := strconv.Atoi(string(buffer[:4]))
-				if err != nil || length <= 0 || len(buffer) < 4+length {
-					break
-				}
-				msg := buffer[4 : 4+length]
-				buffer = buffer[4+length:]
+		length, ok, need := hdr.Length(h.buffer)
+		if need {
+			return
+		}
+		if !ok {
+			h.resync()
+			continue
+		}
+		if len(h.buffer) < hdr.Size()+length {
+			return
+		}
+		h.resyncing = false
+		msg := h.buffer[hdr.Size() : hdr.Size()+length]
+		h.buffer = h.buffer[hdr.Size()+length:]
 
-				if !isLikelyISO8583(msg) {
-					continue
-				}
+		if !isLikelyISO8583(msg) {
+			h.agg.framingErrors.Add(1)
+			continue
+		}
 
-				key, err := extractKey(msg)
-				if err != nil {
-					key = "[key-error]"
-				}
-				timestamp := time.Now().Format(time.RFC3339Nano)
-				row := []string{timestamp, key}
-
-				// تجمیع بر اساس جهت
-				if h.dstIP == h.fwIP {
-					h.agg.addInputRow(row)
-				} else if h.srcIP == h.fwIP {
-					h.agg.addOutputRow(row)
-				}
-			}
+		key, err := extractKey(msg)
+		if err != nil {
+			key = "[key-error]"
+		}
+		rec := Record{
+			Time:    seen,
+			SrcIP:   h.srcIP,
+			SrcPort: h.srcPort,
+			DstIP:   h.dstIP,
+			DstPort: h.dstPort,
+			Key:     key,
+			Raw:     append([]byte(nil), msg...),
+			Path:    h.path.Name,
+			PathOID: h.path.OID,
 		}
-		if err == io.EOF {
-			break
+		rec.Msg, rec.ParseErr = parser.Parse(msg)
+		if h.dstIP != h.fwIP && h.srcIP != h.fwIP {
+			continue
 		}
+		rec.Duplicate = h.agg.checkDuplicate(&rec, msg)
+		row := []string{seen.Format(time.RFC3339Nano), key, string(rec.Duplicate)}
+		dup := rec.Duplicate != ""
 
+		// تجمیع بر اساس جهت
+		if h.dstIP == h.fwIP {
+			rec.Direction = DirectionInput
+			h.agg.addInputRow(row, dup)
+		} else {
+			rec.Direction = DirectionOutput
+			h.agg.addOutputRow(row, dup)
+		}
+		h.agg.addRecord(rec)
 	}
 }
 
 // ---- factory ----
 
 type isoFactory struct {
-	fwIP string
-	agg  *Aggregator
+	fwIP    string
+	agg     *Aggregator
+	labeler *crossnet.Labeler
 }
 
 func NewFactory(fwIP string, agg *Aggregator) *isoFactory {
@@ -185,19 +446,27 @@ func NewFactory(fwIP string, agg *Aggregator) *isoFactory {
 	}
 }
 
+// SetFWIP changes the firewall IP for streams created from now on; running
+// streams keep theirs.
+func (f *isoFactory) SetFWIP(fwIP string) { f.fwIP = fwIP }
+
+// SetLabeler tags streams created from now on with their crossnetwork
+// path; nil disables labeling.
+func (f *isoFactory) SetLabeler(l *crossnet.Labeler) { f.labeler = l }
+
 func (f *isoFactory) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
-	r := tcpreader.NewReaderStream()
-	src := net.IP(netFlow.Src().Raw()).String()
-	dst := net.IP(netFlow.Dst().Raw()).String()
+	f.agg.flows.Add(1)
+	f.agg.activeStreams.Add(1)
 	h := &isoStream{
 		net:       netFlow,
 		transport: tcpFlow,
-		reader:    r,
-		srcIP:     src,
-		dstIP:     dst,
+		srcIP:     net.IP(netFlow.Src().Raw()).String(),
+		dstIP:     net.IP(netFlow.Dst().Raw()).String(),
+		srcPort:   int(binary.BigEndian.Uint16(tcpFlow.Src().Raw())),
+		dstPort:   int(binary.BigEndian.Uint16(tcpFlow.Dst().Raw())),
 		fwIP:      f.fwIP,
 		agg:       f.agg,
 	}
-	go h.run()
-	return &r
+	h.path, _ = f.labeler.Label(h.srcIP, h.dstIP)
+	return h
 }
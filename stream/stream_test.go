@@ -0,0 +1,134 @@
+package stream_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msn60/isotcpdump/pcapgen"
+	"github.com/msn60/isotcpdump/pcapgen/pcapgentest"
+	"github.com/msn60/isotcpdump/stream"
+)
+
+const messages = pcapgentest.Messages
+
+// capture runs the generated conversation with faults into an aggregator.
+func capture(t *testing.T, faults pcapgen.Faults) (*pcapgen.Stats, *stream.IsoStreamResponse) {
+	t.Helper()
+	agg := stream.NewAggregator(1000).WithDuplicateWindow(30 * time.Second)
+	gs := pcapgentest.Capture(t, faults, agg)
+	return gs, agg.Snapshot()
+}
+
+// every message intact: one per direction and request, no duplicates or
+// framing errors
+func wantAll(t *testing.T, s *stream.IsoStreamResponse) {
+	t.Helper()
+	if s.TotalInputMessages != messages || s.TotalOutputMessages != messages {
+		t.Errorf("messages: input %d, output %d, want %d each", s.TotalInputMessages, s.TotalOutputMessages, messages)
+	}
+	if s.DuplicateInputMessages+s.DuplicateOutputMessages != 0 {
+		t.Errorf("duplicates: input %d, output %d, want none", s.DuplicateInputMessages, s.DuplicateOutputMessages)
+	}
+}
+
+func wantNoFramingErrors(t *testing.T, s *stream.IsoStreamResponse) {
+	t.Helper()
+	if s.FramingErrors != 0 || s.ResyncBytes != 0 {
+		t.Errorf("framing errors %d, resync bytes %d, want none", s.FramingErrors, s.ResyncBytes)
+	}
+}
+
+func TestClean(t *testing.T) {
+	_, s := capture(t, pcapgen.Faults{})
+	wantAll(t, s)
+	wantNoFramingErrors(t, s)
+	if s.Flows != 2 || s.PayloadPackets != 2*messages {
+		t.Errorf("flows %d, payload packets %d, want 2 and %d", s.Flows, s.PayloadPackets, 2*messages)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		faults pcapgen.Faults
+		check  func(*testing.T, *pcapgen.Stats, *stream.IsoStreamResponse)
+	}{
+		{"loss", pcapgen.Faults{Loss: 0.02}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Lost == 0 {
+				t.Fatal("no segment lost")
+			}
+			// every message fits one segment, so each loss is one message
+			if got, want := s.TotalInputMessages+s.TotalOutputMessages, 2*messages-gs.Lost; got != want {
+				t.Errorf("messages %d, want %d", got, want)
+			}
+			wantNoFramingErrors(t, s)
+		}},
+		{"reorder", pcapgen.Faults{Reorder: 0.1}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Reordered == 0 {
+				t.Fatal("no segment reordered")
+			}
+			wantAll(t, s)
+			wantNoFramingErrors(t, s)
+		}},
+		{"retransmit", pcapgen.Faults{Retransmit: 0.1}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Retransmitted == 0 {
+				t.Fatal("no segment retransmitted")
+			}
+			// the assembler drops data it already has
+			wantAll(t, s)
+			wantNoFramingErrors(t, s)
+			if want := int64(2*messages + gs.Retransmitted); s.PayloadPackets != want {
+				t.Errorf("payload packets %d, want %d", s.PayloadPackets, want)
+			}
+		}},
+		{"split", pcapgen.Faults{Split: 0.3}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Split == 0 {
+				t.Fatal("no message split")
+			}
+			wantAll(t, s)
+			wantNoFramingErrors(t, s)
+			if want := int64(2*messages + gs.Split); s.PayloadPackets != want {
+				t.Errorf("payload packets %d, want %d", s.PayloadPackets, want)
+			}
+		}},
+		{"merge", pcapgen.Faults{Merge: 0.2}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Merged == 0 {
+				t.Fatal("no request merged")
+			}
+			wantAll(t, s)
+			wantNoFramingErrors(t, s)
+			if want := int64(2*messages - gs.Merged); s.PayloadPackets != want {
+				t.Errorf("payload packets %d, want %d", s.PayloadPackets, want)
+			}
+		}},
+		{"reset", pcapgen.Faults{Reset: 0.1}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Resets == 0 {
+				t.Fatal("no connection reset")
+			}
+			wantAll(t, s)
+			wantNoFramingErrors(t, s)
+			if want := int64(2 * gs.Connections); s.Flows != want {
+				t.Errorf("flows %d, want %d", s.Flows, want)
+			}
+		}},
+		{"garbage", pcapgen.Faults{Garbage: 0.1}, func(t *testing.T, gs *pcapgen.Stats, s *stream.IsoStreamResponse) {
+			if gs.Garbage == 0 {
+				t.Fatal("no garbage written")
+			}
+			// each run of garbage is one framing error, then a resync
+			wantAll(t, s)
+			if s.FramingErrors != int64(gs.Garbage) {
+				t.Errorf("framing errors %d, want %d", s.FramingErrors, gs.Garbage)
+			}
+			if s.ResyncBytes < int64(gs.Garbage) {
+				t.Errorf("resync bytes %d, want at least %d", s.ResyncBytes, gs.Garbage)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs, s := capture(t, tt.faults)
+			tt.check(t, gs, s)
+		})
+	}
+}